@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// buildContainerImage builds the image stage by stage, checks its size, smoke tests it, pushes the dlc cache tags and scans the final image for vulnerabilities
+func buildContainerImage(ctx context.Context, credentials []ContainerRegistryCredentials, image buildImage, version, versionTag, versionTagSuffix string) error {
+
+	// use the git commit time for everything that would otherwise differ between builds
+	var sourceDateEpoch int64
+	if *reproducible {
+		var err error
+		sourceDateEpoch, err = getSourceDateEpoch(ctx)
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("Building reproducibly with SOURCE_DATE_EPOCH %v", sourceDateEpoch)
+	}
+
+	// make build dir if it doesn't exist; a remote build context gets fetched by docker, with the Dockerfile sent from a local directory
+	expandedPath := os.ExpandEnv(image.Path)
+	isRemoteContext := isRemoteBuildContext(expandedPath)
+	dockerfileDirectory := expandedPath
+	if isRemoteContext {
+		if len(image.Copy) > 0 {
+			return fmt.Errorf("Copy can't be used with remote build context %v", expandedPath)
+		}
+		log.Info().Msgf("Using remote build context %v", expandedPath)
+		if *dryRun {
+			dockerfileDirectory = filepath.Join(os.TempDir(), "estafette-dockerfile")
+			plan.addStep(fmt.Sprintf("create directory %v for Dockerfile", dockerfileDirectory), "", nil)
+		} else {
+			var err error
+			dockerfileDirectory, err = os.MkdirTemp("", "estafette-dockerfile-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dockerfileDirectory)
+		}
+	} else {
+		log.Info().Msgf("Ensuring build directory %v exists", expandedPath)
+		if ok, _ := pathExists(expandedPath); !ok {
+			if *dryRun {
+				plan.addStep(fmt.Sprintf("create build directory %v", expandedPath), "", nil)
+			} else {
+				err := os.MkdirAll(expandedPath, os.ModePerm)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// copy files/dirs matching the copy entries to build path, skipping the build path itself when it's inside a matched directory
+	copyEntries, copyExcludes := parseCopyEntries(image.Copy)
+	if len(copyEntries) > 0 && filepath.Clean(expandedPath) != "." && !filepath.IsAbs(expandedPath) {
+		copyExcludes = append(copyExcludes, filepath.ToSlash(filepath.Clean(expandedPath)))
+	}
+	for _, c := range copyEntries {
+
+		files, err := getCopyFiles(c, copyExcludes)
+		if err != nil {
+			return err
+		}
+		if *dryRun {
+			for _, f := range files {
+				plan.addStep(fmt.Sprintf("copy %v to %v", f.source, filepath.Join(expandedPath, f.destination)), "", nil)
+			}
+			if *reproducible {
+				for _, r := range getCopyRoots(files) {
+					plan.addStep(fmt.Sprintf("normalize timestamps and ownership of %v", filepath.Join(expandedPath, r)), "", nil)
+				}
+			}
+			continue
+		}
+
+		log.Info().Msgf("Copying %v files for %v to %v", len(files), c.source, expandedPath)
+		for _, f := range files {
+			log.Info().Msgf("- %v -> %v", f.source, f.destination)
+		}
+		err = copyFilesToBuildDirectory(files, expandedPath)
+		if err != nil {
+			return err
+		}
+
+		if *reproducible {
+			for _, r := range getCopyRoots(files) {
+				err = normalizeFiles(filepath.Join(expandedPath, r), time.Unix(sourceDateEpoch, 0))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	dockerFileExpandedPath := os.ExpandEnv(image.Dockerfile)
+	targetDockerfilePath := filepath.Join(dockerfileDirectory, filepath.Base(dockerFileExpandedPath))
+
+	sourceDockerfile, sourceDockerfilePath, err := readSourceDockerfile(image.Inline, dockerFileExpandedPath)
+	if err != nil {
+		return err
+	}
+
+	secretValues := getSecretValues(credentials)
+	targetDockerfile := sourceDockerfile
+	if image.Template == "gotemplate" {
+		log.Info().Msgf("Rendering Dockerfile template %v...", sourceDockerfilePath)
+		targetDockerfile, err = renderDockerfileTemplate(targetDockerfile, sourceDockerfilePath, newDockerfileTemplateData(image.Container, image.Labels, strings.Split(*templateEnv, ",")))
+		if err != nil {
+			return err
+		}
+		err = checkRenderedDockerfile(sourceDockerfile, targetDockerfile, secretValues)
+		if err != nil {
+			return err
+		}
+	}
+	if syntax := getDockerfileSyntax(targetDockerfile); syntax != "" {
+		log.Info().Msgf("Dockerfile declares syntax frontend %v", syntax)
+	}
+	if *expandEnvironmentVariables {
+		log.Print("Expanding environment variables in Dockerfile...")
+		expandedDockerfile := expandEnvironmentVariablesIfSet(targetDockerfile, dontExpand)
+		err = checkDockerfileVariables(targetDockerfile, expandedDockerfile, secretValues)
+		if err != nil {
+			return err
+		}
+		targetDockerfile = expandedDockerfile
+	}
+
+	if *dryRun {
+		plan.addStep(fmt.Sprintf("write Dockerfile to %v", targetDockerfilePath), "", nil)
+	} else {
+		log.Info().Msgf("Writing Dockerfile to %v...", targetDockerfilePath)
+		err = os.WriteFile(targetDockerfilePath, []byte(targetDockerfile), 0644)
+		if err != nil {
+			return err
+		}
+		if *reproducible {
+			err = normalizeFiles(targetDockerfilePath, time.Unix(sourceDateEpoch, 0))
+			if err != nil {
+				return err
+			}
+		}
+
+	}
+
+	if !*dryRun && !isRemoteContext {
+		// list directory content
+		log.Info().Msgf("Listing directory %v content", expandedPath)
+		files, err := os.ReadDir(expandedPath)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if f.IsDir() {
+				log.Info().Msgf("- %v/", f.Name())
+			} else {
+				log.Info().Msgf("- %v", f.Name())
+			}
+		}
+
+		err = checkBuildContext(expandedPath, targetDockerfilePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// find all images in FROM statements in dockerfile
+	fromImagePaths, err := getFromImagePathsFromDockerfile(targetDockerfile)
+	if err != nil {
+		return err
+	}
+
+	if len(fromImagePaths) == 0 {
+
+		log.Info().Msgf("%v (as string):", sourceDockerfilePath)
+		fmt.Println(maskSecrets(targetDockerfile, secretValues))
+		log.Info().Msg("")
+
+		log.Info().Msgf("%v (as bytes):", sourceDockerfilePath)
+		data, _ := os.ReadFile(sourceDockerfilePath)
+		fmt.Println(data)
+
+		return fmt.Errorf("Failed detecting image paths in FROM statements")
+	}
+
+	buildArgs, err := getBuildArgs(image.Args, image.ArgsFile, newTemplateData(image.Container))
+	if err != nil {
+		return err
+	}
+	// stages after the target don't need building
+	buildStages := fromImagePaths
+	if image.Target != "" {
+		targetIndex, err := getStageIndex(fromImagePaths, image.Target)
+		if err != nil {
+			return err
+		}
+		buildStages = fromImagePaths[:targetIndex+1]
+	}
+
+	warnings, err := checkBuildArgs(buildArgs, getDockerfileArgs(targetDockerfile, len(buildStages)))
+	for _, w := range warnings {
+		log.Warn().Msg(w)
+	}
+	if err != nil {
+		return err
+	}
+
+	// add standard labels, which win over the ones set in the Dockerfile
+	created := newTemplateData(image.Container).BuildDate
+	if *reproducible {
+		created = time.Unix(sourceDateEpoch, 0)
+	}
+	standardLabels := getStandardLabels(*gitSource, *gitOwner, *gitName, os.Getenv("ESTAFETTE_GIT_REVISION"), os.Getenv("ESTAFETTE_BUILD_VERSION"), image.Container, created)
+	if overridden := getOverriddenReservedLabels(targetDockerfile, standardLabels); len(overridden) > 0 {
+		if *failOnReservedLabelOverride {
+			return fmt.Errorf("The Dockerfile sets reserved labels %v, remove them since they're set automatically", strings.Join(overridden, ", "))
+		}
+		log.Warn().Msgf("The Dockerfile sets reserved labels %v, they're overridden with the automatically set values", strings.Join(overridden, ", "))
+	}
+	labelArgs := getLabelBuildArgs(mergeStringMaps(standardLabels, image.Labels), image.Annotations)
+
+	contextArgs, err := getBuildContextArgs(image.Contexts)
+	if err != nil {
+		return err
+	}
+	for _, i := range getBuildContextImages(image.Contexts) {
+		err = loginIfRequiredExtended(ctx, credentials, false, i)
+		if err != nil {
+			return err
+		}
+	}
+
+	// pull images in advance, so we can log in to different repositories in the same registry (see https://github.com/moby/moby/issues/37569); named build contexts replace the image they're named after
+	for _, i := range fromImagePaths {
+		if _, isContext := image.Contexts[i.imagePath]; i.isOfficialDockerHubImage || isContext {
+			continue
+		}
+		err = loginIfRequiredExtended(ctx, credentials, false, i.imagePath)
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("Pulling container image %v", i.imagePath)
+		pullArgs := []string{"pull"}
+		// a platform with variables like $BUILDPLATFORM only resolves during the build
+		if i.platform != "" && !strings.Contains(i.platform, "$") {
+			pullArgs = append(pullArgs, "--platform", i.platform)
+		}
+		pullArgs = append(pullArgs, i.imagePath)
+		err = runDockerCommandWithRetryExtended(ctx, "pull", pullArgs, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	// login to registry for destination container image
+	containerPath := fmt.Sprintf("%v/%v:%v", image.Repositories[0], image.Container, versionTag)
+	err = loginIfRequiredExtended(ctx, credentials, !*noCachePush, containerPath)
+	if err != nil {
+		return err
+	}
+
+	// fill the RUN --mount=type=cache mounts with the contents stored by a previous build, exporting them afterwards if the key didn't match
+	var exportMounts []cacheMount
+	cacheMountsKey := ""
+	if *cacheMountsLocation != "" && !*noCache && runtime.GOOS != "windows" {
+		mounts := getCacheMounts(targetDockerfile)
+		if len(mounts) > 0 {
+			cacheMountsKey, err = getCacheMountsKey(image.Container, mounts, expandedPath, getCacheMountsKeyFiles(*cacheMountsKeyFiles))
+			if err != nil {
+				return err
+			}
+			isKeyMatch, err := importCacheMounts(ctx, image, *cacheMountsLocation, mounts, cacheMountsKey)
+			if err != nil {
+				log.Warn().Err(err).Msg("Importing cache mounts failed, building without them")
+			}
+			if !isKeyMatch && !*noCachePush {
+				exportMounts = mounts
+			}
+		}
+	}
+
+	// build docker image
+	log.Info().Msgf("Building docker image %v...", containerPath)
+
+	log.Info().Msg("")
+	fmt.Println(maskSecrets(targetDockerfile, secretValues))
+	log.Info().Msg("")
+
+	// build every layer separately and push it to registry afterwards to be used as cache next time
+	var dockerLayerCachingPaths []string
+	var cachePushPaths []string
+	for index, i := range buildStages {
+		isFinalLayer := index == len(buildStages)-1
+		isCacheable := !*noCache && runtime.GOOS != "windows"
+		dockerLayerCachingTag := "dlc"
+
+		if !isFinalLayer {
+			if i.stageName == "" || !isCacheable {
+				// skip building intermediate layers for caching
+				continue
+			}
+			log.Info().Msgf("Building layer %v...", i.stageName)
+			dockerLayerCachingTag = tidyTag(fmt.Sprintf("dlc-%v", i.stageName))
+		} else if image.Target != "" {
+			// the target stage has its own cache tag, so it doesn't replace the cache of the full image
+			dockerLayerCachingTag = tidyTag(fmt.Sprintf("dlc-%v", i.stageName))
+		}
+
+		dockerLayerCachingPath := fmt.Sprintf("%v/%v:%v", image.Repositories[0], image.Container, dockerLayerCachingTag)
+		dockerLayerCachingPaths = append(dockerLayerCachingPaths, dockerLayerCachingPath)
+
+		args := []string{
+			"build",
+		}
+
+		if isCacheable {
+			args = append(args, "--build-arg", "BUILDKIT_INLINE_CACHE=1")
+			// cache from remote image
+			for _, cf := range dockerLayerCachingPaths {
+				args = append(args, "--cache-from", cf)
+			}
+			args = append(args, "--tag", dockerLayerCachingPath)
+		} else {
+			// disable use of local layer cache
+			args = append(args, "--no-cache")
+		}
+
+		if isFinalLayer {
+			for _, r := range image.Repositories {
+				args = append(args, "--tag", fmt.Sprintf("%v/%v:%v", r, image.Container, versionTag))
+				for _, t := range image.Tags {
+					if r == image.Repositories[0] && (t == versionTag || t == dockerLayerCachingTag) {
+						continue
+					}
+					args = append(args, "--tag", fmt.Sprintf("%v/%v:%v", r, image.Container, t))
+				}
+			}
+			args = append(args, labelArgs...)
+			if image.Target != "" {
+				args = append(args, "--target", i.stageName)
+			}
+		} else {
+			args = append(args, "--target", i.stageName)
+		}
+
+		// add optional build args
+		args = append(args, getBuildArgArgs(buildArgs)...)
+		args = append(args, contextArgs...)
+
+		if *reproducible {
+			args = append(args, getReproducibleBuildArgs(sourceDateEpoch, isFinalLayer)...)
+		}
+
+		args = append(args, "--file", targetDockerfilePath)
+		args = append(args, expandedPath)
+		description := "docker build"
+		if !isFinalLayer {
+			description = fmt.Sprintf("docker build stage %v", i.stageName)
+		}
+		err = runCommandExtended(ctx, description, "docker", args)
+		if err != nil {
+			return fmt.Errorf("building %v failed: %w", dockerLayerCachingPath, err)
+		}
+
+		if isFinalLayer && *verifyReproducible {
+			err = verifyReproducibleBuild(ctx, args, containerPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		if isCacheable && !*noCachePush {
+			cachePushPaths = append(cachePushPaths, dockerLayerCachingPath)
+		}
+	}
+
+	if len(image.Outputs) > 0 {
+		err = exportBuildOutputs(ctx, image, fromImagePaths, targetDockerfile, targetDockerfilePath, expandedPath, dockerLayerCachingPaths, buildArgs, contextArgs)
+		if err != nil {
+			return err
+		}
+	}
+
+	budgets, err := image.sizeBudgets()
+	if err != nil {
+		return err
+	}
+	if budgets.isSet() {
+		err = checkImageSize(ctx, credentials, image, containerPath, version, versionTag, versionTagSuffix, budgets)
+		if err != nil {
+			return err
+		}
+	}
+
+	// run the image before pushing cache, so a broken image doesn't end up as cache for the next build
+	if image.SmokeTest != nil {
+		err = runSmokeTest(ctx, containerPath, *image.SmokeTest)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(image.Tests) > 0 {
+		err = runStructureTestsOnImage(ctx, image.Container, containerPath, image.Tests, *testsReportPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range cachePushPaths {
+		log.Info().Msgf("Pushing cache container image %v", p)
+		pushArgs := []string{
+			"push",
+			p,
+		}
+		err = runDockerCommandWithRetryExtended(ctx, "push", pushArgs, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(exportMounts) > 0 {
+		err = exportCacheMounts(ctx, image, *cacheMountsLocation, exportMounts, cacheMountsKey)
+		if err != nil {
+			log.Warn().Err(err).Msg("Exporting cache mounts failed, the next build runs without them")
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	return scanContainerImage(ctx, credentials, image, containerPath)
+}
+
+// scanContainerImage scans the built image with trivy and returns an error if it has vulnerabilities of at least the minimum severity
+func scanContainerImage(ctx context.Context, credentials []ContainerRegistryCredentials, image buildImage, containerPath string) error {
+
+	// map severity param value to trivy severity
+	severityArgument := "UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
+	switch strings.ToUpper(*minimumSeverityToFail) {
+	case "UNKNOWN":
+		severityArgument = "UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
+	case "LOW":
+		severityArgument = "LOW,MEDIUM,HIGH,CRITICAL"
+	case "MEDIUM":
+		severityArgument = "MEDIUM,HIGH,CRITICAL"
+	case "HIGH":
+		severityArgument = "HIGH,CRITICAL"
+	case "CRITICAL":
+		severityArgument = "CRITICAL"
+	}
+
+	// set JavaDB repositories for fallback scenarios (e.g. rate limiting failure)
+	javaDbRepositories := "public.ecr.aws/aquasecurity/trivy-java-db:1,aquasec/trivy-java-db:1,ghcr.io/aquasecurity/trivy-java-db:1"
+
+	log.Info().Msg("Saving docker image to file for scanning...")
+	tmpfilePath := filepath.Join(os.TempDir(), "image.tar")
+	if !*dryRun {
+		tmpfile, err := os.CreateTemp("", "*.tar")
+		if err != nil {
+			return fmt.Errorf("Failed creating temporary file: %w", err)
+		}
+		tmpfilePath = tmpfile.Name()
+	}
+
+	// Download Trivy db and save it to path /trivy-cache
+	err := refreshTrivyDB(ctx, credentials, image)
+	if err != nil {
+		return err
+	}
+	err = checkTrivyDBFreshness(trivyCacheDirectory, time.Duration(*trivyDBMaxAgeDays)*24*time.Hour, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	err = runCommandExtended(ctx, "docker save", "docker", []string{"save", containerPath, "-o", tmpfilePath})
+	if err != nil {
+		return err
+	}
+
+	// remove .trivyignore file so devs can't game the system
+	// if foundation.FileExists(".trivyignore") {
+	// 	err = os.Remove(".trivyignore")
+	// 	if err != nil {
+	// 		log.Fatal().Msg("Could not remove .trivyignore file")
+	// 	}
+	// }
+	err = runCommandExtended(ctx, "print trivy version", "/trivy", []string{"-v"})
+	if err != nil {
+		return fmt.Errorf("Error printing trivy version: %q", err)
+	}
+
+	log.Info().Msgf("Scanning container image %v for vulnerabilities of severities %v...", containerPath, severityArgument)
+	err = runCommandExtended(ctx, "scan for vulnerabilities", "/trivy", []string{"--cache-dir", trivyCacheDirectory, "--timeout", "20m", "image", "--severity", severityArgument, "--scanners", "vuln", "--skip-db-update", "--no-progress", "--exit-code", "15", "--ignore-unfixed", "--java-db-repository", javaDbRepositories, "--input", tmpfilePath})
+	if err != nil {
+		return fmt.Errorf("The container image has vulnerabilities of severity %v! Look at https://estafette.io/usage/fixing-vulnerabilities/ to learn how to fix vulnerabilities in your image.", severityArgument)
+	}
+
+	return nil
+}
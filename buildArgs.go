@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	dockerfileArgRegex  = regexp.MustCompile(`(?mi)^\s*ARG\s+(.+)$`)
+	dockerfileFromRegex = regexp.MustCompile(`(?i)^\s*FROM\s`)
+)
+
+// buildArg is a build argument passed to docker build
+type buildArg struct {
+	name  string
+	value string
+}
+
+// dockerfileArg is an ARG declaration in the Dockerfile
+type dockerfileArg struct {
+	name       string
+	hasDefault bool
+}
+
+// renderBuildArgValue renders a value containing {{ }} as template with the same data and functions as tag templates
+func renderBuildArgValue(name, value string, data templateData) (string, error) {
+
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid template for build arg %v: %w", name, err)
+	}
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, data)
+	if err != nil {
+		return "", fmt.Errorf("failed rendering template for build arg %v: %w", name, err)
+	}
+
+	return buffer.String(), nil
+}
+
+// parseArgsFile parses a yaml file with a map of build args or a dotenv file with NAME=value lines
+func parseArgsFile(argsFilePath string) ([]buildArg, error) {
+
+	data, err := os.ReadFile(argsFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading args file %v: %w", argsFilePath, err)
+	}
+
+	var args []buildArg
+	switch strings.ToLower(filepath.Ext(argsFilePath)) {
+	case ".yaml", ".yml":
+		var values map[string]string
+		err = yaml.Unmarshal(data, &values)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing args file %v: %w", argsFilePath, err)
+		}
+		for _, name := range sortedKeys(values) {
+			args = append(args, buildArg{name: name, value: values[name]})
+		}
+
+	default:
+		for i, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+			if !ok {
+				return nil, fmt.Errorf("line %v of args file %v isn't in NAME=value format", i+1, argsFilePath)
+			}
+			value = strings.TrimSpace(value)
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+				value = value[1 : len(value)-1]
+			}
+			args = append(args, buildArg{name: strings.TrimSpace(name), value: value})
+		}
+	}
+
+	return args, nil
+}
+
+// getBuildArgs resolves the args file and args into build args; args win over the args file, NAME=value args are literals rendered as template and plain names take the value of that environment variable, if set; environment variable and args file values are used as is
+func getBuildArgs(args []string, argsFilePath string, data templateData) ([]buildArg, error) {
+
+	var buildArgs []buildArg
+	if argsFilePath != "" {
+		fileArgs, err := parseArgsFile(argsFilePath)
+		if err != nil {
+			return nil, err
+		}
+		buildArgs = append(buildArgs, fileArgs...)
+	}
+
+	for _, a := range args {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		name, value, isLiteral := strings.Cut(a, "=")
+		if isLiteral {
+			var err error
+			value, err = renderBuildArgValue(name, value, data)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			envValue, ok := os.LookupEnv(name)
+			if !ok {
+				log.Warn().Msgf("Environment variable %v for build arg %v isn't set, skipping it so the default in the Dockerfile applies", name, name)
+				continue
+			}
+			value = envValue
+		}
+		buildArgs = append(buildArgs, buildArg{name: name, value: value})
+	}
+
+	// the last value for a name wins
+	resolved := []buildArg{}
+	indexes := map[string]int{}
+	for _, a := range buildArgs {
+		if i, ok := indexes[a.name]; ok {
+			resolved[i].value = a.value
+			continue
+		}
+		indexes[a.name] = len(resolved)
+		resolved = append(resolved, a)
+	}
+
+	return resolved, nil
+}
+
+// getDockerfileArgs returns the ARG declarations in the global scope and the first stageCount stages of the Dockerfile, with hasDefault set if any declaration of the name has a default value; later stages don't get built
+func getDockerfileArgs(dockerfile string, stageCount int) []dockerfileArg {
+
+	dockerfile = strings.ReplaceAll(removeHeredocBodies(dockerfile), "\\\n", " ")
+
+	var lines []string
+	stages := 0
+	for _, line := range strings.Split(dockerfile, "\n") {
+		if dockerfileFromRegex.MatchString(line) {
+			stages++
+			if stages > stageCount {
+				break
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	var args []dockerfileArg
+	indexes := map[string]int{}
+	for _, m := range dockerfileArgRegex.FindAllStringSubmatch(strings.Join(lines, "\n"), -1) {
+		for _, field := range splitQuotedFields(m[1]) {
+			name, _, hasDefault := strings.Cut(field, "=")
+			if i, ok := indexes[name]; ok {
+				args[i].hasDefault = args[i].hasDefault || hasDefault
+				continue
+			}
+			indexes[name] = len(args)
+			args = append(args, dockerfileArg{name: name, hasDefault: hasDefault})
+		}
+	}
+
+	return args
+}
+
+// isPredefinedBuildArg returns true for build args docker sets or accepts without ARG declaration
+func isPredefinedBuildArg(name string) bool {
+	return strings.HasPrefix(name, "BUILDKIT_") || name == "SOURCE_DATE_EPOCH" || predefinedDockerfileVarsMap[strings.ToUpper(name)]
+}
+
+// checkBuildArgs returns warnings for build args the Dockerfile doesn't declare and an error for declared args without default that aren't supplied
+func checkBuildArgs(buildArgs []buildArg, dockerfileArgs []dockerfileArg) ([]string, error) {
+
+	supplied := map[string]bool{}
+	for _, a := range buildArgs {
+		supplied[a.name] = true
+	}
+	declared := map[string]bool{}
+	var missing []string
+	for _, a := range dockerfileArgs {
+		declared[a.name] = true
+		if !a.hasDefault && !supplied[a.name] && !isPredefinedBuildArg(a.name) {
+			missing = append(missing, a.name)
+		}
+	}
+
+	var warnings []string
+	for _, a := range buildArgs {
+		if !declared[a.name] && !isPredefinedBuildArg(a.name) {
+			warnings = append(warnings, fmt.Sprintf("Build arg %v isn't declared with ARG in the Dockerfile and won't be used", a.name))
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return warnings, fmt.Errorf("The Dockerfile requires build args %v without default value; set them in args or argsFile", strings.Join(missing, ", "))
+	}
+
+	return warnings, nil
+}
+
+// getBuildArgArgs returns the --build-arg arguments for docker build
+func getBuildArgArgs(buildArgs []buildArg) []string {
+	var args []string
+	for _, a := range buildArgs {
+		args = append(args, "--build-arg", fmt.Sprintf("%v=%v", a.name, a.value))
+	}
+	return args
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBuildArgs(t *testing.T) {
+
+	data := templateData{Branch: "feature/args", ShortRevision: "0f2c3a4", Version: "1.4.7"}
+
+	t.Run("ReturnsEnvvarValuesAndLiteralsAndRenderedTemplates", func(t *testing.T) {
+
+		os.Setenv("TEST_GO_VERSION", "1.22")
+		defer os.Unsetenv("TEST_GO_VERSION")
+
+		// act
+		buildArgs, err := getBuildArgs([]string{"TEST_GO_VERSION", "APP=estafette", "VERSION={{ .Version }}-{{ .ShortRevision }}", "EMPTY="}, "", data)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []buildArg{
+			{name: "TEST_GO_VERSION", value: "1.22"},
+			{name: "APP", value: "estafette"},
+			{name: "VERSION", value: "1.4.7-0f2c3a4"},
+			{name: "EMPTY", value: ""},
+		}, buildArgs)
+	})
+
+	t.Run("ReturnsEnvvarValuesWithoutRenderingThem", func(t *testing.T) {
+
+		os.Setenv("TEST_TEMPLATE_ARG", "{{ .Unknown }}")
+		defer os.Unsetenv("TEST_TEMPLATE_ARG")
+
+		// act
+		buildArgs, err := getBuildArgs([]string{"TEST_TEMPLATE_ARG"}, "", data)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []buildArg{{name: "TEST_TEMPLATE_ARG", value: "{{ .Unknown }}"}}, buildArgs)
+	})
+
+	t.Run("SkipsUnsetEnvvars", func(t *testing.T) {
+
+		// act
+		buildArgs, err := getBuildArgs([]string{"TEST_UNSET_ARG"}, "", data)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []buildArg{}, buildArgs)
+	})
+
+	t.Run("ReturnsArgsFileValuesOverriddenByArgsWithoutRenderingThem", func(t *testing.T) {
+
+		argsFilePath := filepath.Join(t.TempDir(), "build.env")
+		assert.Nil(t, os.WriteFile(argsFilePath, []byte("# build args\nexport APP=estafette\nVERSION=\"1.0.0\"\nBRANCH='{{ .Branch | tidyTag }}'\n"), 0644))
+
+		// act
+		buildArgs, err := getBuildArgs([]string{"VERSION=2.0.0"}, argsFilePath, data)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []buildArg{
+			{name: "APP", value: "estafette"},
+			{name: "VERSION", value: "2.0.0"},
+			{name: "BRANCH", value: "{{ .Branch | tidyTag }}"},
+		}, buildArgs)
+	})
+
+	t.Run("ReturnsArgsFromYamlFile", func(t *testing.T) {
+
+		argsFilePath := filepath.Join(t.TempDir(), "args.yaml")
+		assert.Nil(t, os.WriteFile(argsFilePath, []byte("VERSION: 1.0.0\nAPP: estafette\n"), 0644))
+
+		// act
+		buildArgs, err := getBuildArgs(nil, argsFilePath, data)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []buildArg{
+			{name: "APP", value: "estafette"},
+			{name: "VERSION", value: "1.0.0"},
+		}, buildArgs)
+	})
+
+	t.Run("ReturnsErrorForInvalidDotenvLine", func(t *testing.T) {
+
+		argsFilePath := filepath.Join(t.TempDir(), "build.env")
+		assert.Nil(t, os.WriteFile(argsFilePath, []byte("APP\n"), 0644))
+
+		// act
+		_, err := getBuildArgs(nil, argsFilePath, data)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForInvalidTemplate", func(t *testing.T) {
+
+		// act
+		_, err := getBuildArgs([]string{"VERSION={{ .Unknown }}"}, "", data)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetDockerfileArgs(t *testing.T) {
+	t.Run("ReturnsDeclaredArgsWithDefaultFromAnyDeclaration", func(t *testing.T) {
+
+		dockerfile := `ARG GO_VERSION=1.22
+FROM golang:${GO_VERSION} AS builder
+ARG GO_VERSION
+ARG VERSION \
+    APP="estafette"
+FROM scratch
+ARG TARGETARCH`
+
+		// act
+		args := getDockerfileArgs(dockerfile, 2)
+
+		assert.Equal(t, []dockerfileArg{
+			{name: "GO_VERSION", hasDefault: true},
+			{name: "VERSION", hasDefault: false},
+			{name: "APP", hasDefault: true},
+			{name: "TARGETARCH", hasDefault: false},
+		}, args)
+	})
+
+	t.Run("ReturnsGlobalArgsAndArgsOfStagesUpToTarget", func(t *testing.T) {
+
+		dockerfile := `ARG GO_VERSION=1.22
+FROM golang:${GO_VERSION} AS a
+ARG ONLY_IN_A
+FROM a AS b
+RUN <<EOF
+echo hello
+FROM not-a-stage
+EOF
+ARG ONLY_IN_B
+FROM b AS c
+ARG ONLY_IN_C`
+
+		// act
+		args := getDockerfileArgs(dockerfile, 2)
+
+		assert.Equal(t, []dockerfileArg{
+			{name: "GO_VERSION", hasDefault: true},
+			{name: "ONLY_IN_A", hasDefault: false},
+			{name: "ONLY_IN_B", hasDefault: false},
+		}, args)
+	})
+}
+
+func TestCheckBuildArgs(t *testing.T) {
+
+	dockerfileArgs := []dockerfileArg{
+		{name: "GO_VERSION", hasDefault: true},
+		{name: "VERSION"},
+		{name: "TARGETARCH"},
+	}
+
+	t.Run("ReturnsWarningsForUndeclaredArgs", func(t *testing.T) {
+
+		// act
+		warnings, err := checkBuildArgs([]buildArg{{name: "VERSION"}, {name: "UNUSED"}, {name: "BUILDKIT_INLINE_CACHE"}, {name: "http_proxy"}}, dockerfileArgs)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"Build arg UNUSED isn't declared with ARG in the Dockerfile and won't be used"}, warnings)
+	})
+
+	t.Run("ReturnsErrorForRequiredArgsNotSupplied", func(t *testing.T) {
+
+		// act
+		_, err := checkBuildArgs([]buildArg{{name: "GO_VERSION"}}, dockerfileArgs)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "requires build args VERSION without default value")
+	})
+}
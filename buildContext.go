@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	vcsDirectoryRegex    = regexp.MustCompile(`(^|/)(\.git|\.svn|\.hg)(/|$)`)
+	credentialsFileRegex = regexp.MustCompile(`(?i)(^|/)(.*\.pem|.*\.key|.*\.p12|.*\.pfx|\.env|\.env\..*|.*key-file\.json|credentials\.json|id_rsa.*|id_ed25519.*|\.npmrc|\.netrc|\.pypirc)$`)
+)
+
+// largestContextFileCount is the number of largest files in the build context that get logged
+const largestContextFileCount = 10
+
+// dockerignorePattern is a line of .dockerignore; exception patterns start with ! and include files again
+type dockerignorePattern struct {
+	pattern   string
+	regexp    *regexp.Regexp
+	exception bool
+}
+
+// contextFile is a file sent to the docker daemon as part of the build context
+type contextFile struct {
+	path string
+	size int64
+}
+
+// parseDockerignore parses the patterns in a .dockerignore file the way docker does, ignoring comments and a leading /
+func parseDockerignore(content string) ([]dockerignorePattern, error) {
+
+	var patterns []dockerignorePattern
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pattern := dockerignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			pattern.exception = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(line)), "/")
+		if line == "" || line == "." {
+			continue
+		}
+		pattern.pattern = line
+
+		r, err := globToRegexp(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid .dockerignore pattern %v: %w", line, err)
+		}
+		pattern.regexp = r
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, nil
+}
+
+// isIgnoredByDockerignore returns true if the last pattern matching the path or one of its parent directories isn't an exception
+func isIgnoredByDockerignore(path string, patterns []dockerignorePattern) bool {
+
+	ignored := false
+	for _, p := range patterns {
+		for q := path; q != "." && q != "/" && q != ""; q = filepath.ToSlash(filepath.Dir(q)) {
+			if p.regexp.MatchString(q) {
+				ignored = !p.exception
+				break
+			}
+		}
+	}
+
+	return ignored
+}
+
+// readDockerignore returns the patterns of the Dockerfile specific <Dockerfile>.dockerignore if present, otherwise of .dockerignore in the build context
+func readDockerignore(contextPath, dockerfilePath string) ([]dockerignorePattern, string, error) {
+
+	for _, p := range []string{dockerfilePath + ".dockerignore", filepath.Join(contextPath, ".dockerignore")} {
+		data, err := os.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, p, err
+		}
+		patterns, err := parseDockerignore(string(data))
+		return patterns, p, err
+	}
+
+	return nil, "", nil
+}
+
+// getBuildContextFiles returns the files in the build context that aren't ignored, with paths relative to the context
+func getBuildContextFiles(contextPath string, patterns []dockerignorePattern) ([]contextFile, error) {
+
+	hasExceptions := false
+	for _, p := range patterns {
+		hasExceptions = hasExceptions || p.exception
+	}
+
+	var files []contextFile
+	err := filepath.WalkDir(contextPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(contextPath, p)
+		if err != nil {
+			return err
+		}
+		relative = filepath.ToSlash(relative)
+		if relative == "." {
+			return nil
+		}
+
+		ignored := isIgnoredByDockerignore(relative, patterns)
+		if d.IsDir() {
+			// an exception could include files below an ignored directory again
+			if ignored && !hasExceptions {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignored {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, contextFile{path: relative, size: info.Size()})
+		return nil
+	})
+
+	return files, err
+}
+
+// analyzeBuildContext returns warnings for large files, version control directories and credential-looking files in the build context
+func analyzeBuildContext(files []contextFile, largeFileSize int64) []string {
+
+	var warnings []string
+	vcsDirectories := map[string]bool{}
+	for _, f := range files {
+		if m := vcsDirectoryRegex.FindStringSubmatch(f.path); m != nil {
+			directory := f.path[:strings.Index(f.path, m[2])+len(m[2])]
+			if !vcsDirectories[directory] {
+				vcsDirectories[directory] = true
+				warnings = append(warnings, fmt.Sprintf("version control directory %v is part of the build context, add it to .dockerignore", directory))
+			}
+			continue
+		}
+		if credentialsFileRegex.MatchString(f.path) {
+			warnings = append(warnings, fmt.Sprintf("file %v looks like it contains credentials and is part of the build context, add it to .dockerignore", f.path))
+		}
+		if largeFileSize > 0 && f.size > largeFileSize {
+			warnings = append(warnings, fmt.Sprintf("file %v of %v is larger than %v", f.path, formatByteSize(f.size), formatByteSize(largeFileSize)))
+		}
+	}
+
+	return warnings
+}
+
+// checkBuildContext logs the size of the build context after applying .dockerignore, warns about files that probably shouldn't be in it and fails if it exceeds maxContextSize
+func checkBuildContext(contextPath, dockerfilePath string) error {
+
+	maxSize, err := parseByteSize(*maxContextSize)
+	if err != nil {
+		return fmt.Errorf("invalid maxContextSize: %w", err)
+	}
+	largeFileSize, err := parseByteSize(*contextLargeFileSize)
+	if err != nil {
+		return fmt.Errorf("invalid contextLargeFileSize: %w", err)
+	}
+
+	patterns, dockerignorePath, err := readDockerignore(contextPath, dockerfilePath)
+	if err != nil {
+		return fmt.Errorf("failed reading %v: %w", dockerignorePath, err)
+	}
+	if dockerignorePath != "" {
+		log.Info().Msgf("Applying %v patterns from %v to the build context", len(patterns), dockerignorePath)
+	}
+
+	files, err := getBuildContextFiles(contextPath, patterns)
+	if err != nil {
+		return fmt.Errorf("failed listing build context %v: %w", contextPath, err)
+	}
+
+	var totalSize int64
+	for _, f := range files {
+		totalSize += f.size
+		log.Debug().Msgf("- %v (%v)", f.path, formatByteSize(f.size))
+	}
+	log.Info().Msgf("Build context %v has %v files with a total size of %v", contextPath, len(files), formatByteSize(totalSize))
+
+	largest := append([]contextFile{}, files...)
+	sort.SliceStable(largest, func(i, j int) bool {
+		return largest[i].size > largest[j].size
+	})
+	if len(largest) > largestContextFileCount {
+		largest = largest[:largestContextFileCount]
+	}
+	if len(largest) > 0 {
+		log.Info().Msgf("Largest files in the build context:")
+	}
+	for _, f := range largest {
+		log.Info().Msgf("- %v (%v)", f.path, formatByteSize(f.size))
+	}
+
+	for _, w := range analyzeBuildContext(files, largeFileSize) {
+		log.Warn().Msgf("Build context: %v", w)
+	}
+
+	if maxSize > 0 && totalSize > maxSize {
+		return fmt.Errorf("Build context %v of %v exceeds maxContextSize %v; add files that aren't needed to .dockerignore", contextPath, formatByteSize(totalSize), formatByteSize(maxSize))
+	}
+
+	return nil
+}
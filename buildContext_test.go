@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDockerignore(t *testing.T) {
+	t.Run("ReturnsPatternsWithoutCommentsAndLeadingSlash", func(t *testing.T) {
+
+		// act
+		patterns, err := parseDockerignore("# comment\n\n/node_modules\n**/*.log\n!important.log\n  .git  \n")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(patterns))
+		assert.Equal(t, "node_modules", patterns[0].pattern)
+		assert.Equal(t, "**/*.log", patterns[1].pattern)
+		assert.Equal(t, "important.log", patterns[2].pattern)
+		assert.True(t, patterns[2].exception)
+		assert.Equal(t, ".git", patterns[3].pattern)
+	})
+
+	t.Run("ReturnsErrorForInvalidPattern", func(t *testing.T) {
+
+		// act
+		_, err := parseDockerignore("file-[0-9.txt")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestIsIgnoredByDockerignore(t *testing.T) {
+
+	patterns, _ := parseDockerignore("node_modules\n**/*.log\n!important.log\ndocs/*\n!docs/README.md")
+
+	t.Run("ReturnsTrueForMatchingPathOrParentDirectory", func(t *testing.T) {
+
+		assert.True(t, isIgnoredByDockerignore("node_modules", patterns))
+		assert.True(t, isIgnoredByDockerignore("node_modules/react/index.js", patterns))
+		assert.True(t, isIgnoredByDockerignore("logs/build.log", patterns))
+		assert.True(t, isIgnoredByDockerignore("docs/index.md", patterns))
+	})
+
+	t.Run("ReturnsFalseIfLastMatchingPatternIsException", func(t *testing.T) {
+
+		assert.False(t, isIgnoredByDockerignore("important.log", patterns))
+		assert.False(t, isIgnoredByDockerignore("docs/README.md", patterns))
+		assert.False(t, isIgnoredByDockerignore("src/node_modules.go", patterns))
+	})
+}
+
+func TestGetBuildContextFiles(t *testing.T) {
+	t.Run("ReturnsFilesNotIgnoredWithSize", func(t *testing.T) {
+
+		dir := t.TempDir()
+		for f, content := range map[string]string{"Dockerfile": "FROM scratch", "app": "binary", "node_modules/react/index.js": "react", "logs/build.log": "log", "important.log": "log"} {
+			assert.Nil(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), 0755))
+			assert.Nil(t, os.WriteFile(filepath.Join(dir, f), []byte(content), 0644))
+		}
+		patterns, _ := parseDockerignore("node_modules\n**/*.log\n!important.log")
+
+		// act
+		files, err := getBuildContextFiles(dir, patterns)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []contextFile{
+			{path: "Dockerfile", size: 12},
+			{path: "app", size: 6},
+			{path: "important.log", size: 3},
+		}, files)
+	})
+}
+
+func TestReadDockerignore(t *testing.T) {
+	t.Run("ReturnsDockerfileSpecificPatternsFirst", func(t *testing.T) {
+
+		dir := t.TempDir()
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("*.log"), 0644))
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, "Dockerfile.dockerignore"), []byte("*.tmp\n*.bak"), 0644))
+
+		// act
+		patterns, path, err := readDockerignore(dir, filepath.Join(dir, "Dockerfile"))
+
+		assert.Nil(t, err)
+		assert.Equal(t, filepath.Join(dir, "Dockerfile.dockerignore"), path)
+		assert.Equal(t, 2, len(patterns))
+	})
+
+	t.Run("ReturnsNoPatternsWithoutDockerignore", func(t *testing.T) {
+
+		dir := t.TempDir()
+
+		// act
+		patterns, path, err := readDockerignore(dir, filepath.Join(dir, "Dockerfile"))
+
+		assert.Nil(t, err)
+		assert.Equal(t, "", path)
+		assert.Equal(t, 0, len(patterns))
+	})
+}
+
+func TestAnalyzeBuildContext(t *testing.T) {
+	t.Run("ReturnsWarningsForVCSDirectoriesCredentialsAndLargeFiles", func(t *testing.T) {
+
+		files := []contextFile{
+			{path: ".git/HEAD", size: 20},
+			{path: ".git/objects/pack/pack-1.pack", size: 200000000},
+			{path: "app", size: 60000000},
+			{path: "certs/server.pem", size: 2000},
+			{path: ".env", size: 100},
+			{path: "config/key-file.json", size: 2000},
+			{path: "main.go", size: 1000},
+		}
+
+		// act
+		warnings := analyzeBuildContext(files, 50000000)
+
+		assert.Equal(t, []string{
+			"version control directory .git is part of the build context, add it to .dockerignore",
+			"file app of 60.0MB is larger than 50.0MB",
+			"file certs/server.pem looks like it contains credentials and is part of the build context, add it to .dockerignore",
+			"file .env looks like it contains credentials and is part of the build context, add it to .dockerignore",
+			"file config/key-file.json looks like it contains credentials and is part of the build context, add it to .dockerignore",
+		}, warnings)
+	})
+}
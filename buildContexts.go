@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	remoteBuildContextRegex = regexp.MustCompile(`^(https?://|git://|ssh://|git@|github\.com/)`)
+	buildContextNameRegex   = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._/:-]*$`)
+	buildContextSchemeRegex = regexp.MustCompile(`^[a-z][a-z0-9+.-]*://`)
+)
+
+// isRemoteBuildContext returns true if the path is a git repository or tarball url docker fetches the build context from itself
+func isRemoteBuildContext(path string) bool {
+	return remoteBuildContextRegex.MatchString(path)
+}
+
+// getBuildContextArgs returns the --build-context arguments for the named contexts, sorted by name; local directories need to exist, values with a scheme like docker-image:// or a remote url are passed as is
+func getBuildContextArgs(contexts map[string]string) ([]string, error) {
+
+	var args []string
+	for _, name := range sortedKeys(contexts) {
+		if !buildContextNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("Build context name %v is invalid", name)
+		}
+		value := os.ExpandEnv(contexts[name])
+		if value == "" {
+			return nil, fmt.Errorf("Build context %v has no value", name)
+		}
+		if !buildContextSchemeRegex.MatchString(value) && !isRemoteBuildContext(value) {
+			fi, err := os.Stat(value)
+			if err != nil {
+				return nil, fmt.Errorf("Build context %v at %v doesn't exist: %w", name, value, err)
+			}
+			if !fi.IsDir() {
+				return nil, fmt.Errorf("Build context %v at %v is not a directory", name, value)
+			}
+		}
+		args = append(args, "--build-context", fmt.Sprintf("%v=%v", name, value))
+	}
+
+	return args, nil
+}
+
+// getBuildContextImages returns the images of docker-image:// contexts, which need a registry login like FROM images
+func getBuildContextImages(contexts map[string]string) []string {
+
+	var images []string
+	for _, name := range sortedKeys(contexts) {
+		if value := os.ExpandEnv(contexts[name]); strings.HasPrefix(value, "docker-image://") {
+			images = append(images, strings.TrimPrefix(value, "docker-image://"))
+		}
+	}
+
+	return images
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRemoteBuildContext(t *testing.T) {
+	t.Run("ReturnsTrueForGitAndTarballUrls", func(t *testing.T) {
+
+		assert.True(t, isRemoteBuildContext("https://github.com/estafette/estafette-extension-docker.git#main:services/api"))
+		assert.True(t, isRemoteBuildContext("git@github.com:estafette/estafette-extension-docker.git"))
+		assert.True(t, isRemoteBuildContext("github.com/estafette/estafette-extension-docker"))
+		assert.True(t, isRemoteBuildContext("https://artifacts.example.com/context.tar.gz"))
+	})
+
+	t.Run("ReturnsFalseForLocalPaths", func(t *testing.T) {
+
+		assert.False(t, isRemoteBuildContext("."))
+		assert.False(t, isRemoteBuildContext("./services/api"))
+		assert.False(t, isRemoteBuildContext("/estafette-work"))
+	})
+}
+
+func TestGetBuildContextArgs(t *testing.T) {
+	t.Run("ReturnsSortedBuildContextArgs", func(t *testing.T) {
+
+		shared := t.TempDir()
+		os.Setenv("TEST_SHARED_DIR", shared)
+		defer os.Unsetenv("TEST_SHARED_DIR")
+
+		// act
+		args, err := getBuildContextArgs(map[string]string{
+			"shared":       "${TEST_SHARED_DIR}",
+			"alpine":       "docker-image://alpine:3.19",
+			"docs":         "https://github.com/estafette/estafette-docs.git",
+			"estafette/ci": "docker-image://estafette/estafette-ci-builder:dev",
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"--build-context", "alpine=docker-image://alpine:3.19",
+			"--build-context", "docs=https://github.com/estafette/estafette-docs.git",
+			"--build-context", "estafette/ci=docker-image://estafette/estafette-ci-builder:dev",
+			"--build-context", "shared=" + shared,
+		}, args)
+	})
+
+	t.Run("ReturnsErrorIfLocalDirectoryDoesNotExist", func(t *testing.T) {
+
+		// act
+		_, err := getBuildContextArgs(map[string]string{"shared": filepath.Join(t.TempDir(), "missing")})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForInvalidName", func(t *testing.T) {
+
+		// act
+		_, err := getBuildContextArgs(map[string]string{"-shared": "docker-image://alpine:3.19"})
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetBuildContextImages(t *testing.T) {
+	t.Run("ReturnsImagesOfDockerImageContexts", func(t *testing.T) {
+
+		// act
+		images := getBuildContextImages(map[string]string{"shared": "../shared", "base": "docker-image://estafette/base:1.0.0"})
+
+		assert.Equal(t, []string{"estafette/base:1.0.0"}, images)
+	})
+}
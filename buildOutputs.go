@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// buildOutput exports the filesystem of a stage, or only the listed paths in it, to a local directory with the BuildKit local exporter
+type buildOutput struct {
+	Stage string   `yaml:"stage"`
+	Dest  string   `yaml:"dest"`
+	Paths []string `yaml:"paths"`
+}
+
+// parseBuildOutputs parses the `outputs:` list passed as yaml or json
+func parseBuildOutputs(value string) ([]buildOutput, error) {
+
+	if value == "" {
+		return nil, nil
+	}
+
+	var outputs []buildOutput
+	err := yaml.Unmarshal([]byte(value), &outputs)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing `outputs:`: %w", err)
+	}
+
+	return outputs, nil
+}
+
+func (o buildOutput) validate() error {
+	if o.Dest == "" {
+		return fmt.Errorf("Set `dest:` for every output")
+	}
+	return nil
+}
+
+// getStageIndex returns the index of the stage with the name, which docker matches case-insensitively
+func getStageIndex(stages []fromImage, name string) (int, error) {
+
+	for i, s := range stages {
+		if strings.EqualFold(s.stageName, name) {
+			return i, nil
+		}
+	}
+
+	var names []string
+	for _, s := range stages {
+		if s.stageName != "" {
+			names = append(names, s.stageName)
+		}
+	}
+
+	return -1, fmt.Errorf("Stage %v doesn't exist in the Dockerfile, it has stages %v", name, strings.Join(names, ", "))
+}
+
+// getOutputStageReference returns the stage to export for the output: its own stage, otherwise the target, otherwise the final stage; an unnamed final stage is referenced by index and can't be a target
+func getOutputStageReference(stages []fromImage, output buildOutput, target string) (reference string, isNamed bool, err error) {
+
+	stage := output.Stage
+	if stage == "" {
+		stage = target
+	}
+	if stage != "" {
+		index, err := getStageIndex(stages, stage)
+		if err != nil {
+			return "", false, err
+		}
+		return stages[index].stageName, true, nil
+	}
+
+	final := len(stages) - 1
+	if stages[final].stageName != "" {
+		return stages[final].stageName, true, nil
+	}
+
+	return fmt.Sprint(final), false, nil
+}
+
+// getOutputDockerfile appends a scratch stage with only the paths copied from the stage, so the local exporter writes just those
+func getOutputDockerfile(dockerfile, stageReference string, paths []string, outputStage string) string {
+
+	var builder strings.Builder
+	builder.WriteString(strings.TrimRight(dockerfile, "\n"))
+	builder.WriteString(fmt.Sprintf("\n\nFROM scratch AS %v\n", outputStage))
+	for _, p := range paths {
+		source := "/" + strings.TrimPrefix(filepath.ToSlash(p), "/")
+		builder.WriteString(fmt.Sprintf("COPY --from=%v %v %v\n", stageReference, source, source))
+	}
+
+	return builder.String()
+}
+
+// getOutputBuildArgs returns the docker build arguments to export the stage to the destination directory
+func getOutputBuildArgs(stage, dest, dockerfilePath, contextPath string, cacheFrom []string, buildArgs []buildArg, contextArgs []string) []string {
+
+	args := []string{"build"}
+	if !*noCache {
+		for _, cf := range cacheFrom {
+			args = append(args, "--cache-from", cf)
+		}
+	} else {
+		args = append(args, "--no-cache")
+	}
+	if stage != "" {
+		args = append(args, "--target", stage)
+	}
+	args = append(args, getBuildArgArgs(buildArgs)...)
+	args = append(args, contextArgs...)
+	args = append(args, "--output", fmt.Sprintf("type=local,dest=%v", dest))
+	args = append(args, "--file", dockerfilePath)
+	args = append(args, contextPath)
+
+	return args
+}
+
+// exportBuildOutputs builds the stage of every output and exports its filesystem, or only the listed paths, to the destination directory
+func exportBuildOutputs(ctx context.Context, image buildImage, stages []fromImage, dockerfile, dockerfilePath, contextPath string, cacheFrom []string, buildArgs []buildArg, contextArgs []string) error {
+
+	for index, o := range image.Outputs {
+
+		stageReference, isNamed, err := getOutputStageReference(stages, o, image.Target)
+		if err != nil {
+			return err
+		}
+
+		target := ""
+		if isNamed {
+			target = stageReference
+		}
+		outputDockerfilePath := dockerfilePath
+		if len(o.Paths) > 0 {
+			target = fmt.Sprintf("estafette-output-%v", index)
+			outputDockerfilePath = fmt.Sprintf("%v.%v", dockerfilePath, target)
+			outputDockerfile := getOutputDockerfile(dockerfile, stageReference, o.Paths, target)
+			if *dryRun {
+				plan.addStep(fmt.Sprintf("write Dockerfile to %v", outputDockerfilePath), "", nil)
+			} else {
+				err = os.WriteFile(outputDockerfilePath, []byte(outputDockerfile), 0644)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		log.Info().Msgf("Exporting stage %v of container image %v to %v...", stageReference, image.Container, o.Dest)
+		args := getOutputBuildArgs(target, o.Dest, outputDockerfilePath, contextPath, cacheFrom, buildArgs, contextArgs)
+		err = runCommandExtended(ctx, fmt.Sprintf("docker build to export stage %v", stageReference), "docker", args)
+		if err != nil {
+			return fmt.Errorf("exporting stage %v to %v failed: %w", stageReference, o.Dest, err)
+		}
+
+		if !*dryRun {
+			for _, p := range o.Paths {
+				log.Info().Msgf("- %v", filepath.Join(o.Dest, p))
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBuildOutputs(t *testing.T) {
+	t.Run("ReturnsOutputsFromYaml", func(t *testing.T) {
+
+		// act
+		outputs, err := parseBuildOutputs("- stage: test\n  dest: ./test-results\n  paths:\n  - /app/reports\n- dest: ./dist")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []buildOutput{
+			{Stage: "test", Dest: "./test-results", Paths: []string{"/app/reports"}},
+			{Dest: "./dist"},
+		}, outputs)
+	})
+
+	t.Run("ReturnsNilForEmptyValue", func(t *testing.T) {
+
+		// act
+		outputs, err := parseBuildOutputs("")
+
+		assert.Nil(t, err)
+		assert.Nil(t, outputs)
+	})
+}
+
+func TestBuildOutputValidate(t *testing.T) {
+	t.Run("ReturnsErrorIfDestIsNotSet", func(t *testing.T) {
+
+		// act
+		err := buildOutput{Stage: "test"}.validate()
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetStageIndex(t *testing.T) {
+
+	stages := []fromImage{{stageName: "builder"}, {stageName: "Test"}, {}}
+
+	t.Run("ReturnsIndexMatchingNameCaseInsensitively", func(t *testing.T) {
+
+		// act
+		index, err := getStageIndex(stages, "test")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, index)
+	})
+
+	t.Run("ReturnsErrorListingStagesIfStageDoesNotExist", func(t *testing.T) {
+
+		// act
+		_, err := getStageIndex(stages, "artifacts")
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "builder, Test")
+	})
+}
+
+func TestGetOutputStageReference(t *testing.T) {
+
+	stages := []fromImage{{stageName: "builder"}, {stageName: "test"}, {}}
+
+	t.Run("ReturnsStageOfOutput", func(t *testing.T) {
+
+		// act
+		reference, isNamed, err := getOutputStageReference(stages, buildOutput{Stage: "builder"}, "test")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "builder", reference)
+		assert.True(t, isNamed)
+	})
+
+	t.Run("ReturnsTargetIfOutputHasNoStage", func(t *testing.T) {
+
+		// act
+		reference, isNamed, err := getOutputStageReference(stages, buildOutput{}, "test")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "test", reference)
+		assert.True(t, isNamed)
+	})
+
+	t.Run("ReturnsIndexOfUnnamedFinalStage", func(t *testing.T) {
+
+		// act
+		reference, isNamed, err := getOutputStageReference(stages, buildOutput{}, "")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "2", reference)
+		assert.False(t, isNamed)
+	})
+}
+
+func TestGetOutputDockerfile(t *testing.T) {
+	t.Run("AppendsScratchStageCopyingPaths", func(t *testing.T) {
+
+		// act
+		dockerfile := getOutputDockerfile("FROM golang:1.22 AS test\nRUN go test ./...\n", "test", []string{"/app/reports", "app/coverage.out"}, "estafette-output-0")
+
+		assert.Equal(t, "FROM golang:1.22 AS test\nRUN go test ./...\n\nFROM scratch AS estafette-output-0\nCOPY --from=test /app/reports /app/reports\nCOPY --from=test /app/coverage.out /app/coverage.out\n", dockerfile)
+	})
+}
+
+func TestGetOutputBuildArgs(t *testing.T) {
+	t.Run("ReturnsBuildArgsWithLocalExporter", func(t *testing.T) {
+
+		// act
+		args := getOutputBuildArgs("test", "./test-results", "/estafette-work/Dockerfile", "/estafette-work", []string{"estafette/docker:dlc-test"}, []buildArg{{name: "VERSION", value: "1.0.0"}}, []string{"--build-context", "shared=../shared"})
+
+		assert.Equal(t, []string{
+			"build",
+			"--cache-from", "estafette/docker:dlc-test",
+			"--target", "test",
+			"--build-arg", "VERSION=1.0.0",
+			"--build-context", "shared=../shared",
+			"--output", "type=local,dest=./test-results",
+			"--file", "/estafette-work/Dockerfile",
+			"/estafette-work",
+		}, args)
+	})
+}
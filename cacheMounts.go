@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cpy "github.com/otiai10/copy"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// cacheMountsHelperImage runs the copy commands moving files between cache mounts and the exported directory
+	cacheMountsHelperImage = "busybox:1.36"
+	cacheMountsLatestKey   = "latest"
+)
+
+// defaultCacheMountsKeyFiles are common lockfiles; the ones present in the build context key the cache mounts
+var defaultCacheMountsKeyFiles = []string{
+	"go.sum",
+	"package-lock.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"packages.lock.json",
+	"Gemfile.lock",
+	"poetry.lock",
+	"Pipfile.lock",
+	"requirements.txt",
+	"Cargo.lock",
+	"composer.lock",
+	"gradle.lockfile",
+}
+
+// cacheMount is a RUN --mount=type=cache mount, identified by its id which defaults to its target
+type cacheMount struct {
+	id   string
+	uid  string
+	gid  string
+	mode string
+}
+
+// directory returns the directory in the exported cache holding the contents of the mount
+func (m cacheMount) directory() string {
+	hash := sha256.Sum256([]byte(m.id))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+// options returns the --mount option to mount the same cache at the target
+func (m cacheMount) options(target string) string {
+	options := fmt.Sprintf("--mount=type=cache,id=%v,target=%v", m.id, target)
+	if m.uid != "" {
+		options += ",uid=" + m.uid
+	}
+	if m.gid != "" {
+		options += ",gid=" + m.gid
+	}
+	if m.mode != "" {
+		options += ",mode=" + m.mode
+	}
+	return options
+}
+
+// getCacheMounts returns the cache mounts of RUN instructions, skipping the ones seeded from another stage or image
+func getCacheMounts(dockerfile string) []cacheMount {
+
+	seen := map[string]bool{}
+	var mounts []cacheMount
+	for _, line := range strings.Split(removeHeredocBodies(dockerfile), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, option := range mountOptionRegex.FindAllString(line, -1) {
+			values := map[string]string{}
+			for _, field := range strings.Split(strings.TrimPrefix(option, "--mount="), ",") {
+				key, value, _ := strings.Cut(field, "=")
+				values[strings.ToLower(key)] = strings.Trim(value, `"'`)
+			}
+			if values["type"] != "cache" || values["from"] != "" {
+				continue
+			}
+			id := values["id"]
+			if id == "" {
+				id = values["target"]
+				if id == "" {
+					id = values["dst"]
+				}
+				if id == "" {
+					id = values["destination"]
+				}
+			}
+			if id == "" || seen[id] {
+				continue
+			}
+			seen[id] = true
+			mounts = append(mounts, cacheMount{id: id, uid: values["uid"], gid: values["gid"], mode: values["mode"]})
+		}
+	}
+
+	return mounts
+}
+
+// getCacheMountsKey returns the key of the cache mounts from the container, the mount ids and the contents of the key files present in the build context
+func getCacheMountsKey(container string, mounts []cacheMount, contextPath string, keyFiles []string) (string, error) {
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "container %v\n", container)
+	for _, m := range mounts {
+		fmt.Fprintf(hash, "mount %v\n", m.id)
+	}
+
+	keyFiles = append([]string{}, keyFiles...)
+	sort.Strings(keyFiles)
+	for _, f := range keyFiles {
+		file, err := os.Open(filepath.Join(contextPath, f))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		fileHash := sha256.New()
+		_, err = io.Copy(fileHash, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+		log.Info().Msgf("Keying cache mounts on %v", f)
+		fmt.Fprintf(hash, "file %v %x\n", filepath.ToSlash(f), fileHash.Sum(nil))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+// getCacheMountsImportDockerfile returns a Dockerfile copying the exported directory of every mount from the build context into its cache mount
+func getCacheMountsImportDockerfile(mounts []cacheMount) string {
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("FROM %v\n", cacheMountsHelperImage))
+	for _, m := range mounts {
+		d := m.directory()
+		builder.WriteString(fmt.Sprintf("RUN %v --mount=type=bind,source=mounts/%v,target=/import/%v cp -a /import/%v/. /cache/%v/\n", m.options("/cache/"+d), d, d, d, d))
+	}
+
+	return builder.String()
+}
+
+// getCacheMountsExportDockerfile returns a Dockerfile with the contents of every cache mount in its own directory of the final stage
+func getCacheMountsExportDockerfile(mounts []cacheMount) string {
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("FROM %v AS export\n", cacheMountsHelperImage))
+	for _, m := range mounts {
+		d := m.directory()
+		builder.WriteString(fmt.Sprintf("RUN %v mkdir -p /export/%v && cp -a /cache/%v/. /export/%v/\n", m.options("/cache/"+d), d, d, d))
+	}
+	builder.WriteString("\nFROM scratch\nCOPY --from=export /export /\n")
+
+	return builder.String()
+}
+
+// getCacheMountsKeyFiles returns the configured key files, or the default lockfiles if none are configured
+func getCacheMountsKeyFiles(value string) []string {
+
+	var keyFiles []string
+	for _, f := range strings.Split(value, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			keyFiles = append(keyFiles, f)
+		}
+	}
+	if len(keyFiles) == 0 {
+		return defaultCacheMountsKeyFiles
+	}
+
+	return keyFiles
+}
+
+// isRegistryCacheMountsLocation returns true if the cache mounts get stored as image next to the dlc cache tags instead of in a directory
+func isRegistryCacheMountsLocation(location string) bool {
+	return location == "registry"
+}
+
+// getCacheMountsArchivePath returns the path of the archive with the cache mounts for the key in the location directory
+func getCacheMountsArchivePath(location, container, key string) string {
+	return filepath.Join(location, tidyTag(container), fmt.Sprintf("cache-mounts-%v.tar.gz", key))
+}
+
+// getCacheMountsImagePath returns the image with the cache mounts for the key, tagged next to the dlc cache tags
+func getCacheMountsImagePath(image buildImage, key string) string {
+	return fmt.Sprintf("%v/%v:cache-mounts-%v", image.Repositories[0], image.Container, key)
+}
+
+// createCacheMountsDirectory creates a temporary directory to use as build context for the import and export builds
+func createCacheMountsDirectory() (string, error) {
+	if *dryRun {
+		directory := filepath.Join(os.TempDir(), "estafette-cache-mounts")
+		plan.addStep(fmt.Sprintf("create directory %v for cache mounts", directory), "", nil)
+		return directory, nil
+	}
+	return os.MkdirTemp("", "estafette-cache-mounts-")
+}
+
+// fetchCacheMounts retrieves the cache mounts for the key into the directory; it returns false if there are none
+func fetchCacheMounts(ctx context.Context, image buildImage, location, key, directory string) (bool, error) {
+
+	if isRegistryCacheMountsLocation(location) {
+		imagePath := getCacheMountsImagePath(image, key)
+		err := runDockerCommandWithRetryExtended(ctx, "pull", []string{"pull", imagePath}, "")
+		if err != nil {
+			log.Info().Msgf("No cache mounts image %v", imagePath)
+			return false, nil
+		}
+		// a scratch image has no command, but creating a container only needs one to be set
+		containerName := fmt.Sprintf("estafette-cache-mounts-%v", key)
+		for _, args := range [][]string{
+			{"create", "--name", containerName, imagePath, "import"},
+			{"cp", fmt.Sprintf("%v:/.", containerName), directory},
+			{"rm", containerName},
+		} {
+			err = runCommandExtended(ctx, fmt.Sprintf("docker %v cache mounts", args[0]), "docker", args)
+			if err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	archivePath := getCacheMountsArchivePath(location, image.Container, key)
+	if ok, _ := pathExists(archivePath); !ok {
+		log.Info().Msgf("No cache mounts archive %v", archivePath)
+		return false, nil
+	}
+	if !*dryRun {
+		err := os.MkdirAll(directory, os.ModePerm)
+		if err != nil {
+			return false, err
+		}
+	}
+	err := runCommandExtended(ctx, "extract cache mounts", "tar", []string{"-xzf", archivePath, "-C", directory})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// importCacheMounts fills the cache mounts of the Dockerfile with the ones stored for the key, falling back to the latest ones stored for the container; it returns true if the key matched
+func importCacheMounts(ctx context.Context, image buildImage, location string, mounts []cacheMount, key string) (bool, error) {
+
+	directory, err := createCacheMountsDirectory()
+	if err != nil {
+		return false, err
+	}
+	if !*dryRun {
+		defer os.RemoveAll(directory)
+	}
+
+	mountsDirectory := filepath.Join(directory, "mounts")
+	foundKey := ""
+	for _, k := range []string{key, cacheMountsLatestKey} {
+		found, err := fetchCacheMounts(ctx, image, location, k, mountsDirectory)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			foundKey = k
+			break
+		}
+	}
+	if foundKey == "" {
+		log.Info().Msg("No cache mounts to import, they'll get exported after the build")
+		return false, nil
+	}
+
+	// mounts added since the cache got exported have nothing to import
+	var importMounts []cacheMount
+	for _, m := range mounts {
+		if ok, _ := pathExists(filepath.Join(mountsDirectory, m.directory())); ok || *dryRun {
+			importMounts = append(importMounts, m)
+		}
+	}
+	if len(importMounts) == 0 {
+		return foundKey == key, nil
+	}
+
+	dockerfilePath := filepath.Join(directory, "Dockerfile")
+	if *dryRun {
+		plan.addStep(fmt.Sprintf("write Dockerfile to %v", dockerfilePath), "", nil)
+	} else {
+		err = os.WriteFile(dockerfilePath, []byte(getCacheMountsImportDockerfile(importMounts)), 0644)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	log.Info().Msgf("Importing %v cache mounts with key %v...", len(importMounts), foundKey)
+	err = runCommandExtended(ctx, "docker build to import cache mounts", "docker", []string{"build", "--no-cache", "--file", dockerfilePath, directory})
+	if err != nil {
+		return false, err
+	}
+
+	return foundKey == key, nil
+}
+
+// exportCacheMounts stores the contents of the cache mounts of the Dockerfile for the key and as latest ones for the container
+func exportCacheMounts(ctx context.Context, image buildImage, location string, mounts []cacheMount, key string) error {
+
+	directory, err := createCacheMountsDirectory()
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		defer os.RemoveAll(directory)
+	}
+
+	dockerfilePath := filepath.Join(directory, "Dockerfile")
+	if *dryRun {
+		plan.addStep(fmt.Sprintf("write Dockerfile to %v", dockerfilePath), "", nil)
+	} else {
+		err = os.WriteFile(dockerfilePath, []byte(getCacheMountsExportDockerfile(mounts)), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info().Msgf("Exporting %v cache mounts with key %v...", len(mounts), key)
+
+	if isRegistryCacheMountsLocation(location) {
+		imagePath := getCacheMountsImagePath(image, key)
+		latestImagePath := getCacheMountsImagePath(image, cacheMountsLatestKey)
+		err = runCommandExtended(ctx, "docker build to export cache mounts", "docker", []string{"build", "--no-cache", "--tag", imagePath, "--tag", latestImagePath, "--file", dockerfilePath, directory})
+		if err != nil {
+			return err
+		}
+		for _, p := range []string{imagePath, latestImagePath} {
+			err = runDockerCommandWithRetryExtended(ctx, "push", []string{"push", p}, "")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	mountsDirectory := filepath.Join(directory, "mounts")
+	err = runCommandExtended(ctx, "docker build to export cache mounts", "docker", []string{"build", "--no-cache", "--output", fmt.Sprintf("type=local,dest=%v", mountsDirectory), "--file", dockerfilePath, directory})
+	if err != nil {
+		return err
+	}
+
+	archivePath := getCacheMountsArchivePath(location, image.Container, key)
+	latestArchivePath := getCacheMountsArchivePath(location, image.Container, cacheMountsLatestKey)
+	if *dryRun {
+		plan.addStep(fmt.Sprintf("create directory %v", filepath.Dir(archivePath)), "", nil)
+	} else {
+		err = os.MkdirAll(filepath.Dir(archivePath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+	}
+	err = runCommandExtended(ctx, "archive cache mounts", "tar", []string{"-czf", archivePath, "-C", mountsDirectory, "."})
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		plan.addStep(fmt.Sprintf("copy %v to %v", archivePath, latestArchivePath), "", nil)
+		return nil
+	}
+	err = cpy.Copy(archivePath, latestArchivePath)
+	if err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(archivePath); err == nil {
+		log.Info().Msgf("Exported cache mounts to %v (%v)", archivePath, formatByteSize(fi.Size()))
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCacheMounts(t *testing.T) {
+	t.Run("ReturnsCacheMountsIdentifiedByIdOrTarget", func(t *testing.T) {
+
+		dockerfile := `# syntax=docker/dockerfile:1
+FROM golang:1.22 AS builder
+RUN --mount=type=cache,target=/go/pkg/mod \
+    --mount=type=cache,id=gobuild,target=/root/.cache/go-build,uid=1000 \
+    --mount=type=bind,source=go.sum,target=go.sum \
+    go mod download
+RUN --mount=type=cache,target=/go/pkg/mod go build ./...
+FROM node:20
+RUN --mount=type=cache,from=builder,target=/tmp/seed ls /tmp/seed
+RUN --mount=type=cache,dst=/root/.npm <<EOF
+npm ci --mount=type=cache,target=/ignored
+EOF`
+
+		// act
+		mounts := getCacheMounts(dockerfile)
+
+		assert.Equal(t, []cacheMount{
+			{id: "/go/pkg/mod"},
+			{id: "gobuild", uid: "1000"},
+			{id: "/root/.npm"},
+		}, mounts)
+	})
+}
+
+func TestCacheMountOptions(t *testing.T) {
+	t.Run("ReturnsMountOptionForSameCacheAtTarget", func(t *testing.T) {
+
+		// act
+		options := cacheMount{id: "gobuild", uid: "1000", gid: "1000", mode: "0755"}.options("/cache/a")
+
+		assert.Equal(t, "--mount=type=cache,id=gobuild,target=/cache/a,uid=1000,gid=1000,mode=0755", options)
+	})
+}
+
+func TestGetCacheMountsKey(t *testing.T) {
+
+	mounts := []cacheMount{{id: "/go/pkg/mod"}}
+
+	t.Run("ReturnsKeyChangingWithKeyFileContents", func(t *testing.T) {
+
+		contextPath := t.TempDir()
+		assert.Nil(t, os.WriteFile(filepath.Join(contextPath, "go.sum"), []byte("github.com/rs/zerolog v1.32.0 h1:abc\n"), 0644))
+
+		// act
+		key, err := getCacheMountsKey("docker", mounts, contextPath, defaultCacheMountsKeyFiles)
+
+		assert.Nil(t, err)
+		assert.Len(t, key, 16)
+
+		assert.Nil(t, os.WriteFile(filepath.Join(contextPath, "go.sum"), []byte("github.com/rs/zerolog v1.33.0 h1:def\n"), 0644))
+		changedKey, err := getCacheMountsKey("docker", mounts, contextPath, defaultCacheMountsKeyFiles)
+
+		assert.Nil(t, err)
+		assert.NotEqual(t, key, changedKey)
+	})
+
+	t.Run("ReturnsKeyChangingWithContainerAndMounts", func(t *testing.T) {
+
+		contextPath := t.TempDir()
+
+		// act
+		key, err := getCacheMountsKey("docker", mounts, contextPath, nil)
+
+		assert.Nil(t, err)
+		otherContainerKey, _ := getCacheMountsKey("gke", mounts, contextPath, nil)
+		otherMountsKey, _ := getCacheMountsKey("docker", append(mounts, cacheMount{id: "gobuild"}), contextPath, nil)
+		assert.NotEqual(t, key, otherContainerKey)
+		assert.NotEqual(t, key, otherMountsKey)
+	})
+}
+
+func TestGetCacheMountsImportDockerfile(t *testing.T) {
+	t.Run("ReturnsDockerfileCopyingBuildContextIntoCacheMounts", func(t *testing.T) {
+
+		mount := cacheMount{id: "/go/pkg/mod"}
+		d := mount.directory()
+
+		// act
+		dockerfile := getCacheMountsImportDockerfile([]cacheMount{mount})
+
+		assert.Equal(t, "FROM busybox:1.36\nRUN --mount=type=cache,id=/go/pkg/mod,target=/cache/"+d+" --mount=type=bind,source=mounts/"+d+",target=/import/"+d+" cp -a /import/"+d+"/. /cache/"+d+"/\n", dockerfile)
+	})
+}
+
+func TestGetCacheMountsExportDockerfile(t *testing.T) {
+	t.Run("ReturnsDockerfileWithCacheMountContentsInFinalStage", func(t *testing.T) {
+
+		mount := cacheMount{id: "/go/pkg/mod"}
+		d := mount.directory()
+
+		// act
+		dockerfile := getCacheMountsExportDockerfile([]cacheMount{mount})
+
+		assert.Equal(t, "FROM busybox:1.36 AS export\nRUN --mount=type=cache,id=/go/pkg/mod,target=/cache/"+d+" mkdir -p /export/"+d+" && cp -a /cache/"+d+"/. /export/"+d+"/\n\nFROM scratch\nCOPY --from=export /export /\n", dockerfile)
+	})
+}
+
+func TestGetCacheMountsKeyFiles(t *testing.T) {
+	t.Run("ReturnsConfiguredKeyFiles", func(t *testing.T) {
+
+		// act
+		keyFiles := getCacheMountsKeyFiles("go.sum, web/package-lock.json")
+
+		assert.Equal(t, []string{"go.sum", "web/package-lock.json"}, keyFiles)
+	})
+
+	t.Run("ReturnsDefaultLockfilesIfNoneAreConfigured", func(t *testing.T) {
+
+		// act
+		keyFiles := getCacheMountsKeyFiles("")
+
+		assert.Equal(t, defaultCacheMountsKeyFiles, keyFiles)
+	})
+}
+
+func TestGetCacheMountsArchivePath(t *testing.T) {
+	t.Run("ReturnsArchiveInDirectoryOfContainer", func(t *testing.T) {
+
+		// act
+		archivePath := getCacheMountsArchivePath("/estafette-cache", "docker", "0123456789abcdef")
+
+		assert.Equal(t, "/estafette-cache/docker/cache-mounts-0123456789abcdef.tar.gz", archivePath)
+	})
+}
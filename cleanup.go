@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// cleanupRules determine which tags of a container image are deleted by the cleanup action
+type cleanupRules struct {
+	// keepLast is the number of most recent version tags that are always kept
+	keepLast int
+	// keepTags matches tags that are never deleted, like stable or beta
+	keepTags *regexp.Regexp
+	// maxAge deletes version tags beyond keepLast older than this; zero disables the age rule, so all version tags beyond keepLast get deleted
+	maxAge time.Duration
+
+	versionTagPrefix string
+	versionTagSuffix string
+	// buildVersionPreRelease is the pre-release part of the build version, the only one accepted in version tags without suffix
+	buildVersionPreRelease string
+
+	// stages are the (tidied) stage names in the current Dockerfile; dlc-<stage> tags for other stages get deleted
+	stages                  []string
+	deleteOrphanedCacheTags bool
+}
+
+// validateCleanupRetention returns an error unless a retention rule for version tags is set explicitly, so a bare cleanup doesn't delete anything unexpectedly
+func validateCleanupRetention(keepLast, maxAgeDays int) error {
+	if keepLast < 0 || maxAgeDays < 0 {
+		return fmt.Errorf("keepLast and maxAgeDays can't be negative")
+	}
+	if keepLast == 0 && maxAgeDays == 0 {
+		return fmt.Errorf("Set `keepLast:` and/or `maxAgeDays:` to the version tags to retain before running the cleanup action")
+	}
+	return nil
+}
+
+// getVersionFromTag returns the version for tags created for ESTAFETTE_BUILD_VERSION with the same prefix and suffix; without suffix a pre-release needs to equal the one of the build version, since tags of stages with a suffix like 1.4.7-windows look like pre-releases
+func getVersionFromTag(tag, versionTagPrefix, versionTagSuffix, buildVersionPreRelease string) (semanticVersion, bool) {
+
+	if versionTagPrefix != "" {
+		if !strings.HasPrefix(tag, versionTagPrefix+"-") {
+			return semanticVersion{}, false
+		}
+		tag = strings.TrimPrefix(tag, versionTagPrefix+"-")
+	}
+	if versionTagSuffix != "" {
+		if !strings.HasSuffix(tag, "-"+versionTagSuffix) {
+			return semanticVersion{}, false
+		}
+		tag = strings.TrimSuffix(tag, "-"+versionTagSuffix)
+	}
+
+	v, err := parseSemanticVersion(tag)
+	if err != nil || v.parts < 3 {
+		return semanticVersion{}, false
+	}
+	if versionTagSuffix == "" && v.preRelease != "" && v.preRelease != buildVersionPreRelease {
+		return semanticVersion{}, false
+	}
+
+	return v, true
+}
+
+// isCacheTag returns true for the dlc tags pushed by the build action to use as layer cache
+func isCacheTag(tag string) bool {
+	return tag == "dlc" || strings.HasPrefix(tag, "dlc-")
+}
+
+// getTagsToDelete applies the cleanup rules to tags and returns the ones to delete; getCreated is only called for version tags beyond keepLast when maxAge is set
+func getTagsToDelete(tags []string, rules cleanupRules, now time.Time, getCreated func(tag string) (time.Time, error)) ([]string, error) {
+
+	type versionTag struct {
+		tag     string
+		version semanticVersion
+	}
+
+	var tagsToDelete []string
+	var versionTags []versionTag
+	for _, t := range tags {
+		if rules.keepTags != nil && rules.keepTags.MatchString(t) {
+			continue
+		}
+
+		if isCacheTag(t) {
+			if rules.deleteOrphanedCacheTags && rules.stages != nil && t != "dlc" && !contains(rules.stages, strings.TrimPrefix(t, "dlc-")) {
+				tagsToDelete = append(tagsToDelete, t)
+			}
+			continue
+		}
+
+		if v, ok := getVersionFromTag(t, rules.versionTagPrefix, rules.versionTagSuffix, rules.buildVersionPreRelease); ok {
+			versionTags = append(versionTags, versionTag{tag: t, version: v})
+		}
+	}
+
+	// without a retention rule for versions all of them are kept
+	if rules.keepLast <= 0 && rules.maxAge <= 0 {
+		return tagsToDelete, nil
+	}
+
+	// most recent versions first
+	sort.SliceStable(versionTags, func(i, j int) bool {
+		return versionTags[i].version.compare(versionTags[j].version) > 0
+	})
+
+	for i, vt := range versionTags {
+		if i < rules.keepLast {
+			continue
+		}
+		if rules.maxAge > 0 {
+			created, err := getCreated(vt.tag)
+			if err != nil {
+				return nil, fmt.Errorf("failed retrieving creation time for tag %v: %w", vt.tag, err)
+			}
+			if now.Sub(created) < rules.maxAge {
+				continue
+			}
+		}
+		tagsToDelete = append(tagsToDelete, vt.tag)
+	}
+
+	return tagsToDelete, nil
+}
+
+// cleanupContainerImage deletes the tags of repository selected by the cleanup rules, or only lists them in dry-run mode
+func cleanupContainerImage(ctx context.Context, client *registryClient, repository string, rules cleanupRules, dryRun bool) error {
+
+	log.Info().Msgf("Listing tags for %v...", repository)
+	tags, err := client.listTags(ctx, repository)
+	if err != nil {
+		return fmt.Errorf("failed listing tags for %v: %w", repository, err)
+	}
+
+	tagsToDelete, err := getTagsToDelete(tags, rules, time.Now().UTC(), func(tag string) (time.Time, error) {
+		config, err := client.getImageConfig(ctx, repository, tag)
+		return config.Created, err
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Selected %v out of %v tags of %v for deletion", len(tagsToDelete), len(tags), repository)
+
+	if dryRun {
+		for _, t := range tagsToDelete {
+			log.Info().Msgf("Would delete %v:%v (dry-run)", repository, t)
+			plan.addStep(fmt.Sprintf("delete %v:%v", repository, t), "", nil)
+		}
+		return nil
+	}
+
+	// digests of tags that are kept, so deleting by digest never removes a kept tag
+	var keptDigests map[string]bool
+	getKeptDigests := func() (map[string]bool, error) {
+		if keptDigests != nil {
+			return keptDigests, nil
+		}
+		digests := map[string]bool{}
+		for _, t := range tags {
+			if contains(tagsToDelete, t) {
+				continue
+			}
+			digest, err := client.getManifestDigest(ctx, repository, t)
+			if err != nil {
+				return nil, fmt.Errorf("failed retrieving digest for kept tag %v:%v: %w", repository, t, err)
+			}
+			digests[digest] = true
+		}
+		keptDigests = digests
+		return keptDigests, nil
+	}
+
+	var cleanupErrors []error
+	for _, t := range tagsToDelete {
+
+		log.Info().Msgf("Deleting %v:%v", repository, t)
+
+		// registries like gcr and artifact registry support deleting a tag, others only delete by digest
+		err := client.deleteManifest(ctx, repository, t)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, errUnsupported) {
+			cleanupErrors = append(cleanupErrors, fmt.Errorf("deleting %v:%v failed: %w", repository, t, err))
+			continue
+		}
+
+		// without the digests of all kept tags deleting by digest could remove a kept image, so stop cleaning up this repository
+		kept, err := getKeptDigests()
+		if err != nil {
+			cleanupErrors = append(cleanupErrors, fmt.Errorf("stopped cleaning up %v: %w", repository, err))
+			break
+		}
+
+		digest, err := client.getManifestDigest(ctx, repository, t)
+		if err != nil {
+			cleanupErrors = append(cleanupErrors, fmt.Errorf("deleting %v:%v failed: %w", repository, t, err))
+			continue
+		}
+		if kept[digest] {
+			log.Warn().Msgf("Skipping deletion of %v:%v, its digest %v is shared with a kept tag and the registry doesn't support deleting tags", repository, t, digest)
+			continue
+		}
+
+		err = client.deleteManifest(ctx, repository, digest)
+		if err != nil && !errors.Is(err, errManifestUnknown) {
+			cleanupErrors = append(cleanupErrors, fmt.Errorf("deleting %v:%v failed: %w", repository, t, err))
+		}
+	}
+
+	return errors.Join(cleanupErrors...)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetVersionFromTag(t *testing.T) {
+	t.Run("ReturnsVersionForVersionTag", func(t *testing.T) {
+
+		// act
+		v, ok := getVersionFromTag("0.1.23", "", "", "")
+
+		assert.True(t, ok)
+		assert.Equal(t, "0.1.23", v.String())
+	})
+
+	t.Run("ReturnsVersionForTagWithPrefixAndSuffix", func(t *testing.T) {
+
+		// act
+		v, ok := getVersionFromTag("api-0.1.23-feature-x-windows", "api", "windows", "")
+
+		assert.True(t, ok)
+		assert.Equal(t, "0.1.23-feature-x", v.String())
+	})
+
+	t.Run("ReturnsFalseForTagWithOtherPrefix", func(t *testing.T) {
+
+		// act
+		_, ok := getVersionFromTag("web-0.1.23", "api", "", "")
+
+		assert.False(t, ok)
+	})
+
+	t.Run("ReturnsFalseForPreReleaseOtherThanBuildVersionWithoutSuffix", func(t *testing.T) {
+
+		// act
+		_, ok := getVersionFromTag("1.4.7-windows", "", "", "")
+
+		assert.False(t, ok)
+	})
+
+	t.Run("ReturnsVersionForPreReleaseOfBuildVersionWithoutSuffix", func(t *testing.T) {
+
+		// act
+		v, ok := getVersionFromTag("0.1.23-feature-x", "", "", "feature-x")
+
+		assert.True(t, ok)
+		assert.Equal(t, "0.1.23-feature-x", v.String())
+	})
+
+	t.Run("ReturnsFalseForPartialVersion", func(t *testing.T) {
+
+		// act
+		_, ok := getVersionFromTag("1.4", "", "", "")
+
+		assert.False(t, ok)
+	})
+}
+
+func TestGetTagsToDelete(t *testing.T) {
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	created := map[string]time.Time{
+		"0.1.1": now.Add(-100 * 24 * time.Hour),
+		"0.1.2": now.Add(-50 * 24 * time.Hour),
+		"0.1.3": now.Add(-20 * 24 * time.Hour),
+		"0.1.4": now.Add(-1 * 24 * time.Hour),
+	}
+	getCreated := func(tag string) (time.Time, error) {
+		if c, ok := created[tag]; ok {
+			return c, nil
+		}
+		return time.Time{}, fmt.Errorf("unknown tag %v", tag)
+	}
+	tags := []string{"stable", "0.1.1", "0.1.3", "0.1.2", "0.1.4", "dlc", "dlc-builder", "dlc-old-stage", "some-other-tag"}
+
+	t.Run("DeletesVersionsBeyondKeepLast", func(t *testing.T) {
+
+		rules := cleanupRules{
+			keepLast: 2,
+			keepTags: regexp.MustCompile(`^(stable|beta|dev|latest)$`),
+		}
+
+		// act
+		tagsToDelete, err := getTagsToDelete(tags, rules, now, getCreated)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"0.1.2", "0.1.1"}, tagsToDelete)
+	})
+
+	t.Run("DeletesVersionsOfUnsuffixedStageOnlyInRepositorySharedWithSuffixedStage", func(t *testing.T) {
+
+		rules := cleanupRules{
+			keepLast: 2,
+		}
+
+		// act
+		tagsToDelete, err := getTagsToDelete([]string{"1.4.7", "1.4.6", "1.4.5", "1.4.7-windows", "1.4.6-windows"}, rules, now, getCreated)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1.4.5"}, tagsToDelete)
+	})
+
+	t.Run("DeletesVersionsOfSuffixedStageOnlyInRepositorySharedWithUnsuffixedStage", func(t *testing.T) {
+
+		rules := cleanupRules{
+			keepLast:         1,
+			versionTagSuffix: "windows",
+		}
+
+		// act
+		tagsToDelete, err := getTagsToDelete([]string{"1.4.7", "1.4.6", "1.4.5", "1.4.7-windows", "1.4.6-windows"}, rules, now, getCreated)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1.4.6-windows"}, tagsToDelete)
+	})
+
+	t.Run("DeletesVersionsBeyondKeepLastOlderThanMaxAge", func(t *testing.T) {
+
+		rules := cleanupRules{
+			keepLast: 1,
+			maxAge:   30 * 24 * time.Hour,
+		}
+
+		// act
+		tagsToDelete, err := getTagsToDelete(tags, rules, now, getCreated)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"0.1.2", "0.1.1"}, tagsToDelete)
+	})
+
+	t.Run("DeletesOrphanedCacheTags", func(t *testing.T) {
+
+		rules := cleanupRules{
+			stages:                  []string{"builder"},
+			deleteOrphanedCacheTags: true,
+		}
+
+		// act
+		tagsToDelete, err := getTagsToDelete(tags, rules, now, getCreated)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"dlc-old-stage"}, tagsToDelete)
+	})
+
+	t.Run("KeepsCacheTagsIfStagesAreUnknown", func(t *testing.T) {
+
+		rules := cleanupRules{
+			deleteOrphanedCacheTags: true,
+		}
+
+		// act
+		tagsToDelete, err := getTagsToDelete(tags, rules, now, getCreated)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(tagsToDelete))
+	})
+
+	t.Run("NeverDeletesKeptTags", func(t *testing.T) {
+
+		rules := cleanupRules{
+			keepLast: 0,
+			maxAge:   time.Hour,
+			keepTags: regexp.MustCompile(`^(0\.1\.1)$`),
+		}
+
+		// act
+		tagsToDelete, err := getTagsToDelete(tags, rules, now, getCreated)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"0.1.4", "0.1.3", "0.1.2"}, tagsToDelete)
+	})
+}
+
+func TestCleanupContainerImage(t *testing.T) {
+
+	// newTestRegistry returns a registry with tags 1.0.0, 1.0.1 and 1.0.2 that answers tag deletes with deleteTagStatus and digest lookups of the kept tag with keptDigestStatus
+	newTestRegistry := func(t *testing.T, deleteTagStatus, keptDigestStatus int) (*httptest.Server, *registryClient, string, func() []string) {
+		var mutex sync.Mutex
+		var deleted []string
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reference := strings.TrimPrefix(r.URL.Path, "/v2/extensions/docker/manifests/")
+			switch {
+			case r.URL.Path == "/v2/extensions/docker/tags/list":
+				fmt.Fprint(w, `{"name":"extensions/docker","tags":["1.0.0","1.0.1","1.0.2"]}`)
+			case r.Method == http.MethodDelete && strings.HasPrefix(reference, "sha256:"):
+				mutex.Lock()
+				deleted = append(deleted, reference)
+				mutex.Unlock()
+				w.WriteHeader(http.StatusAccepted)
+			case r.Method == http.MethodDelete:
+				w.WriteHeader(deleteTagStatus)
+			case r.Method == http.MethodHead && reference == "1.0.2":
+				w.Header().Set("Docker-Content-Digest", "sha256:kept")
+				w.WriteHeader(keptDigestStatus)
+			case r.Method == http.MethodHead:
+				w.Header().Set("Docker-Content-Digest", "sha256:"+reference)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		client := newRegistryClient(nil)
+		client.httpClient = server.Client()
+
+		return server, client, strings.TrimPrefix(server.URL, "https://") + "/extensions/docker", func() []string {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return deleted
+		}
+	}
+
+	rules := cleanupRules{keepLast: 1}
+
+	t.Run("DeletesByDigestIfRegistryDoesNotSupportDeletingTags", func(t *testing.T) {
+
+		server, client, repository, getDeleted := newTestRegistry(t, http.StatusMethodNotAllowed, http.StatusOK)
+		defer server.Close()
+
+		// act
+		err := cleanupContainerImage(context.Background(), client, repository, rules, false)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"sha256:1.0.1", "sha256:1.0.0"}, getDeleted())
+	})
+
+	t.Run("DoesNotDeleteByDigestIfDeletingTagFails", func(t *testing.T) {
+
+		server, client, repository, getDeleted := newTestRegistry(t, http.StatusForbidden, http.StatusOK)
+		defer server.Close()
+
+		// act
+		err := cleanupContainerImage(context.Background(), client, repository, rules, false)
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, len(getDeleted()))
+	})
+
+	t.Run("StopsIfDigestOfKeptTagCannotBeRetrieved", func(t *testing.T) {
+
+		server, client, repository, getDeleted := newTestRegistry(t, http.StatusMethodNotAllowed, http.StatusInternalServerError)
+		defer server.Close()
+
+		// act
+		err := cleanupContainerImage(context.Background(), client, repository, rules, false)
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, len(getDeleted()))
+	})
+}
+
+func TestValidateCleanupRetention(t *testing.T) {
+	t.Run("ReturnsErrorIfNoRetentionRuleIsSet", func(t *testing.T) {
+
+		// act
+		err := validateCleanupRetention(0, 0)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForNegativeValues", func(t *testing.T) {
+
+		// act
+		err := validateCleanupRetention(-1, 30)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsNilIfKeepLastOrMaxAgeDaysIsSet", func(t *testing.T) {
+
+		assert.Nil(t, validateCleanupRetention(10, 0))
+		assert.Nil(t, validateCleanupRetention(0, 90))
+	})
+}
@@ -16,4 +16,5 @@ type ContainerRegistryCredentialsAdditionalProperties struct {
 	TrivyVulnerabilityDBGCSBucket  string `json:"trivyVulnerabilityDBGCSBucket,omitempty"`
 	TrivyVulnerabilityDBGCSProject string `json:"trivyVulnerabilityDBGCSProject,omitempty"`
 	ServiceAccountKeyfile          string `json:"serviceAccountKeyfile,omitempty"`
+	Scope                          string `json:"scope,omitempty"`
 }
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	cpy "github.com/otiai10/copy"
+)
+
+// copyEntry is an entry of `copy:`, a literal path or glob pattern with an optional destination relative to the build directory
+type copyEntry struct {
+	source      string
+	destination string
+}
+
+// copyFile is a single file, symlink or empty directory to copy, with the destination relative to the build directory
+type copyFile struct {
+	source      string
+	destination string
+}
+
+// parseCopyEntries splits `copy:` into source:destination entries and exclude patterns starting with !
+func parseCopyEntries(values []string) (entries []copyEntry, excludes []string) {
+
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if strings.HasPrefix(v, "!") {
+			excludes = append(excludes, filepath.ToSlash(filepath.Clean(strings.TrimPrefix(v, "!"))))
+			continue
+		}
+
+		entry := copyEntry{source: v}
+		// don't mistake the colon of a windows drive letter like C:\ for a destination separator
+		if i := strings.LastIndex(v, ":"); i > 0 && !(i == 1 && len(v) > 2 && (v[2] == '\\' || v[2] == '/')) {
+			entry.source, entry.destination = v[:i], v[i+1:]
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, excludes
+}
+
+// isGlobPattern returns true if the path contains any of the glob characters *, ? or [
+func isGlobPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// globToRegexp converts a glob pattern to a regular expression; * and ? don't match /, ** matches any number of directories
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+
+	var expression strings.Builder
+	expression.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '*' && strings.HasPrefix(pattern[i:], "**/"):
+			expression.WriteString("(?:.*/)?")
+			i += 2
+		case c == '*' && strings.HasPrefix(pattern[i:], "**"):
+			expression.WriteString(".*")
+			i++
+		case c == '*':
+			expression.WriteString("[^/]*")
+		case c == '?':
+			expression.WriteString("[^/]")
+		case c == '[':
+			end := strings.Index(pattern[i:], "]")
+			if end < 0 {
+				return nil, fmt.Errorf("glob pattern %v has an unterminated [", pattern)
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expression.WriteString("[" + class + "]")
+			i += end
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expression.WriteString("$")
+
+	return regexp.Compile(expression.String())
+}
+
+// getGlobBase returns the directory part of the pattern before the first glob character, where matching starts
+func getGlobBase(pattern string) string {
+
+	parts := strings.Split(pattern, "/")
+	for i, p := range parts {
+		if isGlobPattern(p) {
+			base := strings.Join(parts[:i], "/")
+			if base == "" && strings.HasPrefix(pattern, "/") {
+				return "/"
+			}
+			if base == "" {
+				return "."
+			}
+			return base
+		}
+	}
+
+	return pattern
+}
+
+// isExcluded returns true if the path or one of its parent directories matches an exclude pattern
+func isExcluded(path string, excludes []*regexp.Regexp) bool {
+	for _, e := range excludes {
+		for p := path; p != "." && p != "/" && p != ""; p = filepath.ToSlash(filepath.Dir(p)) {
+			if e.MatchString(p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getCopyFiles resolves a copy entry into the files to copy; directories are copied with their content, glob matches keep their path relative to the part of the pattern without glob characters
+func getCopyFiles(entry copyEntry, excludes []string) ([]copyFile, error) {
+
+	excludeRegexps := make([]*regexp.Regexp, 0, len(excludes))
+	for _, e := range excludes {
+		r, err := globToRegexp(e)
+		if err != nil {
+			return nil, err
+		}
+		excludeRegexps = append(excludeRegexps, r)
+	}
+
+	source := filepath.ToSlash(filepath.Clean(entry.source))
+	destination := filepath.ToSlash(entry.destination)
+	if destination != "" {
+		if filepath.IsAbs(destination) || strings.HasPrefix(filepath.ToSlash(filepath.Clean(destination)), "../") || filepath.Clean(destination) == ".." {
+			return nil, fmt.Errorf("Destination %v of copy %v needs to be inside the build directory", entry.destination, entry.source)
+		}
+	}
+
+	var base, walkRoot string
+	var matchRegexp *regexp.Regexp
+	if isGlobPattern(source) {
+		base = getGlobBase(source)
+		walkRoot = base
+		var err error
+		matchRegexp, err = globToRegexp(source)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		fi, err := os.Stat(source)
+		if err != nil {
+			return nil, err
+		}
+		// a symlink gets copied as the file or directory it points at, docker can't follow it when it points outside the build context
+		resolvedSource := source
+		if lfi, err := os.Lstat(source); err == nil && lfi.Mode()&fs.ModeSymlink != 0 {
+			resolvedSource, err = filepath.EvalSymlinks(source)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !fi.IsDir() {
+			target := filepath.Base(source)
+			switch {
+			case destination == "":
+			case strings.HasSuffix(destination, "/"):
+				target = filepath.Join(destination, target)
+			default:
+				target = destination
+			}
+			return []copyFile{{source: filepath.ToSlash(resolvedSource), destination: filepath.ToSlash(filepath.Clean(target))}}, nil
+		}
+		base = source
+		walkRoot = resolvedSource
+		if destination == "" {
+			destination = filepath.Base(source)
+		}
+	}
+
+	var files []copyFile
+	err := filepath.WalkDir(walkRoot, func(walkPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// excludes and patterns apply to the path below the source, also when walking the target of a symlink
+		p := walkPath
+		if walkRoot != base {
+			relativeToRoot, err := filepath.Rel(walkRoot, walkPath)
+			if err != nil {
+				return err
+			}
+			p = filepath.Join(base, relativeToRoot)
+		}
+		p = filepath.ToSlash(p)
+		if isExcluded(p, excludeRegexps) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// directories get created along with the files in them, only empty ones need copying
+		if d.IsDir() {
+			empty, err := isEmptyDir(walkPath)
+			if err != nil || !empty {
+				return err
+			}
+		}
+		if matchRegexp != nil && !matchesPathOrParent(p, base, matchRegexp) {
+			return nil
+		}
+		relative, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, copyFile{source: filepath.ToSlash(walkPath), destination: filepath.ToSlash(filepath.Join(destination, relative))})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("Copy %v doesn't match any file", entry.source)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].source < files[j].source
+	})
+
+	return files, nil
+}
+
+// matchesPathOrParent returns true if the path or one of its parent directories below base matches the pattern, so directories matching a pattern get copied with their content
+func matchesPathOrParent(path, base string, pattern *regexp.Regexp) bool {
+	for p := path; p != base && p != "." && p != "/"; p = filepath.ToSlash(filepath.Dir(p)) {
+		if pattern.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isEmptyDir(path string) (bool, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return false, err
+	}
+	return len(entries) == 0, nil
+}
+
+// copyFilesToBuildDirectory copies the files to the build directory, preserving their permissions
+func copyFilesToBuildDirectory(files []copyFile, buildPath string) error {
+
+	options := cpy.Options{
+		PermissionControl: cpy.PerservePermission,
+	}
+	for _, f := range files {
+		target := filepath.Join(buildPath, filepath.FromSlash(f.destination))
+		err := cpy.Copy(filepath.FromSlash(f.source), target, options)
+		if err != nil {
+			return fmt.Errorf("failed copying %v to %v: %w", f.source, target, err)
+		}
+	}
+
+	return nil
+}
+
+// getCopyRoots returns the top level files and directories in the build directory the files get copied to
+func getCopyRoots(files []copyFile) []string {
+
+	roots := map[string]bool{}
+	for _, f := range files {
+		root, _, _ := strings.Cut(f.destination, "/")
+		roots[root] = true
+	}
+
+	sortedRoots := make([]string, 0, len(roots))
+	for r := range roots {
+		sortedRoots = append(sortedRoots, r)
+	}
+	sort.Strings(sortedRoots)
+
+	return sortedRoots
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCopyEntries(t *testing.T) {
+	t.Run("ReturnsEntriesWithDestinationAndExcludes", func(t *testing.T) {
+
+		// act
+		entries, excludes := parseCopyEntries([]string{"app", "bin/*.so:lib/", "config.yaml:conf/app.yaml", "!**/*_test.go", " ", `C:\estafette\app`})
+
+		assert.Equal(t, []copyEntry{
+			{source: "app"},
+			{source: "bin/*.so", destination: "lib/"},
+			{source: "config.yaml", destination: "conf/app.yaml"},
+			{source: `C:\estafette\app`},
+		}, entries)
+		assert.Equal(t, []string{"**/*_test.go"}, excludes)
+	})
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	t.Run("ReturnsRegexpMatchingGlobSemantics", func(t *testing.T) {
+
+		cases := []struct {
+			pattern string
+			path    string
+			matches bool
+		}{
+			{"bin/*.so", "bin/libapp.so", true},
+			{"bin/*.so", "bin/sub/libapp.so", false},
+			{"src/**/*.go", "src/main.go", true},
+			{"src/**/*.go", "src/pkg/api/api.go", true},
+			{"src/**", "src/pkg/api/api.go", true},
+			{"file-?.txt", "file-1.txt", true},
+			{"file-?.txt", "file-10.txt", false},
+			{"file-[!0-4].txt", "file-5.txt", true},
+			{"file-[!0-4].txt", "file-3.txt", false},
+			{"app.v1", "appxv1", false},
+		}
+
+		for _, c := range cases {
+
+			// act
+			r, err := globToRegexp(c.pattern)
+
+			assert.Nil(t, err)
+			assert.Equal(t, c.matches, r.MatchString(c.path), "%v matching %v", c.pattern, c.path)
+		}
+	})
+
+	t.Run("ReturnsErrorForUnterminatedClass", func(t *testing.T) {
+
+		// act
+		_, err := globToRegexp("file-[0-9.txt")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetCopyFiles(t *testing.T) {
+
+	dir := t.TempDir()
+	for _, f := range []string{"bin/libapp.so", "bin/libdb.so", "bin/app", "src/main.go", "src/main_test.go", "src/pkg/api.go", "config.yaml"} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), 0755))
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, f), []byte(f), 0644))
+	}
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "src", "empty"), 0755))
+
+	workingDirectory, _ := os.Getwd()
+	assert.Nil(t, os.Chdir(dir))
+	defer os.Chdir(workingDirectory)
+
+	t.Run("ReturnsLiteralFileWithBaseNameAsDestination", func(t *testing.T) {
+
+		// act
+		files, err := getCopyFiles(copyEntry{source: "./bin/app"}, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []copyFile{{source: "bin/app", destination: "app"}}, files)
+	})
+
+	t.Run("ReturnsLiteralFileWithRenamedDestination", func(t *testing.T) {
+
+		// act
+		files, err := getCopyFiles(copyEntry{source: "config.yaml", destination: "conf/app.yaml"}, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []copyFile{{source: "config.yaml", destination: "conf/app.yaml"}}, files)
+	})
+
+	t.Run("ReturnsLiteralFileInDestinationDirectory", func(t *testing.T) {
+
+		// act
+		files, err := getCopyFiles(copyEntry{source: "config.yaml", destination: "conf/"}, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []copyFile{{source: "config.yaml", destination: "conf/config.yaml"}}, files)
+	})
+
+	t.Run("ReturnsDirectoryContentBelowDirectoryNameWithoutExcludedFiles", func(t *testing.T) {
+
+		// act
+		files, err := getCopyFiles(copyEntry{source: "src"}, []string{"**/*_test.go"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []copyFile{
+			{source: "src/empty", destination: "src/empty"},
+			{source: "src/main.go", destination: "src/main.go"},
+			{source: "src/pkg/api.go", destination: "src/pkg/api.go"},
+		}, files)
+	})
+
+	t.Run("ReturnsContentOfSymlinkedDirectoryOutsideBuildContext", func(t *testing.T) {
+
+		outside := t.TempDir()
+		assert.Nil(t, os.WriteFile(filepath.Join(outside, "ca.crt"), []byte("certificate"), 0644))
+		assert.Nil(t, os.WriteFile(filepath.Join(outside, "ca.key"), []byte("key"), 0644))
+		assert.Nil(t, os.Symlink(outside, filepath.Join(dir, "certs")))
+		defer os.Remove(filepath.Join(dir, "certs"))
+		resolved, _ := filepath.EvalSymlinks(outside)
+
+		// act
+		files, err := getCopyFiles(copyEntry{source: "certs"}, []string{"**/*.key"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []copyFile{{source: filepath.ToSlash(filepath.Join(resolved, "ca.crt")), destination: "certs/ca.crt"}}, files)
+	})
+
+	t.Run("ReturnsGlobMatchesRelativeToPatternBaseInDestination", func(t *testing.T) {
+
+		// act
+		files, err := getCopyFiles(copyEntry{source: "bin/*.so", destination: "lib"}, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []copyFile{
+			{source: "bin/libapp.so", destination: "lib/libapp.so"},
+			{source: "bin/libdb.so", destination: "lib/libdb.so"},
+		}, files)
+	})
+
+	t.Run("ReturnsRecursiveGlobMatches", func(t *testing.T) {
+
+		// act
+		files, err := getCopyFiles(copyEntry{source: "**/*.go"}, []string{"src/*_test.go"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []copyFile{
+			{source: "src/main.go", destination: "src/main.go"},
+			{source: "src/pkg/api.go", destination: "src/pkg/api.go"},
+		}, files)
+	})
+
+	t.Run("ReturnsErrorIfPatternMatchesNothing", func(t *testing.T) {
+
+		// act
+		_, err := getCopyFiles(copyEntry{source: "bin/*.dll"}, nil)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "doesn't match any file")
+	})
+
+	t.Run("ReturnsErrorIfLiteralPathDoesNotExist", func(t *testing.T) {
+
+		// act
+		_, err := getCopyFiles(copyEntry{source: "missing"}, nil)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfDestinationIsOutsideBuildDirectory", func(t *testing.T) {
+
+		// act
+		_, err := getCopyFiles(copyEntry{source: "config.yaml", destination: "../config.yaml"}, nil)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCopyFilesToBuildDirectory(t *testing.T) {
+	t.Run("CopiesFilesPreservingPermissions", func(t *testing.T) {
+
+		sourceDir := t.TempDir()
+		buildDir := t.TempDir()
+		assert.Nil(t, os.WriteFile(filepath.Join(sourceDir, "app"), []byte("app"), 0755))
+		assert.Nil(t, os.WriteFile(filepath.Join(sourceDir, "config.yaml"), []byte("config"), 0600))
+
+		// act
+		err := copyFilesToBuildDirectory([]copyFile{
+			{source: filepath.ToSlash(filepath.Join(sourceDir, "app")), destination: "bin/app"},
+			{source: filepath.ToSlash(filepath.Join(sourceDir, "config.yaml")), destination: "config.yaml"},
+		}, buildDir)
+
+		assert.Nil(t, err)
+		fi, err := os.Stat(filepath.Join(buildDir, "bin", "app"))
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+		fi, err = os.Stat(filepath.Join(buildDir, "config.yaml"))
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	})
+}
+
+func TestGetCopyRoots(t *testing.T) {
+	t.Run("ReturnsTopLevelDestinations", func(t *testing.T) {
+
+		// act
+		roots := getCopyRoots([]copyFile{{destination: "lib/a.so"}, {destination: "lib/b.so"}, {destination: "app"}})
+
+		assert.Equal(t, []string{"app", "lib"}, roots)
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	parserDirectiveRegex = regexp.MustCompile(`^#\s*([a-zA-Z][a-zA-Z0-9_-]*)\s*=\s*(.*?)\s*$`)
+	heredocRegex         = regexp.MustCompile(`<<(-?)(["']?)([A-Za-z_][A-Za-z0-9_]*)(["']?)`)
+	mountOptionRegex     = regexp.MustCompile(`--mount=\S+`)
+)
+
+// heredoc is a here-document started in an instruction like RUN <<EOF, its body ends at a line with only the delimiter
+type heredoc struct {
+	delimiter string
+	stripTabs bool
+}
+
+// getDockerfileSyntax returns the frontend of the `# syntax=` parser directive, which needs to precede any instruction, comment or empty line
+func getDockerfileSyntax(dockerfile string) string {
+
+	for _, line := range strings.Split(strings.TrimPrefix(dockerfile, "\uFEFF"), "\n") {
+		m := parserDirectiveRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			return ""
+		}
+		if strings.EqualFold(m[1], "syntax") {
+			return m[2]
+		}
+	}
+
+	return ""
+}
+
+// getHeredocBodyLines returns which lines of the Dockerfile are part of a heredoc body, including the closing delimiter
+func getHeredocBodyLines(dockerfile string) []bool {
+
+	lines := strings.Split(dockerfile, "\n")
+	isBody := make([]bool, len(lines))
+
+	var pending []heredoc
+	instruction := ""
+	continued := false
+	for i, line := range lines {
+		if len(pending) > 0 {
+			isBody[i] = true
+			text := line
+			if pending[0].stripTabs {
+				text = strings.TrimLeft(text, "\t")
+			}
+			if strings.TrimRight(text, "\r") == pending[0].delimiter {
+				pending = pending[1:]
+			}
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// a line continuing the previous one belongs to the same instruction
+		if !continued {
+			instruction = strings.ToUpper(strings.Fields(trimmed)[0])
+		}
+		continued = strings.HasSuffix(trimmed, "\\")
+
+		// only these instructions take heredocs; elsewhere << is part of a shell command like a here-string
+		if instruction != "RUN" && instruction != "COPY" && instruction != "ADD" {
+			continue
+		}
+		for _, m := range heredocRegex.FindAllStringSubmatchIndex(line, -1) {
+			// a third < makes it a here-string
+			if m[0] > 0 && line[m[0]-1] == '<' {
+				continue
+			}
+			// quotes around the delimiter need to match
+			if line[m[4]:m[5]] != line[m[8]:m[9]] {
+				continue
+			}
+			pending = append(pending, heredoc{delimiter: line[m[6]:m[7]], stripTabs: m[3] > m[2]})
+		}
+	}
+
+	return isBody
+}
+
+// removeHeredocBodies empties the lines of heredoc bodies, so their content doesn't get parsed as instructions; line numbers stay the same
+func removeHeredocBodies(dockerfile string) string {
+
+	lines := strings.Split(dockerfile, "\n")
+	for i, isBody := range getHeredocBodyLines(dockerfile) {
+		if isBody {
+			lines[i] = ""
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// expandDockerfile applies expand to the Dockerfile except for heredoc bodies and --mount options, unless expanding those is requested; docker resolves variables in them itself at build time
+func expandDockerfile(dockerfile string, expand func(string) string, expandHeredocs, expandMounts bool) string {
+
+	lines := strings.Split(dockerfile, "\n")
+	isBody := getHeredocBodyLines(dockerfile)
+
+	for i, line := range lines {
+		if isBody[i] {
+			if expandHeredocs {
+				lines[i] = expand(line)
+			}
+			continue
+		}
+		if expandMounts {
+			lines[i] = expand(line)
+			continue
+		}
+
+		var builder strings.Builder
+		start := 0
+		for _, m := range mountOptionRegex.FindAllStringIndex(line, -1) {
+			builder.WriteString(expand(line[start:m[0]]))
+			builder.WriteString(line[m[0]:m[1]])
+			start = m[1]
+		}
+		builder.WriteString(expand(line[start:]))
+		lines[i] = builder.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// isInMountOption returns true if the position in the line is part of a --mount option
+func isInMountOption(line string, position int) bool {
+	for _, m := range mountOptionRegex.FindAllStringIndex(line, -1) {
+		if position >= m[0] && position < m[1] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDockerfileSyntax(t *testing.T) {
+	t.Run("ReturnsFrontendOfSyntaxDirective", func(t *testing.T) {
+
+		// act
+		syntax := getDockerfileSyntax("# escape=\\\n# syntax = docker/dockerfile:1.7\nFROM alpine:3.19")
+
+		assert.Equal(t, "docker/dockerfile:1.7", syntax)
+	})
+
+	t.Run("ReturnsEmptyStringIfDirectiveFollowsInstruction", func(t *testing.T) {
+
+		// act
+		syntax := getDockerfileSyntax("FROM alpine:3.19\n# syntax=docker/dockerfile:1")
+
+		assert.Equal(t, "", syntax)
+	})
+
+	t.Run("ReturnsEmptyStringIfDirectiveFollowsEmptyLine", func(t *testing.T) {
+
+		// act
+		syntax := getDockerfileSyntax("\n# syntax=docker/dockerfile:1\nFROM alpine:3.19")
+
+		assert.Equal(t, "", syntax)
+	})
+}
+
+func TestGetHeredocBodyLines(t *testing.T) {
+	t.Run("ReturnsLinesOfHeredocBodiesIncludingDelimiter", func(t *testing.T) {
+
+		dockerfile := "FROM alpine:3.19\nRUN <<EOF\necho $HOME\nEOF\nCOPY <<-'first' <<second /app/\n\tfirst file\n\tfirst\nsecond file\nsecond\nCMD [\"sh\"]"
+
+		// act
+		isBody := getHeredocBodyLines(dockerfile)
+
+		assert.Equal(t, []bool{false, false, true, true, false, true, true, true, true, false}, isBody)
+	})
+
+	t.Run("IgnoresShiftsAndComments", func(t *testing.T) {
+
+		// act
+		isBody := getHeredocBodyLines("# use <<EOF for scripts\nRUN echo $((1<<2))\nFROM alpine:3.19")
+
+		assert.Equal(t, []bool{false, false, false}, isBody)
+	})
+
+	t.Run("IgnoresHereStringsAndOtherInstructions", func(t *testing.T) {
+
+		// act
+		isBody := getHeredocBodyLines("FROM golang AS builder\nRUN read x <<<hello\nENV TEXT=<<EOF\nFROM alpine:3.19")
+
+		assert.Equal(t, []bool{false, false, false, false}, isBody)
+	})
+
+	t.Run("ReturnsHeredocBodyOfContinuedRunInstruction", func(t *testing.T) {
+
+		// act
+		isBody := getHeredocBodyLines("FROM alpine:3.19\nRUN --mount=type=cache,target=/root/.cache \\\n  # comment\n  sh <<EOF\necho hello\nEOF\nFROM alpine:3.19")
+
+		assert.Equal(t, []bool{false, false, false, false, true, true, false}, isBody)
+	})
+}
+
+func TestRemoveHeredocBodies(t *testing.T) {
+	t.Run("ReturnsDockerfileWithEmptiedHeredocBodies", func(t *testing.T) {
+
+		// act
+		dockerfile := removeHeredocBodies("FROM postgres:16\nCOPY <<EOF /docker-entrypoint-initdb.d/init.sql\nSELECT 1\nFROM users;\nEOF\nUSER postgres")
+
+		assert.Equal(t, "FROM postgres:16\nCOPY <<EOF /docker-entrypoint-initdb.d/init.sql\n\n\n\nUSER postgres", dockerfile)
+	})
+}
+
+func TestExpandDockerfile(t *testing.T) {
+
+	dockerfile := "FROM golang:${GO_VERSION}\nRUN --mount=type=cache,target=${GOCACHE} <<EOF\ngo build -o ${OUT} .\nEOF"
+	expand := func(text string) string {
+		return strings.ReplaceAll(text, "$", "%")
+	}
+
+	t.Run("ReturnsDockerfileWithoutExpandingHeredocsAndMounts", func(t *testing.T) {
+
+		// act
+		expanded := expandDockerfile(dockerfile, expand, false, false)
+
+		assert.Equal(t, "FROM golang:%{GO_VERSION}\nRUN --mount=type=cache,target=${GOCACHE} <<EOF\ngo build -o ${OUT} .\nEOF", expanded)
+	})
+
+	t.Run("ReturnsDockerfileWithExpandedHeredocsAndMountsIfRequested", func(t *testing.T) {
+
+		// act
+		expanded := expandDockerfile(dockerfile, expand, true, true)
+
+		assert.Equal(t, "FROM golang:%{GO_VERSION}\nRUN --mount=type=cache,target=%{GOCACHE} <<EOF\ngo build -o %{OUT} .\nEOF", expanded)
+	})
+}
+
+func TestExpandEnvironmentVariablesIfSet(t *testing.T) {
+	t.Run("LeavesVariablesInHeredocBodiesForDocker", func(t *testing.T) {
+
+		os.Setenv("TEST_APP", "estafette")
+		defer os.Unsetenv("TEST_APP")
+		os.Setenv("TEST_HEREDOC_DIR", "/app")
+		defer os.Unsetenv("TEST_HEREDOC_DIR")
+		dontExpand := "PATH"
+
+		// act
+		expanded := expandEnvironmentVariablesIfSet("FROM alpine:3.19\nLABEL app=${TEST_APP}\nRUN <<EOF\necho $TEST_HEREDOC_DIR\nEOF", &dontExpand)
+
+		assert.Equal(t, "FROM alpine:3.19\nLABEL app=estafette\nRUN <<EOF\necho $TEST_HEREDOC_DIR\nEOF", expanded)
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var (
+	templateErrorLineRegex = regexp.MustCompile(`^template: [^:]*:(\d+)(?::\d+)?: `)
+)
+
+// dockerfileTemplateData is the data available when rendering a Dockerfile with `template: gotemplate`; next to the build metadata of tag templates it has the labels and the env vars listed in templateEnv
+type dockerfileTemplateData struct {
+	templateData
+	Labels map[string]string
+	Env    map[string]string
+}
+
+// dockerfileTemplateFuncs are the tag template functions plus helpers for looping over lists
+var dockerfileTemplateFuncs = func() template.FuncMap {
+	funcs := template.FuncMap{
+		"split": func(separator, value string) []string {
+			if value == "" {
+				return []string{}
+			}
+			return strings.Split(value, separator)
+		},
+		"join": func(separator string, values []string) string {
+			return strings.Join(values, separator)
+		},
+		"trim": strings.TrimSpace,
+		"hasPrefix": func(prefix, value string) bool {
+			return strings.HasPrefix(value, prefix)
+		},
+		"hasSuffix": func(suffix, value string) bool {
+			return strings.HasSuffix(value, suffix)
+		},
+	}
+	for name, f := range templateFuncs {
+		funcs[name] = f
+	}
+	return funcs
+}()
+
+// newDockerfileTemplateData collects the build metadata, labels and the values of the env vars in templateEnv
+func newDockerfileTemplateData(container string, labels map[string]string, templateEnv []string) dockerfileTemplateData {
+
+	data := dockerfileTemplateData{
+		templateData: newTemplateData(container),
+		Labels:       labels,
+		Env:          map[string]string{},
+	}
+	if data.Labels == nil {
+		data.Labels = map[string]string{}
+	}
+	for _, name := range templateEnv {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			data.Env[name] = os.Getenv(name)
+		}
+	}
+
+	return data
+}
+
+// renderDockerfileTemplate renders the Dockerfile as go template; errors mention the line of the Dockerfile they occur on
+func renderDockerfileTemplate(dockerfile, dockerfilePath string, data dockerfileTemplateData) (string, error) {
+
+	tmpl, err := template.New("Dockerfile").Funcs(dockerfileTemplateFuncs).Option("missingkey=error").Parse(dockerfile)
+	if err != nil {
+		return "", getDockerfileTemplateError("parsing", dockerfile, dockerfilePath, err)
+	}
+
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, data)
+	if err != nil {
+		return "", getDockerfileTemplateError("rendering", dockerfile, dockerfilePath, err)
+	}
+
+	return buffer.String(), nil
+}
+
+// getDockerfileTemplateError returns the template error with the line number and content of the Dockerfile line it refers to
+func getDockerfileTemplateError(stage, dockerfile, dockerfilePath string, err error) error {
+
+	message := err.Error()
+	matches := templateErrorLineRegex.FindStringSubmatch(message)
+	if matches == nil {
+		return fmt.Errorf("failed %v Dockerfile template %v: %w", stage, dockerfilePath, err)
+	}
+
+	line, _ := strconv.Atoi(matches[1])
+	message = strings.TrimPrefix(message, matches[0])
+	lines := strings.Split(dockerfile, "\n")
+	if line < 1 || line > len(lines) {
+		return fmt.Errorf("failed %v Dockerfile template %v at line %v: %v", stage, dockerfilePath, line, message)
+	}
+
+	return fmt.Errorf("failed %v Dockerfile template %v at line %v: %v\n%4d | %v", stage, dockerfilePath, line, message, line, lines[line-1])
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderDockerfileTemplate(t *testing.T) {
+
+	data := dockerfileTemplateData{
+		templateData: templateData{
+			Branch:  "feature/debug",
+			Version: "1.4.7",
+		},
+		Labels: map[string]string{"team": "estafette"},
+		Env:    map[string]string{"PACKAGES": "curl,git"},
+	}
+
+	t.Run("ReturnsDockerfileWithConditionalStage", func(t *testing.T) {
+
+		dockerfile := `FROM alpine:3.19
+{{- if ne .Branch "main" }}
+RUN apk add --no-cache strace
+{{- end }}
+LABEL team={{ .Labels.team }} version={{ .Version }}`
+
+		// act
+		rendered, err := renderDockerfileTemplate(dockerfile, "Dockerfile", data)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "FROM alpine:3.19\nRUN apk add --no-cache strace\nLABEL team=estafette version=1.4.7", rendered)
+	})
+
+	t.Run("ReturnsDockerfileWithLoopOverEnvList", func(t *testing.T) {
+
+		dockerfile := `FROM alpine:3.19
+{{- range split "," .Env.PACKAGES }}
+RUN apk add --no-cache {{ . }}
+{{- end }}`
+
+		// act
+		rendered, err := renderDockerfileTemplate(dockerfile, "Dockerfile", data)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "FROM alpine:3.19\nRUN apk add --no-cache curl\nRUN apk add --no-cache git", rendered)
+	})
+
+	t.Run("KeepsEnvironmentVariablesForExpansion", func(t *testing.T) {
+
+		// act
+		rendered, err := renderDockerfileTemplate("FROM alpine:${ALPINE_VERSION}", "Dockerfile", data)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "FROM alpine:${ALPINE_VERSION}", rendered)
+	})
+
+	t.Run("ReturnsErrorWithLineNumberForParseError", func(t *testing.T) {
+
+		dockerfile := "FROM alpine:3.19\n{{ if .Branch }}\nRUN true"
+
+		// act
+		_, err := renderDockerfileTemplate(dockerfile, "Dockerfile", data)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "failed parsing Dockerfile template Dockerfile at line")
+	})
+
+	t.Run("ReturnsErrorWithLineNumberAndContentForMissingEnvVar", func(t *testing.T) {
+
+		dockerfile := "FROM alpine:3.19\nRUN echo {{ .Env.MISSING }}"
+
+		// act
+		_, err := renderDockerfileTemplate(dockerfile, "Dockerfile", data)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "failed rendering Dockerfile template Dockerfile at line 2: ")
+		assert.Contains(t, err.Error(), "   2 | RUN echo {{ .Env.MISSING }}")
+	})
+}
+
+func TestNewDockerfileTemplateData(t *testing.T) {
+	t.Run("ReturnsOnlyListedEnvVars", func(t *testing.T) {
+
+		os.Setenv("TEMPLATE_PACKAGES", "curl")
+		defer os.Unsetenv("TEMPLATE_PACKAGES")
+		os.Setenv("TEMPLATE_SECRET", "secret")
+		defer os.Unsetenv("TEMPLATE_SECRET")
+
+		// act
+		data := newDockerfileTemplateData("docker", nil, []string{"TEMPLATE_PACKAGES", " TEMPLATE_UNSET", ""})
+
+		assert.Equal(t, map[string]string{"TEMPLATE_PACKAGES": "curl", "TEMPLATE_UNSET": ""}, data.Env)
+		assert.Equal(t, map[string]string{}, data.Labels)
+		assert.Equal(t, "docker", data.Container)
+	})
+}
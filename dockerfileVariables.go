@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	dockerfileVariableRegex     = regexp.MustCompile(`\$(?:\{([A-Za-z_][A-Za-z0-9_]*)([^}]*)\}|([A-Za-z_][A-Za-z0-9_]*))`)
+	dockerfileDeclarationRegex  = regexp.MustCompile(`(?mi)^\s*(?:ARG|ENV)\s+(.+)$`)
+	secretEnvarNameRegex        = regexp.MustCompile(`(?i)(SECRET|TOKEN|PASSWORD|PASSWD|PRIVATE_?KEY|API_?KEY)`)
+	credentialsEnvarNameRegex   = regexp.MustCompile(`(?i)CREDENTIALS?$`)
+	uppercaseVariableNameRegex  = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+	predefinedDockerfileVarsMap = map[string]bool{
+		"HOME": true, "PWD": true, "HOSTNAME": true, "USER": true, "SHELL": true,
+		"TARGETPLATFORM": true, "TARGETOS": true, "TARGETARCH": true, "TARGETVARIANT": true,
+		"BUILDPLATFORM": true, "BUILDOS": true, "BUILDARCH": true, "BUILDVARIANT": true,
+		"HTTP_PROXY": true, "HTTPS_PROXY": true, "FTP_PROXY": true, "NO_PROXY": true, "ALL_PROXY": true,
+	}
+)
+
+// minSecretLength avoids masking short values like `1` or `true` everywhere in the log
+const minSecretLength = 6
+
+// unresolvedVariable is a variable reference left in the Dockerfile after expansion that isn't declared with ARG or ENV
+type unresolvedVariable struct {
+	name string
+	line int
+}
+
+// getUnresolvedVariables returns the uppercase variable references in the expanded Dockerfile that aren't declared with ARG or ENV, excluded from expansion or predefined by docker; references with a default like ${VAR:-value} resolve in docker itself
+func getUnresolvedVariables(dockerfile string, dontExpand []string) []unresolvedVariable {
+
+	declared := map[string]bool{}
+	for _, m := range dockerfileDeclarationRegex.FindAllStringSubmatch(dockerfile, -1) {
+		for _, field := range splitQuotedFields(m[1]) {
+			name, _, _ := strings.Cut(field, "=")
+			declared[name] = true
+		}
+	}
+
+	var unresolved []unresolvedVariable
+	isHeredocBody := getHeredocBodyLines(dockerfile)
+	for i, line := range strings.Split(dockerfile, "\n") {
+		// variables in heredoc bodies are usually shell variables resolved when running
+		if isHeredocBody[i] || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, m := range dockerfileVariableRegex.FindAllStringSubmatchIndex(line, -1) {
+			// an escaped \$ is a literal dollar sign
+			if m[0] > 0 && line[m[0]-1] == '\\' {
+				continue
+			}
+			// mount options get resolved by docker itself
+			if !*expandMounts && isInMountOption(line, m[0]) {
+				continue
+			}
+			name, modifier := "", ""
+			if m[2] >= 0 {
+				name, modifier = line[m[2]:m[3]], line[m[4]:m[5]]
+			} else {
+				name = line[m[6]:m[7]]
+			}
+			if strings.HasPrefix(modifier, ":-") || strings.HasPrefix(modifier, ":+") {
+				continue
+			}
+			if !uppercaseVariableNameRegex.MatchString(name) || declared[name] || predefinedDockerfileVarsMap[name] || contains(dontExpand, name) {
+				continue
+			}
+			unresolved = append(unresolved, unresolvedVariable{name: name, line: i + 1})
+		}
+	}
+
+	return unresolved
+}
+
+// getSecretValues returns the values of environment variables with secret-looking names and the passwords and keys of the injected credentials
+func getSecretValues(credentials []ContainerRegistryCredentials) []string {
+
+	values := map[string]bool{}
+	for _, e := range os.Environ() {
+		name, value, _ := strings.Cut(e, "=")
+		if isSecretEnvar(name, value) {
+			values[value] = true
+		}
+	}
+	for _, c := range credentials {
+		for _, value := range []string{c.AdditionalProperties.Password, c.AdditionalProperties.ServiceAccountKeyfile} {
+			if len(value) >= minSecretLength {
+				values[value] = true
+			}
+		}
+	}
+
+	secretValues := make([]string, 0, len(values))
+	for v := range values {
+		secretValues = append(secretValues, v)
+	}
+	// mask longer values first, so a secret containing another one gets masked completely
+	sort.Slice(secretValues, func(i, j int) bool {
+		if len(secretValues[i]) != len(secretValues[j]) {
+			return len(secretValues[i]) > len(secretValues[j])
+		}
+		return secretValues[i] < secretValues[j]
+	})
+
+	return secretValues
+}
+
+// isSecretEnvar returns true for environment variables with a secret-looking name and a value long enough to mask; variables named like credentials often hold the path to a credentials file instead, like GOOGLE_APPLICATION_CREDENTIALS, which isn't a secret
+func isSecretEnvar(name, value string) bool {
+	if len(value) < minSecretLength {
+		return false
+	}
+	if secretEnvarNameRegex.MatchString(name) {
+		return true
+	}
+	return credentialsEnvarNameRegex.MatchString(name) && !filepath.IsAbs(value) && !strings.HasPrefix(value, "./") && !strings.HasPrefix(value, "../")
+}
+
+// getSecretVariables returns the names of the variables expansion would replace with a secret value, or that have a secret-looking name
+func getSecretVariables(dockerfile string, dontExpand *string, secretValues []string) []string {
+
+	names := map[string]bool{}
+	// only the parts expansion touches count, heredoc bodies and mount options are left to docker
+	expandDockerfile(dockerfile, func(text string) string {
+		return os.Expand(text, func(envar string) string {
+			if dontExpand != nil && contains(strings.Split(*dontExpand, ","), envar) {
+				return ""
+			}
+			value := os.Getenv(envar)
+			if value == "" {
+				return ""
+			}
+			if isSecretEnvar(envar, value) {
+				names[envar] = true
+			}
+			for _, s := range secretValues {
+				if strings.Contains(value, s) {
+					names[envar] = true
+				}
+			}
+			return ""
+		})
+	}, *expandHeredocs, *expandMounts)
+
+	secretNames := make([]string, 0, len(names))
+	for n := range names {
+		secretNames = append(secretNames, n)
+	}
+	sort.Strings(secretNames)
+
+	return secretNames
+}
+
+// maskSecrets replaces all secret values in text with ***
+func maskSecrets(text string, secretValues []string) string {
+	for _, s := range secretValues {
+		text = strings.ReplaceAll(text, s, "***")
+	}
+	return text
+}
+
+// checkRenderedDockerfile refuses a Dockerfile template that renders secret values into the Dockerfile unless allowed, since they would end up in the image and the log
+func checkRenderedDockerfile(templateDockerfile, renderedDockerfile string, secretValues []string) error {
+
+	if *allowSecretExpansion {
+		return nil
+	}
+	for _, s := range secretValues {
+		if strings.Count(renderedDockerfile, s) > strings.Count(templateDockerfile, s) {
+			return fmt.Errorf("Refusing to render secrets into the Dockerfile from its template; pass them as build secret or set allowSecretExpansion")
+		}
+	}
+
+	return nil
+}
+
+// checkDockerfileVariables refuses to expand secrets into the Dockerfile unless allowed and reports unresolved variables in the expanded Dockerfile
+func checkDockerfileVariables(sourceDockerfile, expandedDockerfile string, secretValues []string) error {
+
+	if secretNames := getSecretVariables(sourceDockerfile, dontExpand, secretValues); len(secretNames) > 0 && !*allowSecretExpansion {
+		return fmt.Errorf("Refusing to expand %v into the Dockerfile since they contain secrets; pass them as build secret, add them to dontExpand or set allowSecretExpansion", strings.Join(secretNames, ", "))
+	}
+
+	if *unresolvedVariables == "ignore" {
+		return nil
+	}
+	var references []string
+	for _, v := range getUnresolvedVariables(expandedDockerfile, strings.Split(*dontExpand, ",")) {
+		references = append(references, fmt.Sprintf("${%v} at line %v", v.name, v.line))
+	}
+	if len(references) == 0 {
+		return nil
+	}
+	if *unresolvedVariables == "fail" {
+		return fmt.Errorf("The Dockerfile has unresolved variables %v; set them as environment variable, declare them with ARG or add them to dontExpand", strings.Join(references, ", "))
+	}
+	for _, r := range references {
+		log.Warn().Msgf("Unresolved variable %v in the Dockerfile; set it as environment variable, declare it with ARG or add it to dontExpand", r)
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// buildImage is a single container image to build, push or tag; without `images:` the stage parameters describe the only one
+type buildImage struct {
+	Container     string            `yaml:"container"`
+	Dockerfile    string            `yaml:"dockerfile"`
+	Inline        string            `yaml:"inline"`
+	Path          string            `yaml:"path"`
+	Copy          []string          `yaml:"copy"`
+	Args          []string          `yaml:"args"`
+	ArgsFile      string            `yaml:"argsFile"`
+	Tags          []string          `yaml:"tags"`
+	Repositories  []string          `yaml:"repositories"`
+	SmokeTest     *smokeTest        `yaml:"smokeTest"`
+	Labels        map[string]string `yaml:"labels"`
+	Annotations   map[string]string `yaml:"annotations"`
+	MaxImageSize  string            `yaml:"maxImageSize"`
+	MaxLayerCount int               `yaml:"maxLayerCount"`
+	MaxLayerSize  string            `yaml:"maxLayerSize"`
+	Tests         []structureTest   `yaml:"tests"`
+	Template      string            `yaml:"template"`
+	Target        string            `yaml:"target"`
+	Outputs       []buildOutput     `yaml:"outputs"`
+	Contexts      map[string]string `yaml:"contexts"`
+
+	// semverFloatingTags are the major and major.minor tags added to tags when semverTags is enabled
+	semverFloatingTags []string
+}
+
+// withDefaults returns the image with all unset fields inherited from the stage parameters; lists set to [] explicitly are not inherited, labels and annotations are merged
+func (i buildImage) withDefaults(defaults buildImage) buildImage {
+	if i.Container == "" {
+		i.Container = defaults.Container
+	}
+	if i.Dockerfile == "" && i.Inline == "" {
+		i.Dockerfile = defaults.Dockerfile
+		i.Inline = defaults.Inline
+	}
+	if i.Path == "" {
+		i.Path = defaults.Path
+	}
+	if i.Copy == nil {
+		i.Copy = defaults.Copy
+	}
+	if i.Args == nil {
+		i.Args = defaults.Args
+	}
+	if i.ArgsFile == "" {
+		i.ArgsFile = defaults.ArgsFile
+	}
+	if i.Tags == nil {
+		i.Tags = defaults.Tags
+	}
+	if i.Repositories == nil {
+		i.Repositories = defaults.Repositories
+	}
+	if i.SmokeTest == nil {
+		i.SmokeTest = defaults.SmokeTest
+	}
+	if i.MaxImageSize == "" {
+		i.MaxImageSize = defaults.MaxImageSize
+	}
+	if i.MaxLayerCount == 0 {
+		i.MaxLayerCount = defaults.MaxLayerCount
+	}
+	if i.MaxLayerSize == "" {
+		i.MaxLayerSize = defaults.MaxLayerSize
+	}
+	if i.Tests == nil {
+		i.Tests = defaults.Tests
+	}
+	if i.Template == "" {
+		i.Template = defaults.Template
+	}
+	if i.Target == "" {
+		i.Target = defaults.Target
+	}
+	if i.Outputs == nil {
+		i.Outputs = defaults.Outputs
+	}
+	i.Labels = mergeStringMaps(defaults.Labels, i.Labels)
+	i.Annotations = mergeStringMaps(defaults.Annotations, i.Annotations)
+	i.Contexts = mergeStringMaps(defaults.Contexts, i.Contexts)
+	i.Container = os.ExpandEnv(i.Container)
+
+	return i
+}
+
+// sizeBudgets returns the parsed size limits for the image
+func (i buildImage) sizeBudgets() (sizeBudgets, error) {
+
+	maxImageSize, err := parseByteSize(i.MaxImageSize)
+	if err != nil {
+		return sizeBudgets{}, fmt.Errorf("invalid maxImageSize: %w", err)
+	}
+	maxLayerSize, err := parseByteSize(i.MaxLayerSize)
+	if err != nil {
+		return sizeBudgets{}, fmt.Errorf("invalid maxLayerSize: %w", err)
+	}
+	if i.MaxLayerCount < 0 {
+		return sizeBudgets{}, fmt.Errorf("maxLayerCount can't be negative")
+	}
+
+	return sizeBudgets{maxImageSize: maxImageSize, maxLayerCount: i.MaxLayerCount, maxLayerSize: maxLayerSize}, nil
+}
+
+// getBuildImages returns the images listed in imagesValue (yaml or json) or in the yaml file at imagesFilePath with defaults applied, or only the defaults if neither is set
+func getBuildImages(defaults buildImage, imagesValue, imagesFilePath string) ([]buildImage, error) {
+
+	if imagesValue != "" && imagesFilePath != "" {
+		return nil, fmt.Errorf("Set either `images:` or `imagesFile:`, not both")
+	}
+
+	var images []buildImage
+	switch {
+	case imagesValue != "":
+		err := yaml.Unmarshal([]byte(imagesValue), &images)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing `images:`: %w", err)
+		}
+
+	case imagesFilePath != "":
+		log.Info().Msgf("Reading images from file %v...", imagesFilePath)
+		data, err := os.ReadFile(imagesFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading images file %v: %w", imagesFilePath, err)
+		}
+		var imagesFile struct {
+			Images []buildImage `yaml:"images"`
+		}
+		err = yaml.Unmarshal(data, &imagesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing images file %v: %w", imagesFilePath, err)
+		}
+		images = imagesFile.Images
+
+	default:
+		return []buildImage{defaults}, nil
+	}
+
+	if len(images) == 0 {
+		return nil, fmt.Errorf("The list of images is empty; add at least one image with a `container:`")
+	}
+
+	containers := map[string]bool{}
+	for i := range images {
+		images[i] = images[i].withDefaults(defaults)
+		if containers[images[i].Container] {
+			return nil, fmt.Errorf("Container %v is listed more than once in images; set a unique `container:` for each image", images[i].Container)
+		}
+		containers[images[i].Container] = true
+
+		if images[i].SmokeTest != nil {
+			err := images[i].SmokeTest.validate()
+			if err != nil {
+				return nil, fmt.Errorf("Invalid smoke test for container %v: %w", images[i].Container, err)
+			}
+		}
+
+		if images[i].Template != "" && images[i].Template != "none" && images[i].Template != "gotemplate" {
+			return nil, fmt.Errorf("Template %v for container %v is not supported, use none or gotemplate", images[i].Template, images[i].Container)
+		}
+
+		err := validateStructureTests(images[i].Tests)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid structure tests for container %v: %w", images[i].Container, err)
+		}
+
+		for _, o := range images[i].Outputs {
+			err := o.validate()
+			if err != nil {
+				return nil, fmt.Errorf("Invalid outputs for container %v: %w", images[i].Container, err)
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// prepare renders the tags, adds the floating semver tags when promoting a version and validates the image for the action
+func (image *buildImage) prepare(action, version, versionTagPrefix, versionTagSuffix string) error {
+
+	if len(image.Repositories) == 0 && action != "history" {
+		return fmt.Errorf("Set `repositories:` to list at least one `- <repository>` (for example like `- extensions`)")
+	}
+
+	var renderedTags []string
+	data := newTemplateData(image.Container)
+	for _, t := range image.Tags {
+		renderedTag, err := renderTag(t, data)
+		if err != nil {
+			return fmt.Errorf("Invalid tag: %w", err)
+		}
+		renderedTags = append(renderedTags, renderedTag)
+	}
+	image.Tags = renderedTags
+
+	// add major and major.minor tags for the build version when promoting a version
+	if *semverTags && (action == "push" || action == "tag") {
+		var err error
+		image.semverFloatingTags, err = getSemverFloatingTags(version, versionTagPrefix, versionTagSuffix)
+		if err != nil {
+			return fmt.Errorf("Can't use semverTags for a build version that isn't a semantic version: %w", err)
+		}
+		if len(image.semverFloatingTags) == 0 {
+			log.Info().Msgf("Version %v is a pre-release, not moving any floating semver tags", version)
+		}
+		for _, t := range image.semverFloatingTags {
+			if !contains(image.Tags, t) {
+				image.Tags = append(image.Tags, t)
+			}
+		}
+	}
+
+	err := validateContainerImages(action, image.Repositories, image.Container, image.Tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = image.sizeBudgets()
+	if err != nil {
+		return fmt.Errorf("Invalid size budget: %w", err)
+	}
+
+	return nil
+}
+
+// imageResult is the outcome of an action for a single image, used in the combined report
+type imageResult struct {
+	container string
+	duration  time.Duration
+	err       error
+}
+
+// logImagesReport logs the outcome for every image and returns an error if any of them failed
+func logImagesReport(action string, results []imageResult) error {
+
+	var failedContainers []string
+	log.Info().Msgf("Report for action %v on %v images:", action, len(results))
+	for _, r := range results {
+		if r.err != nil {
+			failedContainers = append(failedContainers, r.container)
+			log.Error().Err(r.err).Msgf("- %v failed after %v", r.container, r.duration.Round(time.Second))
+		} else {
+			log.Info().Msgf("- %v succeeded in %v", r.container, r.duration.Round(time.Second))
+		}
+	}
+
+	if len(failedContainers) > 0 {
+		return fmt.Errorf("%v of %v images failed: %v", len(failedContainers), len(results), strings.Join(failedContainers, ", "))
+	}
+
+	return nil
+}
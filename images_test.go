@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBuildImages(t *testing.T) {
+
+	defaults := buildImage{
+		Container:    "docker",
+		Dockerfile:   "Dockerfile",
+		Path:         ".",
+		Copy:         []string{"/etc/ssl/certs/ca-certificates.crt"},
+		Args:         []string{"NPM_TOKEN"},
+		Tags:         []string{"dev"},
+		Repositories: []string{"extensions"},
+	}
+
+	t.Run("ReturnsDefaultsIfImagesAreNotSet", func(t *testing.T) {
+
+		// act
+		images, err := getBuildImages(defaults, "", "")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []buildImage{defaults}, images)
+	})
+
+	t.Run("ReturnsImagesWithUnsetFieldsInheritedFromDefaults", func(t *testing.T) {
+
+		imagesValue := `
+- container: docker-test
+  dockerfile: Dockerfile.test
+  tags:
+  - test
+- container: docker-slim
+  inline: |
+    FROM scratch
+  copy: []`
+
+		// act
+		images, err := getBuildImages(defaults, imagesValue, "")
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(images)) {
+			assert.Equal(t, buildImage{
+				Container:    "docker-test",
+				Dockerfile:   "Dockerfile.test",
+				Path:         ".",
+				Copy:         []string{"/etc/ssl/certs/ca-certificates.crt"},
+				Args:         []string{"NPM_TOKEN"},
+				Tags:         []string{"test"},
+				Repositories: []string{"extensions"},
+			}, images[0])
+			assert.Equal(t, buildImage{
+				Container:    "docker-slim",
+				Inline:       "FROM scratch\n",
+				Path:         ".",
+				Copy:         []string{},
+				Args:         []string{"NPM_TOKEN"},
+				Tags:         []string{"dev"},
+				Repositories: []string{"extensions"},
+			}, images[1])
+		}
+	})
+
+	t.Run("ReturnsImagesFromJson", func(t *testing.T) {
+
+		imagesValue := `[{"container":"docker-test","repositories":["estafette"]}]`
+
+		// act
+		images, err := getBuildImages(defaults, imagesValue, "")
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(images)) {
+			assert.Equal(t, "docker-test", images[0].Container)
+			assert.Equal(t, []string{"estafette"}, images[0].Repositories)
+		}
+	})
+
+	t.Run("ReturnsImagesFromFile", func(t *testing.T) {
+
+		imagesFilePath := filepath.Join(t.TempDir(), "images.yaml")
+		err := os.WriteFile(imagesFilePath, []byte("images:\n- container: docker-test\n- container: docker-slim\n"), 0644)
+		assert.Nil(t, err)
+
+		// act
+		images, err := getBuildImages(defaults, "", imagesFilePath)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(images)) {
+			assert.Equal(t, "docker-test", images[0].Container)
+			assert.Equal(t, "docker-slim", images[1].Container)
+			assert.Equal(t, "Dockerfile", images[1].Dockerfile)
+		}
+	})
+
+	t.Run("ReturnsErrorIfBothImagesAndImagesFileAreSet", func(t *testing.T) {
+
+		// act
+		_, err := getBuildImages(defaults, "- container: docker-test", "images.yaml")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfImagesListIsEmpty", func(t *testing.T) {
+
+		// act
+		_, err := getBuildImages(defaults, "[]", "")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfContainerIsListedMoreThanOnce", func(t *testing.T) {
+
+		// act
+		_, err := getBuildImages(defaults, "- dockerfile: Dockerfile.test\n- dockerfile: Dockerfile.slim", "")
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "docker")
+	})
+}
+
+func TestPrepare(t *testing.T) {
+	t.Run("AddsFloatingSemverTagsWithoutVersionTagWhenNotPushingVersionTag", func(t *testing.T) {
+
+		originalSemverTags, originalPushVersionTag := *semverTags, *pushVersionTag
+		defer func() { *semverTags, *pushVersionTag = originalSemverTags, originalPushVersionTag }()
+		*semverTags = true
+		*pushVersionTag = false
+
+		image := buildImage{Container: "docker", Repositories: []string{"extensions"}, Tags: []string{"stable"}}
+
+		// act
+		err := image.prepare("push", "1.2.3", "", "")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"stable", "1", "1.2"}, image.Tags)
+	})
+
+	t.Run("ReturnsErrorForNonSemanticVersionWithSemverTags", func(t *testing.T) {
+
+		originalSemverTags := *semverTags
+		defer func() { *semverTags = originalSemverTags }()
+		*semverTags = true
+
+		image := buildImage{Container: "docker", Repositories: []string{"extensions"}}
+
+		// act
+		err := image.prepare("push", "main-abc", "", "")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForInvalidContainer", func(t *testing.T) {
+
+		image := buildImage{Container: "Docker", Repositories: []string{"extensions"}}
+
+		// act
+		err := image.prepare("build", "1.2.3", "", "")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorWithoutRepositories", func(t *testing.T) {
+
+		image := buildImage{Container: "docker"}
+
+		// act
+		err := image.prepare("build", "1.2.3", "", "")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestLogImagesReport(t *testing.T) {
+	t.Run("ReturnsNilIfAllImagesSucceeded", func(t *testing.T) {
+
+		results := []imageResult{{container: "docker"}, {container: "docker-test"}}
+
+		// act
+		err := logImagesReport("build", results)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorListingFailedImages", func(t *testing.T) {
+
+		results := []imageResult{{container: "docker"}, {container: "docker-test", err: errors.New("build failed")}, {container: "docker-slim", err: errors.New("scan failed")}}
+
+		// act
+		err := logImagesReport("build", results)
+
+		assert.NotNil(t, err)
+		assert.Equal(t, "2 of 3 images failed: docker-test, docker-slim", err.Error())
+	})
+}
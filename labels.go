@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ociLabelSource   = "org.opencontainers.image.source"
+	ociLabelRevision = "org.opencontainers.image.revision"
+	ociLabelVersion  = "org.opencontainers.image.version"
+	ociLabelCreated  = "org.opencontainers.image.created"
+	ociLabelTitle    = "org.opencontainers.image.title"
+)
+
+var (
+	labelInstructionRegex = regexp.MustCompile(`(?i)^\s*LABEL\s+(.*)$`)
+)
+
+// parseStringMap parses a map like `labels:` passed as yaml or json
+func parseStringMap(value, name string) (map[string]string, error) {
+
+	if value == "" {
+		return nil, nil
+	}
+
+	var values map[string]string
+	err := yaml.Unmarshal([]byte(value), &values)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing `%v:`: %w", name, err)
+	}
+
+	return values, nil
+}
+
+// mergeStringMaps returns a new map with the values of overrides replacing those in defaults
+func mergeStringMaps(defaults, overrides map[string]string) map[string]string {
+
+	if defaults == nil && overrides == nil {
+		return nil
+	}
+
+	merged := map[string]string{}
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+// getStandardLabels returns the org.opencontainers.image labels derived from the git repository and build metadata
+func getStandardLabels(gitSource, gitOwner, gitName, revision, version, container string, created time.Time) map[string]string {
+
+	labels := map[string]string{
+		ociLabelCreated: created.UTC().Format(time.RFC3339),
+	}
+	if gitSource != "" && gitOwner != "" && gitName != "" {
+		labels[ociLabelSource] = fmt.Sprintf("https://%v/%v/%v", gitSource, gitOwner, gitName)
+	}
+	if revision != "" {
+		labels[ociLabelRevision] = revision
+	}
+	if version != "" {
+		labels[ociLabelVersion] = version
+	}
+	if container != "" {
+		labels[ociLabelTitle] = container
+	}
+
+	return labels
+}
+
+// getLabelBuildArgs returns the --label and --annotation arguments in a stable order; values get environment variables expanded
+func getLabelBuildArgs(labels, annotations map[string]string) []string {
+
+	var args []string
+	for _, f := range []struct {
+		flag   string
+		values map[string]string
+	}{{"--label", labels}, {"--annotation", annotations}} {
+		keys := make([]string, 0, len(f.values))
+		for k := range f.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			args = append(args, f.flag, fmt.Sprintf("%v=%v", k, os.ExpandEnv(f.values[k])))
+		}
+	}
+
+	return args
+}
+
+// getDockerfileLabelKeys returns the keys set by LABEL instructions in the Dockerfile, supporting line continuations, quoting and the legacy `LABEL key value` form
+func getDockerfileLabelKeys(dockerfile string) []string {
+
+	var keys []string
+	for _, line := range strings.Split(strings.ReplaceAll(dockerfile, "\\\n", " "), "\n") {
+		matches := labelInstructionRegex.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		fields := splitQuotedFields(matches[1])
+		if len(fields) == 0 {
+			continue
+		}
+		if !strings.Contains(fields[0], "=") {
+			keys = append(keys, strings.Trim(fields[0], `"'`))
+			continue
+		}
+		for _, f := range fields {
+			key, _, _ := strings.Cut(f, "=")
+			keys = append(keys, strings.Trim(key, `"'`))
+		}
+	}
+
+	return keys
+}
+
+// splitQuotedFields splits on whitespace outside of single or double quotes
+func splitQuotedFields(value string) []string {
+
+	var fields []string
+	var field strings.Builder
+	var quote rune
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && (r == ' ' || r == '\t'):
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+			continue
+		}
+		field.WriteRune(r)
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+
+	return fields
+}
+
+// getOverriddenReservedLabels returns the standard labels the Dockerfile sets itself; the injected value wins, so these are most likely mistakes
+func getOverriddenReservedLabels(dockerfile string, standardLabels map[string]string) []string {
+
+	var overridden []string
+	for _, k := range getDockerfileLabelKeys(dockerfile) {
+		if _, ok := standardLabels[k]; ok && !contains(overridden, k) {
+			overridden = append(overridden, k)
+		}
+	}
+
+	return overridden
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStringMap(t *testing.T) {
+	t.Run("ReturnsNilIfValueIsEmpty", func(t *testing.T) {
+
+		// act
+		values, err := parseStringMap("", "labels")
+
+		assert.Nil(t, err)
+		assert.Nil(t, values)
+	})
+
+	t.Run("ReturnsMapFromYamlWithScalarsAsStrings", func(t *testing.T) {
+
+		// act
+		values, err := parseStringMap("team: platform\nport: 8080", "labels")
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"team": "platform", "port": "8080"}, values)
+	})
+
+	t.Run("ReturnsMapFromJson", func(t *testing.T) {
+
+		// act
+		values, err := parseStringMap(`{"team":"platform"}`, "labels")
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"team": "platform"}, values)
+	})
+
+	t.Run("ReturnsErrorIfValueIsNotAMap", func(t *testing.T) {
+
+		// act
+		_, err := parseStringMap("- team", "labels")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestMergeStringMaps(t *testing.T) {
+	t.Run("ReturnsNilIfBothAreNil", func(t *testing.T) {
+
+		// act
+		merged := mergeStringMaps(nil, nil)
+
+		assert.Nil(t, merged)
+	})
+
+	t.Run("ReturnsOverridesReplacingDefaults", func(t *testing.T) {
+
+		// act
+		merged := mergeStringMaps(map[string]string{"team": "platform", "tier": "1"}, map[string]string{"tier": "2"})
+
+		assert.Equal(t, map[string]string{"team": "platform", "tier": "2"}, merged)
+	})
+}
+
+func TestGetStandardLabels(t *testing.T) {
+	t.Run("ReturnsAllLabelsIfMetadataIsSet", func(t *testing.T) {
+
+		created := time.Date(2024, 3, 1, 10, 15, 0, 0, time.UTC)
+
+		// act
+		labels := getStandardLabels("github.com", "estafette", "estafette-extension-docker", "6e2a3f1", "1.0.0", "docker", created)
+
+		assert.Equal(t, map[string]string{
+			"org.opencontainers.image.source":   "https://github.com/estafette/estafette-extension-docker",
+			"org.opencontainers.image.revision": "6e2a3f1",
+			"org.opencontainers.image.version":  "1.0.0",
+			"org.opencontainers.image.created":  "2024-03-01T10:15:00Z",
+			"org.opencontainers.image.title":    "docker",
+		}, labels)
+	})
+
+	t.Run("OmitsLabelsForMissingMetadata", func(t *testing.T) {
+
+		created := time.Date(2024, 3, 1, 10, 15, 0, 0, time.UTC)
+
+		// act
+		labels := getStandardLabels("", "estafette", "estafette-extension-docker", "", "", "docker", created)
+
+		assert.Equal(t, map[string]string{
+			"org.opencontainers.image.created": "2024-03-01T10:15:00Z",
+			"org.opencontainers.image.title":   "docker",
+		}, labels)
+	})
+}
+
+func TestGetLabelBuildArgs(t *testing.T) {
+	t.Run("ReturnsSortedLabelsFollowedByAnnotationsWithExpandedValues", func(t *testing.T) {
+
+		os.Setenv("LABELS_TEST_TEAM", "platform")
+		defer os.Unsetenv("LABELS_TEST_TEAM")
+
+		// act
+		args := getLabelBuildArgs(map[string]string{"tier": "1", "team": "${LABELS_TEST_TEAM}"}, map[string]string{"org.opencontainers.image.description": "Docker extension"})
+
+		assert.Equal(t, []string{
+			"--label", "team=platform",
+			"--label", "tier=1",
+			"--annotation", "org.opencontainers.image.description=Docker extension",
+		}, args)
+	})
+}
+
+func TestGetDockerfileLabelKeys(t *testing.T) {
+	t.Run("ReturnsKeysOfAllLabelForms", func(t *testing.T) {
+
+		dockerfile := `FROM scratch
+LABEL maintainer="estafette" version=1.0
+label "org.opencontainers.image.title"="docker extension"
+LABEL description This is the legacy form
+LABEL team=platform \
+      tier="1 and 2"
+ENV LABEL=value`
+
+		// act
+		keys := getDockerfileLabelKeys(dockerfile)
+
+		assert.Equal(t, []string{"maintainer", "version", "org.opencontainers.image.title", "description", "team", "tier"}, keys)
+	})
+}
+
+func TestGetOverriddenReservedLabels(t *testing.T) {
+	t.Run("ReturnsStandardLabelsSetInDockerfileOnce", func(t *testing.T) {
+
+		dockerfile := `FROM golang AS builder
+LABEL org.opencontainers.image.version=0.0.1
+FROM scratch
+LABEL org.opencontainers.image.version=0.0.1 org.opencontainers.image.vendor=estafette team=platform`
+		standardLabels := map[string]string{"org.opencontainers.image.version": "1.0.0", "org.opencontainers.image.title": "docker"}
+
+		// act
+		overridden := getOverriddenReservedLabels(dockerfile, standardLabels)
+
+		assert.Equal(t, []string{"org.opencontainers.image.version"}, overridden)
+	})
+}
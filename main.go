@@ -6,15 +6,14 @@ import (
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/kingpin"
+	"github.com/estafette/estafette-extension-docker/reference"
 	foundation "github.com/estafette/estafette-foundation"
-	cpy "github.com/otiai10/copy"
 	"github.com/rs/zerolog/log"
 )
 
@@ -29,7 +28,7 @@ var (
 
 var (
 	// flags
-	action                     = kingpin.Flag("action", "Any of the following actions: build, push, tag, history.").Envar("ESTAFETTE_EXTENSION_ACTION").String()
+	action                     = kingpin.Flag("action", "Any of the following actions: build, push, tag, history, mirror, cleanup.").Envar("ESTAFETTE_EXTENSION_ACTION").String()
 	repositories               = kingpin.Flag("repositories", "List of the repositories the image needs to be pushed to or tagged in.").Envar("ESTAFETTE_EXTENSION_REPOSITORIES").String()
 	container                  = kingpin.Flag("container", "Name of the container to build, defaults to app label if present.").Envar("ESTAFETTE_EXTENSION_CONTAINER").String()
 	tag                        = kingpin.Flag("tag", "Tag for an image to show history for.").Envar("ESTAFETTE_EXTENSION_TAG").String()
@@ -38,7 +37,8 @@ var (
 	dockerfile                 = kingpin.Flag("dockerfile", "Dockerfile to build, defaults to Dockerfile.").Default("Dockerfile").Envar("ESTAFETTE_EXTENSION_DOCKERFILE").String()
 	inlineDockerfile           = kingpin.Flag("inline", "Dockerfile to build inlined.").Envar("ESTAFETTE_EXTENSION_INLINE").String()
 	copy                       = kingpin.Flag("copy", "List of files or directories to copy into the build directory.").Envar("ESTAFETTE_EXTENSION_COPY").String()
-	args                       = kingpin.Flag("args", "List of build arguments to pass to the build.").Envar("ESTAFETTE_EXTENSION_ARGS").String()
+	args                       = kingpin.Flag("args", "List of build arguments to pass to the build, either names of environment variables or NAME=value, where value can be a template.").Envar("ESTAFETTE_EXTENSION_ARGS").String()
+	argsFile                   = kingpin.Flag("args-file", "Path to a dotenv or yaml file with build arguments; args take precedence.").Envar("ESTAFETTE_EXTENSION_ARGS_FILE").String()
 	pushVersionTag             = kingpin.Flag("push-version-tag", "By default the version tag is pushed, so it can be promoted with a release, but if you don't want it you can disable it via this flag.").Default("true").Envar("ESTAFETTE_EXTENSION_PUSH_VERSION_TAG").Bool()
 	versionTagPrefix           = kingpin.Flag("version-tag-prefix", "A prefix to add to the version tag so promoting different containers originating from the same pipeline is possible.").Envar("ESTAFETTE_EXTENSION_VERSION_TAG_PREFIX").String()
 	versionTagSuffix           = kingpin.Flag("version-tag-suffix", "A suffix to add to the version tag so promoting different containers originating from the same pipeline is possible.").Envar("ESTAFETTE_EXTENSION_VERSION_TAG_SUFFIX").String()
@@ -46,13 +46,75 @@ var (
 	noCachePush                = kingpin.Flag("no-cache-push", "Indicates no dlc cache tag should be pushed when building the image.").Default("false").Envar("ESTAFETTE_EXTENSION_NO_CACHE_PUSH").Bool()
 	expandEnvironmentVariables = kingpin.Flag("expand-envvars", "By default environment variables get replaced in the Dockerfile, use this flag to disable that behaviour").Default("true").Envar("ESTAFETTE_EXTENSION_EXPAND_VARIABLES").Bool()
 	dontExpand                 = kingpin.Flag("dont-expand", "Comma separate list of environment variable names that should not be expanded").Default("PATH").Envar("ESTAFETTE_EXTENSION_DONT_EXPAND").String()
+	expandHeredocs             = kingpin.Flag("expand-heredocs", "Expand environment variables in heredoc bodies like RUN <<EOF as well, which docker otherwise resolves at build time.").Default("false").Envar("ESTAFETTE_EXTENSION_EXPAND_HEREDOCS").Bool()
+	expandMounts               = kingpin.Flag("expand-mounts", "Expand environment variables in --mount options of RUN instructions as well.").Default("false").Envar("ESTAFETTE_EXTENSION_EXPAND_MOUNTS").Bool()
+
+	dockerfileTemplate = kingpin.Flag("template", "Renders the Dockerfile as go template with build metadata, labels and the env vars in template-env before expanding environment variables.").Default("none").Envar("ESTAFETTE_EXTENSION_TEMPLATE").Enum("none", "gotemplate")
+	templateEnv        = kingpin.Flag("template-env", "Comma separated list of environment variable names available as .Env in the Dockerfile template").Envar("ESTAFETTE_EXTENSION_TEMPLATE_ENV").String()
+
+	unresolvedVariables  = kingpin.Flag("unresolved-variables", "Whether to warn, fail or ignore when variables in the Dockerfile are left unresolved after expansion.").Default("warn").Envar("ESTAFETTE_EXTENSION_UNRESOLVED_VARIABLES").Enum("warn", "fail", "ignore")
+	allowSecretExpansion = kingpin.Flag("allow-secret-expansion", "Allow expanding environment variables with secret values into the Dockerfile; they're still masked when printing it.").Default("false").Envar("ESTAFETTE_EXTENSION_ALLOW_SECRET_EXPANSION").Bool()
+
+	cacheMountsLocation = kingpin.Flag("cache-mounts", "Directory, or registry to store them next to the dlc cache tags, to persist the contents of RUN --mount=type=cache mounts to between builds.").Envar("ESTAFETTE_EXTENSION_CACHE_MOUNTS").String()
+	cacheMountsKeyFiles = kingpin.Flag("cache-mounts-key-files", "Comma separated list of files in the build context keying the cache mounts, defaults to common lockfiles like go.sum and package-lock.json.").Envar("ESTAFETTE_EXTENSION_CACHE_MOUNTS_KEY_FILES").String()
+
+	maxContextSize       = kingpin.Flag("max-context-size", "Maximum size of the build context after applying .dockerignore, like 200MB.").Envar("ESTAFETTE_EXTENSION_MAX_CONTEXT_SIZE").String()
+	contextLargeFileSize = kingpin.Flag("context-large-file-size", "Size above which files in the build context get a warning.").Default("50MB").Envar("ESTAFETTE_EXTENSION_CONTEXT_LARGE_FILE_SIZE").String()
+
+	images     = kingpin.Flag("images", "List of images (as yaml or json) to build, push or tag in one go, each with its own container, dockerfile, inline, path, copy, args, tags and repositories; unset fields default to the stage parameters.").Envar("ESTAFETTE_EXTENSION_IMAGES").String()
+	imagesFile = kingpin.Flag("images-file", "Path to a yaml file with an images list, as an alternative to images.").Envar("ESTAFETTE_EXTENSION_IMAGES_FILE").String()
+
+	smokeTestValue = kingpin.Flag("smoke-test", "Runs the built image (as yaml or json with env, args, port, httpPath and timeoutSeconds) and waits for it to become healthy before pushing cache.").Envar("ESTAFETTE_EXTENSION_SMOKE_TEST").String()
+
+	tests           = kingpin.Flag("tests", "List of structure tests (as yaml or json) to evaluate against the built image.").Envar("ESTAFETTE_EXTENSION_TESTS").String()
+	testsFile       = kingpin.Flag("tests-file", "Path to a yaml file with a tests list, as an alternative to tests.").Envar("ESTAFETTE_EXTENSION_TESTS_FILE").String()
+	testsReportPath = kingpin.Flag("tests-report", "Path to write the JUnit XML report of the structure tests to.").Default("structure-tests.xml").Envar("ESTAFETTE_EXTENSION_TESTS_REPORT").String()
+
+	target  = kingpin.Flag("target", "Stage of the Dockerfile to build as the final image.").Envar("ESTAFETTE_EXTENSION_TARGET").String()
+	outputs = kingpin.Flag("outputs", "List of outputs (as yaml or json) with stage, dest and paths to export the filesystem of a stage to a local directory.").Envar("ESTAFETTE_EXTENSION_OUTPUTS").String()
+
+	contexts = kingpin.Flag("contexts", "Map of named build contexts (as yaml or json) with a local directory, git or tarball url or docker-image:// reference.").Envar("ESTAFETTE_EXTENSION_CONTEXTS").String()
+
+	labels                      = kingpin.Flag("labels", "Map of labels (as yaml or json) to add to the built image besides the standard org.opencontainers.image labels.").Envar("ESTAFETTE_EXTENSION_LABELS").String()
+	annotations                 = kingpin.Flag("annotations", "Map of annotations (as yaml or json) to add to the built image manifest.").Envar("ESTAFETTE_EXTENSION_ANNOTATIONS").String()
+	failOnReservedLabelOverride = kingpin.Flag("fail-on-reserved-label-override", "Fail the build when the Dockerfile sets one of the automatically added org.opencontainers.image labels.").Default("false").Envar("ESTAFETTE_EXTENSION_FAIL_ON_RESERVED_LABEL_OVERRIDE").Bool()
+
+	reproducible       = kingpin.Flag("reproducible", "Builds reproducibly by using the git commit time as SOURCE_DATE_EPOCH, rewriting timestamps in the layers and normalizing copied files.").Default("false").Envar("ESTAFETTE_EXTENSION_REPRODUCIBLE").Bool()
+	verifyReproducible = kingpin.Flag("verify-reproducible", "Rebuilds the image without cache and fails if the result differs.").Default("false").Envar("ESTAFETTE_EXTENSION_VERIFY_REPRODUCIBLE").Bool()
+
+	maxImageSize   = kingpin.Flag("max-image-size", "Maximum size of the built image, like 500MB or 2GiB.").Envar("ESTAFETTE_EXTENSION_MAX_IMAGE_SIZE").String()
+	maxLayerCount  = kingpin.Flag("max-layer-count", "Maximum number of layers of the built image.").Default("0").Envar("ESTAFETTE_EXTENSION_MAX_LAYER_COUNT").Int()
+	maxLayerSize   = kingpin.Flag("max-layer-size", "Maximum size of every layer of the built image, like 200MB.").Envar("ESTAFETTE_EXTENSION_MAX_LAYER_SIZE").String()
+	sizeBudgetMode = kingpin.Flag("size-budget-mode", "Whether to fail or warn when the built image exceeds maxImageSize, maxLayerCount or maxLayerSize.").Default("fail").Envar("ESTAFETTE_EXTENSION_SIZE_BUDGET_MODE").Enum("fail", "warn")
 
 	gitSource = kingpin.Flag("git-source", "Repository source.").Envar("ESTAFETTE_GIT_SOURCE").String()
 	gitOwner  = kingpin.Flag("git-owner", "Repository owner.").Envar("ESTAFETTE_GIT_OWNER").String()
 	gitName   = kingpin.Flag("git-name", "Repository name, used as application name if not passed explicitly and app label not being set.").Envar("ESTAFETTE_GIT_NAME").String()
 	appLabel  = kingpin.Flag("app-name", "App label, used as application name if not passed explicitly.").Envar("ESTAFETTE_LABEL_APP").String()
 
+	sources           = kingpin.Flag("sources", "List of source images to mirror into the repositories; images without tag get all their tags mirrored.").Envar("ESTAFETTE_EXTENSION_SOURCES").String()
+	mirrorTagFilter   = kingpin.Flag("mirror-tag-filter", "Regular expression tags need to match fully to be mirrored when the source image has no tag.").Envar("ESTAFETTE_EXTENSION_MIRROR_TAG_FILTER").String()
+	mirrorSemverRange = kingpin.Flag("mirror-semver-range", "Semantic version range like '>=1.2.0 <2.0.0' or '^1.4' tags need to match to be mirrored when the source image has no tag.").Envar("ESTAFETTE_EXTENSION_MIRROR_SEMVER_RANGE").String()
+
+	keepLast                = kingpin.Flag("keep-last", "Number of most recent version tags the cleanup action always keeps; 0 disables this rule.").Default("0").Envar("ESTAFETTE_EXTENSION_KEEP_LAST").Int()
+	keepTags                = kingpin.Flag("keep-tags", "Regular expression for tags the cleanup action never deletes.").Default("stable|beta|dev|latest").Envar("ESTAFETTE_EXTENSION_KEEP_TAGS").String()
+	maxAgeDays              = kingpin.Flag("max-age-days", "The cleanup action deletes version tags beyond keep-last older than this number of days; 0 disables this rule.").Default("0").Envar("ESTAFETTE_EXTENSION_MAX_AGE_DAYS").Int()
+	deleteOrphanedCacheTags = kingpin.Flag("delete-orphaned-cache-tags", "The cleanup action deletes dlc-<stage> cache tags for stages no longer in the Dockerfile.").Default("true").Envar("ESTAFETTE_EXTENSION_DELETE_ORPHANED_CACHE_TAGS").Bool()
+	dryRun                  = kingpin.Flag("dry-run", "Print the execution plan instead of running any command.").Default("false").Envar("ESTAFETTE_EXTENSION_DRY_RUN").Bool()
+
+	semverTags = kingpin.Flag("semver-tags", "Adds major and major.minor tags derived from the build version when pushing or tagging; pre-releases don't move them and they never move back to a lower version.").Default("false").Envar("ESTAFETTE_EXTENSION_SEMVER_TAGS").Bool()
+
+	pushParallelism = kingpin.Flag("push-parallelism", "Maximum number of repository and tag combinations pushed at the same time.").Default("1").Envar("ESTAFETTE_EXTENSION_PUSH_PARALLELISM").Int()
+
+	retryAttempts = kingpin.Flag("retry-attempts", "Number of attempts for pulling, pushing, tagging and logging in before failing on transient registry errors.").Default("3").Envar("ESTAFETTE_EXTENSION_RETRY_ATTEMPTS").Int()
+	retryDelay    = kingpin.Flag("retry-delay", "Initial delay in milliseconds between attempts, doubled for every next attempt.").Default("1000").Envar("ESTAFETTE_EXTENSION_RETRY_DELAY").Int()
+	retryJitter   = kingpin.Flag("retry-jitter", "Adds +-25% jitter to the delay between attempts to avoid synchronized retries.").Default("true").Envar("ESTAFETTE_EXTENSION_RETRY_JITTER").Bool()
+
 	minimumSeverityToFail = kingpin.Flag("minimum-severity-to-fail", "Minimum severity of detected vulnerabilities to fail the build on").Default("HIGH").Envar("ESTAFETTE_EXTENSION_SEVERITY").String()
+	trivyDBSource         = kingpin.Flag("trivy-db-source", "Source of the trivy db: gcs for the bucket of the credentials, builtin for the db in the extension image, a local directory, an oci://<repository> artifact or an http(s) url of a db.tar.gz.").Default("gcs").Envar("ESTAFETTE_EXTENSION_TRIVY_DB_SOURCE").String()
+	trivyDBChecksum       = kingpin.Flag("trivy-db-checksum", "Sha256 checksum of the db.tar.gz to verify a trivy db downloaded from an http(s) url with.").Envar("ESTAFETTE_EXTENSION_TRIVY_DB_CHECKSUM").String()
+	trivyDBMaxAgeDays     = kingpin.Flag("trivy-db-max-age-days", "Maximum age in days of the trivy db before trivy-db-freshness applies; 0 disables the check.").Default("7").Envar("ESTAFETTE_EXTENSION_TRIVY_DB_MAX_AGE_DAYS").Int()
+	trivyDBFreshness      = kingpin.Flag("trivy-db-freshness", "Whether to warn, fail or ignore when the trivy db is older than trivy-db-max-age-days.").Default("warn").Envar("ESTAFETTE_EXTENSION_TRIVY_DB_FRESHNESS").Enum("warn", "fail", "ignore")
 
 	credentialsPath    = kingpin.Flag("credentials-path", "Path to file with container registry credentials configured at the CI server, passed in to this trusted extension.").Default("/credentials/container_registry.json").String()
 	githubAPITokenPath = kingpin.Flag("githubApiToken-path", "Path to file with Github api token credentials configured at the CI server, passed in to this trusted extension.").Default("/credentials/github_api_token.json").String()
@@ -69,6 +131,10 @@ func main() {
 	// create context to cancel commands on sigterm
 	ctx := foundation.InitCancellationContext(context.Background())
 
+	// log the retry summary on exit, also when a fatal error exits the process
+	log.Logger = log.Logger.Hook(retrySummaryHook{})
+	defer logRetrySummary()
+
 	if runtime.GOOS == "windows" {
 		interfaces, err := net.Interfaces()
 		if err != nil {
@@ -103,6 +169,10 @@ func main() {
 		if err != nil {
 			log.Fatal().Err(err).Msg("Failed unmarshalling injected credentials")
 		}
+		err = validateScopes(credentials)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid injected credentials")
+		}
 	}
 
 	if runtime.GOOS == "windows" {
@@ -128,9 +198,6 @@ func main() {
 		}
 	}
 
-	// validate inputs
-	validateRepositories(*repositories, *action)
-
 	// split into arrays and set other variables
 	var repositoriesSlice []string
 	if *repositories != "" {
@@ -149,18 +216,113 @@ func main() {
 		argsSlice = strings.Split(*args, ",")
 	}
 	estafetteBuildVersion := os.Getenv("ESTAFETTE_BUILD_VERSION")
-	estafetteBuildVersionAsTag := tidyTag(estafetteBuildVersion)
-	if *versionTagPrefix != "" {
-		estafetteBuildVersionAsTag = tidyTag(*versionTagPrefix + "-" + estafetteBuildVersionAsTag)
+	expandedVersionTagSuffix := os.ExpandEnv(*versionTagSuffix)
+	estafetteBuildVersionAsTag := formatVersionTag(estafetteBuildVersion, *versionTagPrefix, expandedVersionTagSuffix)
+
+	stageSmokeTest, err := parseSmokeTest(*smokeTestValue)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid smoke test")
 	}
 
-	expandedVersionTagSuffix := os.ExpandEnv(*versionTagSuffix)
-	if expandedVersionTagSuffix != "" {
-		estafetteBuildVersionAsTag = tidyTag(estafetteBuildVersionAsTag + "-" + expandedVersionTagSuffix)
+	stageTests, err := parseStructureTests(*tests, *testsFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid structure tests")
+	}
+
+	stageOutputs, err := parseBuildOutputs(*outputs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid outputs")
+	}
+
+	labelsMap, err := parseStringMap(*labels, "labels")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid labels")
+	}
+	annotationsMap, err := parseStringMap(*annotations, "annotations")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid annotations")
+	}
+	contextsMap, err := parseStringMap(*contexts, "contexts")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid contexts")
+	}
+
+	err = validateTrivyDBSource(os.ExpandEnv(*trivyDBSource), *trivyDBChecksum)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid trivy db source")
+	}
+
+	if *verifyReproducible && !*reproducible {
+		log.Fatal().Msg("Set `reproducible: true` to use verifyReproducible")
+	}
+	if *semverTags && !*pushVersionTag && *action == "push" {
+		// detecting a floating tag pointing at a higher version needs the version tags in the repository
+		log.Warn().Msg("With pushVersionTag set to false semverTags can't detect floating tags pointing at a higher version, so they can move back to a lower version")
+	}
+
+	// the stage parameters are the defaults for every image in `images:`
+	buildImages, err := getBuildImages(buildImage{
+		Container:     expandedContainer,
+		Dockerfile:    *dockerfile,
+		Inline:        *inlineDockerfile,
+		Path:          *path,
+		Copy:          copySlice,
+		Args:          argsSlice,
+		ArgsFile:      *argsFile,
+		Tags:          tagsSlice,
+		Repositories:  repositoriesSlice,
+		SmokeTest:     stageSmokeTest,
+		Labels:        labelsMap,
+		Annotations:   annotationsMap,
+		MaxImageSize:  *maxImageSize,
+		MaxLayerCount: *maxLayerCount,
+		MaxLayerSize:  *maxLayerSize,
+		Tests:         stageTests,
+		Template:      *dockerfileTemplate,
+		Target:        *target,
+		Outputs:       stageOutputs,
+		Contexts:      contextsMap,
+	}, *images, *imagesFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid images")
+	}
+	if len(buildImages) > 1 && *action != "build" && *action != "push" && *action != "tag" {
+		log.Fatal().Msgf("Multiple images are only supported for actions build, push and tag, not for %v", *action)
+	}
+
+	// an invalid image ends up in the report instead of stopping the other images
+	imageErrors := make([]error, len(buildImages))
+	for i := range buildImages {
+		imageErrors[i] = buildImages[i].prepare(*action, estafetteBuildVersion, *versionTagPrefix, expandedVersionTagSuffix)
+	}
+	if *action != "build" && *action != "push" && *action != "tag" && imageErrors[0] != nil {
+		log.Fatal().Err(imageErrors[0]).Msgf("Invalid container image %v", buildImages[0].Container)
+	}
+
+	// actions other than build, push and tag only support a single image
+	expandedContainer = buildImages[0].Container
+	repositoriesSlice = buildImages[0].Repositories
+	tagsSlice = buildImages[0].Tags
+
+	if *dryRun {
+		log.Info().Msg("Running in dry-run mode, no commands will be executed")
+		plan.Action = *action
+		plan.Container = expandedContainer
+		plan.Repositories = repositoriesSlice
+		plan.Tags = tagsSlice
+		plan.VersionTag = estafetteBuildVersionAsTag
+		if len(buildImages) > 1 {
+			plan.Container = ""
+			plan.Tags = nil
+			for _, i := range buildImages {
+				plan.Images = append(plan.Images, i.Container)
+			}
+		}
+		defer printPlan()
 	}
 
 	switch *action {
-	case "build":
+	case "build", "push", "tag":
 
 		// minimal using defaults
 
@@ -191,287 +353,20 @@ func main() {
 		// args:
 		// - SOME_BUILD_ARG_ENVVAR
 
-		// make build dir if it doesn't exist
-
-		expandedPath := os.ExpandEnv(*path)
-		log.Info().Msgf("Ensuring build directory %v exists", expandedPath)
-		if ok, _ := pathExists(expandedPath); !ok {
-			err := os.MkdirAll(expandedPath, os.ModePerm)
-			foundation.HandleError(err)
-		}
-
-		// copy files/dirs from copySlice to build path
-		for _, c := range copySlice {
-
-			fi, err := os.Stat(c)
-			foundation.HandleError(err)
-			switch mode := fi.Mode(); {
-			case mode.IsDir():
-				log.Info().Msgf("Copying directory %v to %v", c, expandedPath)
-				err := cpy.Copy(c, filepath.Join(expandedPath, filepath.Base(c)))
-				foundation.HandleError(err)
-
-			case mode.IsRegular():
-				log.Info().Msgf("Copying file %v to %v", c, expandedPath)
-				err := cpy.Copy(c, filepath.Join(expandedPath, filepath.Base(c)))
-				foundation.HandleError(err)
-
-			default:
-				log.Fatal().Msgf("Unknown file mode %v for path %v", mode, c)
-			}
-		}
-
-		sourceDockerfilePath := ""
-		dockerFileExpandedPath := os.ExpandEnv(*dockerfile)
-		targetDockerfilePath := filepath.Join(expandedPath, filepath.Base(dockerFileExpandedPath))
-		sourceDockerfile := ""
-
-		// check in order of importance whether `inline` dockerfile is set, path to `dockerfile` is set or a dockerfile exist in /template directory (for building docker extension from this one)
-		if *inlineDockerfile != "" {
-			sourceDockerfile = *inlineDockerfile
-		} else if _, err := os.Stat(dockerFileExpandedPath); !os.IsNotExist(err) {
-			sourceDockerfilePath = dockerFileExpandedPath
-		} else if _, err := os.Stat("/template/Dockerfile"); !os.IsNotExist(err) {
-			sourceDockerfilePath = "/template/Dockerfile"
-		} else {
-			log.Fatal().Msg("No Dockerfile can be found; either use the `inline` property, set the path to a Dockerfile with the `dockerfile` property or inherit from the Docker extension and store a Dockerfile at /template/Dockerfile")
-		}
-
-		if sourceDockerfile == "" && sourceDockerfilePath != "" {
-			log.Info().Msgf("Reading dockerfile content from %v...", sourceDockerfilePath)
-			data, err := os.ReadFile(sourceDockerfilePath)
-			foundation.HandleError(err)
-			sourceDockerfile = string(data)
-			// trim BOM
-			sourceDockerfile = strings.TrimPrefix(sourceDockerfile, "\uFEFF")
-		}
-
-		targetDockerfile := sourceDockerfile
-		if *expandEnvironmentVariables {
-			log.Print("Expanding environment variables in Dockerfile...")
-			targetDockerfile = expandEnvironmentVariablesIfSet(sourceDockerfile, dontExpand)
-		}
-
-		log.Info().Msgf("Writing Dockerfile to %v...", targetDockerfilePath)
-		err := os.WriteFile(targetDockerfilePath, []byte(targetDockerfile), 0644)
-		foundation.HandleError(err)
-
-		// list directory content
-		log.Info().Msgf("Listing directory %v content", expandedPath)
-		files, err := os.ReadDir(expandedPath)
-		foundation.HandleError(err)
-		for _, f := range files {
-			if f.IsDir() {
-				log.Info().Msgf("- %v/", f.Name())
-			} else {
-				log.Info().Msgf("- %v", f.Name())
-			}
-		}
-
-		// find all images in FROM statements in dockerfile
-		fromImagePaths, err := getFromImagePathsFromDockerfile(targetDockerfile)
-		foundation.HandleError(err)
+		// or build multiple images sharing the stage parameters as defaults
 
-		if len(fromImagePaths) == 0 {
-
-			log.Info().Msgf("%v (as string):", sourceDockerfilePath)
-			fmt.Println(targetDockerfile)
-			log.Info().Msg("")
-
-			log.Info().Msgf("%v (as bytes):", sourceDockerfilePath)
-			data, _ := os.ReadFile(sourceDockerfilePath)
-			fmt.Println(data)
-
-			log.Fatal().Msg("Failed detecting image paths in FROM statements, exiting")
-		}
-
-		// pull images in advance, so we can log in to different repositories in the same registry (see https://github.com/moby/moby/issues/37569)
-		for _, i := range fromImagePaths {
-			if i.isOfficialDockerHubImage {
-				continue
-			}
-			loginIfRequired(credentials, false, i.imagePath)
-			log.Info().Msgf("Pulling container image %v", i.imagePath)
-			pullArgs := []string{
-				"pull",
-				i.imagePath,
-			}
-			foundation.RunCommandWithArgs(ctx, "docker", pullArgs)
-		}
-
-		// login to registry for destination container image
-		containerPath := fmt.Sprintf("%v/%v:%v", repositoriesSlice[0], expandedContainer, estafetteBuildVersionAsTag)
-		loginIfRequired(credentials, !*noCachePush, containerPath)
-
-		// build docker image
-		log.Info().Msgf("Building docker image %v...", containerPath)
-
-		log.Info().Msg("")
-		fmt.Println(targetDockerfile)
-		log.Info().Msg("")
-
-		// build every layer separately and push it to registry to be used as cache next time
-		var dockerLayerCachingPaths []string
-		for index, i := range fromImagePaths {
-			isFinalLayer := index == len(fromImagePaths)-1
-			isCacheable := !*noCache && runtime.GOOS != "windows"
-			dockerLayerCachingTag := "dlc"
-
-			if !isFinalLayer {
-				if i.stageName == "" || !isCacheable {
-					// skip building intermediate layers for caching
-					continue
-				}
-				log.Info().Msgf("Building layer %v...", i.stageName)
-				dockerLayerCachingTag = tidyTag(fmt.Sprintf("dlc-%v", i.stageName))
-			}
-
-			dockerLayerCachingPath := fmt.Sprintf("%v/%v:%v", repositoriesSlice[0], expandedContainer, dockerLayerCachingTag)
-			dockerLayerCachingPaths = append(dockerLayerCachingPaths, dockerLayerCachingPath)
-
-			args := []string{
-				"build",
-			}
-
-			if isCacheable {
-				args = append(args, "--build-arg", "BUILDKIT_INLINE_CACHE=1")
-				// cache from remote image
-				for _, cf := range dockerLayerCachingPaths {
-					args = append(args, "--cache-from", cf)
-				}
-				args = append(args, "--tag", dockerLayerCachingPath)
-			} else {
-				// disable use of local layer cache
-				args = append(args, "--no-cache")
-			}
-
-			if isFinalLayer {
-				for _, r := range repositoriesSlice {
-					args = append(args, "--tag", fmt.Sprintf("%v/%v:%v", r, expandedContainer, estafetteBuildVersionAsTag))
-					for _, t := range tagsSlice {
-						if r == repositoriesSlice[0] && (t == estafetteBuildVersionAsTag || t == dockerLayerCachingTag) {
-							continue
-						}
-						args = append(args, "--tag", fmt.Sprintf("%v/%v:%v", r, expandedContainer, t))
-					}
-				}
-			} else {
-				args = append(args, "--target", i.stageName)
-			}
-
-			// add optional build args
-			for _, a := range argsSlice {
-				argValue := os.Getenv(a)
-				args = append(args, "--build-arg", fmt.Sprintf("%v=%v", a, argValue))
-			}
-
-			args = append(args, "--file", targetDockerfilePath)
-			args = append(args, expandedPath)
-			foundation.RunCommandWithArgs(ctx, "docker", args)
-
-			if isCacheable && !*noCachePush {
-				log.Info().Msgf("Pushing cache container image %v", dockerLayerCachingPath)
-				pushArgs := []string{
-					"push",
-					dockerLayerCachingPath,
-				}
-				foundation.RunCommandWithArgs(ctx, "docker", pushArgs)
-			}
-		}
-
-		if runtime.GOOS == "windows" {
-			return
-		}
-
-		// map severity param value to trivy severity
-		severityArgument := "UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
-		switch strings.ToUpper(*minimumSeverityToFail) {
-		case "UNKNOWN":
-			severityArgument = "UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
-		case "LOW":
-			severityArgument = "LOW,MEDIUM,HIGH,CRITICAL"
-		case "MEDIUM":
-			severityArgument = "MEDIUM,HIGH,CRITICAL"
-		case "HIGH":
-			severityArgument = "HIGH,CRITICAL"
-		case "CRITICAL":
-			severityArgument = "CRITICAL"
-		}
-
-		// set JavaDB repositories for fallback scenarios (e.g. rate limiting failure)
-		javaDbRepositories := "public.ecr.aws/aquasecurity/trivy-java-db:1,aquasec/trivy-java-db:1,ghcr.io/aquasecurity/trivy-java-db:1"
-		
-		log.Info().Msg("Saving docker image to file for scanning...")
-		tmpfile, err := os.CreateTemp("", "*.tar")
-		if err != nil {
-			log.Fatal().Err(err).Msg("Failed creating temporary file")
-		}
-
-		// Download Trivy db and save it to path /trivy-cache
-		bucketName := ""
-		for i := range repositoriesSlice {
-			if credentials != nil && bucketName != credentials[i].AdditionalProperties.TrivyVulnerabilityDBGCSBucket {
-				credential := credentials[i]
-
-				pathDir := filepath.Dir(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
-				if _, err := os.Stat(pathDir); os.IsNotExist(err) {
-					err = os.MkdirAll(pathDir, os.ModePerm)
-					if err != nil {
-						log.Fatal().Err(err).Msg("Failed creating directory")
-
-					}
-				}
-				err = os.WriteFile(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), []byte(credential.AdditionalProperties.ServiceAccountKeyfile), 0666)
-				if err != nil {
-					log.Fatal().Err(err).Msg("Failed writing service account keyfile")
-				}
-
-				var serviceAccountKeyFile struct {
-					ClientEmail string `json:"client_email"`
-				}
-				err = json.Unmarshal([]byte(credential.AdditionalProperties.ServiceAccountKeyfile), &serviceAccountKeyFile)
-				if err != nil {
-					log.Fatal().Err(err).Msg("Failed reading service account keyfile")
-				}
-				log.Info().Msgf("Using service account to download Trivy db %v...", serviceAccountKeyFile.ClientEmail)
-
-				bucketName = credentials[i].AdditionalProperties.TrivyVulnerabilityDBGCSBucket
-
-				log.Info().Msg("Authenticating to google cloud")
-				foundation.RunCommandWithArgs(ctx, "gcloud", []string{"auth", "activate-service-account", serviceAccountKeyFile.ClientEmail, "--key-file", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")})
-
-				log.Info().Msg("Setting gcloud account")
-				foundation.RunCommandWithArgs(ctx, "gcloud", []string{"config", "set", "account", serviceAccountKeyFile.ClientEmail})
-
-				log.Info().Msg("Setting gcloud project")
-				foundation.RunCommandWithArgs(ctx, "gcloud", []string{"config", "set", "project", credentials[i].AdditionalProperties.TrivyVulnerabilityDBGCSProject})
-
-				foundation.RunCommandWithArgs(ctx, "gsutil", []string{"-m", "cp", "-r", fmt.Sprintf("gs://%v/trivy-cache/*", bucketName), "/trivy-cache"})
-			}
-		}
-
-		foundation.RunCommandWithArgs(ctx, "docker", []string{"save", containerPath, "-o", tmpfile.Name()})
-
-		// remove .trivyignore file so devs can't game the system
-		// if foundation.FileExists(".trivyignore") {
-		// 	err = os.Remove(".trivyignore")
-		// 	if err != nil {
-		// 		log.Fatal().Msg("Could not remove .trivyignore file")
-		// 	}
-		// }
-		err = foundation.RunCommandWithArgsExtended(ctx, "/trivy", []string{"-v"})
-		if err != nil {
-			log.Fatal().Msgf("Error printing trivy version: %q", err)
-		}
-
-		log.Info().Msgf("Scanning container image %v for vulnerabilities of severities %v...", containerPath, severityArgument)
-		err = foundation.RunCommandWithArgsExtended(ctx, "/trivy", []string{"--cache-dir", "/trivy-cache", "--timeout", "20m", "image", "--severity", severityArgument, "--scanners", "vuln", "--skip-db-update", "--no-progress", "--exit-code", "15", "--ignore-unfixed", "--java-db-repository", javaDbRepositories,"--input", tmpfile.Name()})
-
-		if err != nil {
-			log.Fatal().Msgf("The container image has vulnerabilities of severity %v! Look at https://estafette.io/usage/fixing-vulnerabilities/ to learn how to fix vulnerabilities in your image.", severityArgument)
-		}
+		// image: extensions/docker:stable
+		// action: build
+		// repositories:
+		// - extensions
+		// images:
+		// - container: docker
+		// - container: docker-test
+		//   dockerfile: Dockerfile.test
+		//   tags:
+		//   - test
 
-	case "push":
+		// push or tag
 
 		// image: extensions/docker:stable
 		// action: push
@@ -481,143 +376,42 @@ func main() {
 		// tags:
 		// - dev
 
-		sourceContainerPath := fmt.Sprintf("%v/%v:%v", repositoriesSlice[0], expandedContainer, estafetteBuildVersionAsTag)
-
-		// push each repository + tag combination
-		for i, r := range repositoriesSlice {
-
-			targetContainerPath := fmt.Sprintf("%v/%v:%v", r, expandedContainer, estafetteBuildVersionAsTag)
-
-			if i > 0 {
-				// tag container with default tag (it already exists for the first repository)
-				log.Info().Msgf("Tagging container image %v", targetContainerPath)
-				tagArgs := []string{
-					"tag",
-					sourceContainerPath,
-					targetContainerPath,
-				}
-				err := exec.Command("docker", tagArgs...).Run()
-				foundation.HandleError(err)
+		var results []imageResult
+		for i, image := range buildImages {
+			if imageErrors[i] != nil {
+				results = append(results, imageResult{container: image.Container, err: fmt.Errorf("Invalid container image %v: %w", image.Container, imageErrors[i])})
+				continue
 			}
-
-			loginIfRequired(credentials, true, targetContainerPath)
-
-			if *pushVersionTag {
-				// push container with default tag
-				log.Info().Msgf("Pushing container image %v", targetContainerPath)
-				pushArgs := []string{
-					"push",
-					targetContainerPath,
-				}
-				foundation.RunCommandWithArgs(ctx, "docker", pushArgs)
-			} else {
-				log.Info().Msg("Skipping pushing version tag, because pushVersionTag is set to false; this make promoting a version to a tag at a later stage impossible!")
+			if len(buildImages) > 1 {
+				log.Info().Msgf("Running action %v for image %v...", *action, image.Container)
 			}
 
-			if !*pushVersionTag && len(tagsSlice) == 0 {
-				log.Fatal().Msg("When setting pushVersionTag to false you need at least one tag")
+			start := time.Now()
+			var err error
+			switch *action {
+			case "build":
+				err = buildContainerImage(ctx, credentials, image, estafetteBuildVersion, estafetteBuildVersionAsTag, expandedVersionTagSuffix)
+			case "push":
+				err = pushContainerImage(ctx, credentials, image, estafetteBuildVersion, estafetteBuildVersionAsTag, expandedVersionTagSuffix)
+			case "tag":
+				err = tagContainerImage(ctx, credentials, image, estafetteBuildVersion, estafetteBuildVersionAsTag, expandedVersionTagSuffix)
 			}
+			results = append(results, imageResult{container: image.Container, duration: time.Since(start), err: err})
 
-			// push additional tags
-			for _, t := range tagsSlice {
-
-				if r == repositoriesSlice[0] && t == estafetteBuildVersionAsTag {
-					continue
-				}
-
-				targetContainerPath := fmt.Sprintf("%v/%v:%v", r, expandedContainer, t)
-
-				// tag container with additional tag
-				log.Info().Msgf("Tagging container image %v", targetContainerPath)
-				tagArgs := []string{
-					"tag",
-					sourceContainerPath,
-					targetContainerPath,
-				}
-				foundation.RunCommandWithArgs(ctx, "docker", tagArgs)
-
-				loginIfRequired(credentials, true, targetContainerPath)
-
-				log.Info().Msgf("Pushing container image %v", targetContainerPath)
-				pushArgs := []string{
-					"push",
-					targetContainerPath,
-				}
-				foundation.RunCommandWithArgs(ctx, "docker", pushArgs)
+			// sigterm cancels all remaining images
+			if ctx.Err() != nil {
+				break
 			}
 		}
 
-	case "tag":
-
-		// image: extensions/docker:stable
-		// action: tag
-		// container: docker
-		// repositories:
-		// - extensions
-		// tags:
-		// - stable
-		// - latest
-
-		sourceContainerPath := fmt.Sprintf("%v/%v:%v", repositoriesSlice[0], expandedContainer, estafetteBuildVersionAsTag)
-
-		loginIfRequired(credentials, false, sourceContainerPath)
-
-		// pull source container first
-		log.Info().Msgf("Pulling container image %v", sourceContainerPath)
-		pullArgs := []string{
-			"pull",
-			sourceContainerPath,
-		}
-		foundation.RunCommandWithArgs(ctx, "docker", pullArgs)
-
-		// push each repository + tag combination
-		for i, r := range repositoriesSlice {
-
-			targetContainerPath := fmt.Sprintf("%v/%v:%v", r, expandedContainer, estafetteBuildVersionAsTag)
-
-			if i > 0 {
-				// tag container with default tag
-				log.Info().Msgf("Tagging container image %v", targetContainerPath)
-				tagArgs := []string{
-					"tag",
-					sourceContainerPath,
-					targetContainerPath,
-				}
-				foundation.RunCommandWithArgs(ctx, "docker", tagArgs)
-
-				loginIfRequired(credentials, true, targetContainerPath)
-
-				// push container with default tag
-				log.Info().Msgf("Pushing container image %v", targetContainerPath)
-				pushArgs := []string{
-					"push",
-					targetContainerPath,
-				}
-				foundation.RunCommandWithArgs(ctx, "docker", pushArgs)
+		if len(buildImages) == 1 {
+			if results[0].err != nil {
+				log.Fatal().Err(results[0].err).Msgf("Failed to %v container image %v", *action, results[0].container)
 			}
-
-			// push additional tags
-			for _, t := range tagsSlice {
-
-				targetContainerPath := fmt.Sprintf("%v/%v:%v", r, expandedContainer, t)
-
-				// tag container with additional tag
-				log.Info().Msgf("Tagging container image %v", targetContainerPath)
-				tagArgs := []string{
-					"tag",
-					sourceContainerPath,
-					targetContainerPath,
-				}
-				foundation.RunCommandWithArgs(ctx, "docker", tagArgs)
-
-				loginIfRequired(credentials, true, targetContainerPath)
-
-				log.Info().Msgf("Pushing container image %v", targetContainerPath)
-				pushArgs := []string{
-					"push",
-					targetContainerPath,
-				}
-				foundation.RunCommandWithArgs(ctx, "docker", pushArgs)
+		} else {
+			err := logImagesReport(*action, results)
+			if err != nil {
+				log.Fatal().Err(err).Msgf("Failed to %v container images", *action)
 			}
 		}
 
@@ -647,7 +441,7 @@ func main() {
 			sourceContainerPath += ":" + *tag
 		}
 
-		loginIfRequired(credentials, false, sourceContainerPath)
+		loginIfRequired(ctx, credentials, false, sourceContainerPath)
 
 		log.Info().Msgf("Showing history for container image %v", sourceContainerPath)
 		historyArgs := []string{
@@ -658,6 +452,11 @@ func main() {
 			sourceContainerPath,
 		}
 
+		if *dryRun {
+			plan.addStep("docker history", "docker", historyArgs)
+			break
+		}
+
 		output, err := foundation.GetCommandWithArgsOutput(ctx, "docker", historyArgs)
 		if err != nil {
 			// pull source container first
@@ -666,13 +465,101 @@ func main() {
 				"pull",
 				sourceContainerPath,
 			}
-			foundation.RunCommandWithArgs(ctx, "docker", pullArgs)
+			runDockerCommandWithRetry(ctx, "pull", pullArgs)
 
-			foundation.RunCommandWithArgs(ctx, "docker", historyArgs)
+			runCommand(ctx, "docker history", "docker", historyArgs)
 		} else {
 			log.Info().Msg(output)
 		}
 
+	case "mirror":
+
+		// image: extensions/docker:stable
+		// action: mirror
+		// sources:
+		// - golang:1.23.0-alpine
+		// - bitnami/redis
+		// mirrorSemverRange: ^7.2
+		// repositories:
+		// - eu.gcr.io/travix-com
+
+		if *sources == "" {
+			log.Fatal().Msg("Set `sources:` to list at least one `- <image>` to mirror (for example like `- golang:1.23.0-alpine`)")
+		}
+		for _, s := range strings.Split(*sources, ",") {
+			if _, err := reference.Parse(s); err != nil {
+				log.Fatal().Err(err).Msgf("Source %v is invalid", s)
+			}
+		}
+
+		err := mirrorContainerImages(ctx, credentials, strings.Split(*sources, ","), repositoriesSlice, *mirrorTagFilter, *mirrorSemverRange)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed mirroring container images")
+		}
+
+	case "cleanup":
+
+		// image: extensions/docker:stable
+		// action: cleanup
+		// container: docker
+		// repositories:
+		// - extensions
+		// keepLast: 10
+		// keepTags: stable|beta|dev|latest
+		// maxAgeDays: 90
+		// dryRun: true
+
+		err := validateCleanupRetention(*keepLast, *maxAgeDays)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid cleanup retention")
+		}
+
+		keepTagsRegex, err := regexp.Compile(fmt.Sprintf("^(%v)$", *keepTags))
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Invalid keepTags regular expression %v", *keepTags)
+		}
+
+		rules := cleanupRules{
+			keepLast:                *keepLast,
+			keepTags:                keepTagsRegex,
+			maxAge:                  time.Duration(*maxAgeDays) * 24 * time.Hour,
+			versionTagPrefix:        *versionTagPrefix,
+			versionTagSuffix:        expandedVersionTagSuffix,
+			deleteOrphanedCacheTags: *deleteOrphanedCacheTags,
+		}
+		if v, err := parseSemanticVersion(estafetteBuildVersion); err == nil {
+			rules.buildVersionPreRelease = v.preRelease
+		}
+
+		// determine current stages to find cache tags for stages that no longer exist
+		if *deleteOrphanedCacheTags {
+			sourceDockerfile, _, err := readSourceDockerfile(*inlineDockerfile, os.ExpandEnv(*dockerfile))
+			if err != nil {
+				log.Warn().Err(err).Msg("Skipping deletion of orphaned cache tags")
+			} else {
+				if *dockerfileTemplate == "gotemplate" {
+					sourceDockerfile, err = renderDockerfileTemplate(sourceDockerfile, *dockerfile, newDockerfileTemplateData(expandedContainer, labelsMap, strings.Split(*templateEnv, ",")))
+					foundation.HandleError(err)
+				}
+				fromImagePaths, err := getFromImagePathsFromDockerfile(sourceDockerfile)
+				foundation.HandleError(err)
+				rules.stages = []string{}
+				for _, i := range fromImagePaths {
+					if i.stageName != "" {
+						rules.stages = append(rules.stages, strings.TrimPrefix(tidyTag(fmt.Sprintf("dlc-%v", i.stageName)), "dlc-"))
+					}
+				}
+			}
+		}
+
+		client := newRegistryClient(credentials)
+		for _, r := range repositoriesSlice {
+			err := cleanupContainerImage(ctx, client, fmt.Sprintf("%v/%v", r, expandedContainer), rules, *dryRun)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed cleaning up container images")
+			}
+		}
+
 	case "dive":
 
 		log.Warn().Msg("Support for 'action: dive' has been removed, please remove your stage")
@@ -682,34 +569,86 @@ func main() {
 		log.Warn().Msgf("Direct support for 'action: trivy' has been removed, please use 'severity: %v' on the stage with 'action: build' to use a non-default severity", *minimumSeverityToFail)
 
 	default:
-		log.Fatal().Msg("Set `action: <action>` on this step to run build, push, tag or history")
+		log.Fatal().Msg("Set `action: <action>` on this step to run build, push, tag, history, mirror or cleanup")
 	}
 }
 
-func validateRepositories(repositories, action string) {
-	if repositories == "" && action != "history" {
-		log.Fatal().Msg("Set `repositories:` to list at least one `- <repository>` (for example like `- extensions`)")
+// readSourceDockerfile returns the `inline` dockerfile if set, otherwise the content of the `dockerfile` file or the one in the /template directory (for building docker extension from this one)
+func readSourceDockerfile(inlineDockerfile, dockerfilePath string) (sourceDockerfile, sourceDockerfilePath string, err error) {
+
+	// check in order of importance whether `inline` dockerfile is set, path to `dockerfile` is set or a dockerfile exist in /template directory
+	if inlineDockerfile != "" {
+		return inlineDockerfile, "", nil
+	} else if _, err := os.Stat(dockerfilePath); !os.IsNotExist(err) {
+		sourceDockerfilePath = dockerfilePath
+	} else if _, err := os.Stat("/template/Dockerfile"); !os.IsNotExist(err) {
+		sourceDockerfilePath = "/template/Dockerfile"
+	} else {
+		return "", "", fmt.Errorf("No Dockerfile can be found; either use the `inline` property, set the path to a Dockerfile with the `dockerfile` property or inherit from the Docker extension and store a Dockerfile at /template/Dockerfile")
 	}
+
+	log.Info().Msgf("Reading dockerfile content from %v...", sourceDockerfilePath)
+	data, err := os.ReadFile(sourceDockerfilePath)
+	if err != nil {
+		return "", sourceDockerfilePath, err
+	}
+
+	// trim BOM
+	sourceDockerfile = strings.TrimPrefix(string(data), "\uFEFF")
+
+	return sourceDockerfile, sourceDockerfilePath, nil
 }
 
-func getCredentialsForContainers(credentials []ContainerRegistryCredentials, containerImages []string) map[string]*ContainerRegistryCredentials {
+// validateContainerImages validates the repositories, container and tags combine into valid container image references
+func validateContainerImages(action string, repositories []string, container string, tags []string) error {
+
+	for _, r := range repositories {
+		if err := reference.ValidateName(r); err != nil {
+			return fmt.Errorf("repository %q is invalid: %w", r, err)
+		}
+	}
+
+	switch action {
+	case "build", "push", "tag", "history", "cleanup":
+		if container == "" {
+			return fmt.Errorf("Set `container:` to the name of the container image, or set the app label")
+		}
+		if err := reference.ValidateName(container); err != nil {
+			return fmt.Errorf("container %q is invalid: %w", container, err)
+		}
+		for _, r := range repositories {
+			if err := reference.ValidateName(r + "/" + container); err != nil {
+				return fmt.Errorf("container image %v/%v is invalid: %w", r, container, err)
+			}
+		}
+	}
+
+	for _, t := range tags {
+		if err := reference.ValidateTag(t); err != nil {
+			return fmt.Errorf("tags are invalid: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func getCredentialsForContainers(credentials []ContainerRegistryCredentials, push bool, containerImages []string) map[string]*ContainerRegistryCredentials {
 
 	filteredCredentialsMap := make(map[string]*ContainerRegistryCredentials)
 
 	if credentials != nil {
 		// loop all container images
 		for _, ci := range containerImages {
-			containerImageSlice := strings.Split(ci, "/")
-			containerRepo := strings.Join(containerImageSlice[:len(containerImageSlice)-1], "/")
+			containerRepo := getCredentialsRepository(ci)
 
 			if _, ok := filteredCredentialsMap[containerRepo]; ok {
 				// credentials for this repo were added before, check next container image
 				continue
 			}
 
-			// find the credentials matching the container image
+			// find the credentials matching the container image and scope
 			for _, credential := range credentials {
-				if containerRepo == credential.AdditionalProperties.Repository {
+				if containerRepo == credential.AdditionalProperties.Repository && hasScope(credential, push) {
 
 					// this one matches, add it to the map
 					filteredCredentialsMap[credential.AdditionalProperties.Repository] = &credential
@@ -735,12 +674,57 @@ func isAllowedPipelineForPush(credential ContainerRegistryCredentials, fullRepos
 	return isMatch
 }
 
+const (
+	credentialsScopePull = "pull"
+	credentialsScopePush = "push"
+)
+
+// getScopes returns the scopes the credential can be used for; credentials without scope can be used for both pull and push
+func getScopes(credential ContainerRegistryCredentials) []string {
+
+	scope := strings.TrimSpace(credential.AdditionalProperties.Scope)
+	if scope == "" {
+		return []string{credentialsScopePull, credentialsScopePush}
+	}
+
+	var scopes []string
+	for _, s := range strings.Split(scope, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s != "" && !contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes
+}
+
+// validateScopes returns an error for credentials with a scope other than pull or push, since a typo would make them silently unusable
+func validateScopes(credentials []ContainerRegistryCredentials) error {
+	for _, c := range credentials {
+		for _, s := range getScopes(c) {
+			if s != credentialsScopePull && s != credentialsScopePush {
+				return fmt.Errorf("Credentials %v have scope %v; set scope to pull, push or both separated by a comma", c.Name, s)
+			}
+		}
+	}
+	return nil
+}
+
+// hasScope returns true if the credential can be used for pushing when push is true, or for pulling when push is false
+func hasScope(credential ContainerRegistryCredentials, push bool) bool {
+	if push {
+		return contains(getScopes(credential), credentialsScopePush)
+	}
+	return contains(getScopes(credential), credentialsScopePull)
+}
+
 var (
 	imagesFromDockerFileRegex *regexp.Regexp
 )
 
 type fromImage struct {
 	imagePath                string
+	platform                 string
 	stageName                string
 	isOfficialDockerHubImage bool
 }
@@ -750,23 +734,25 @@ func getFromImagePathsFromDockerfile(dockerfileContent string) ([]fromImage, err
 	var containerImages []fromImage
 
 	if imagesFromDockerFileRegex == nil {
-		imagesFromDockerFileRegex = regexp.MustCompile(`(?mi)^\s*FROM\s+([^\s]+)(\s+AS\s+([^\s]+))?\s*$`)
+		imagesFromDockerFileRegex = regexp.MustCompile(`(?mi)^\s*FROM\s+(?:--platform=([^\s]+)\s+)?([^\s]+)(\s+AS\s+([^\s]+))?\s*$`)
 	}
 
-	matches := imagesFromDockerFileRegex.FindAllStringSubmatch(dockerfileContent, -1)
+	// heredoc bodies can contain lines starting with FROM, like sql queries
+	matches := imagesFromDockerFileRegex.FindAllStringSubmatch(removeHeredocBodies(dockerfileContent), -1)
 
 	log.Debug().Interface("matches", matches).Msg("Showing FROM matches")
 
 	if len(matches) > 0 {
 		for _, m := range matches {
-			if len(m) > 1 {
-				image := m[1]
+			if len(m) > 2 {
+				image := m[2]
 				stageName := ""
-				if len(m) > 3 {
-					stageName = m[3]
+				if len(m) > 4 {
+					stageName = m[4]
 				}
 				containerImages = append(containerImages, fromImage{
 					imagePath:                image,
+					platform:                 m[1],
 					isOfficialDockerHubImage: strings.Count(image, "/") == 0 || strings.Contains(image, "$"),
 					stageName:                stageName,
 				})
@@ -779,14 +765,26 @@ func getFromImagePathsFromDockerfile(dockerfileContent string) ([]fromImage, err
 	return containerImages, nil
 }
 
-func loginIfRequired(credentials []ContainerRegistryCredentials, push bool, containerImages ...string) {
+// loginIfRequired logs in with the credentials for the container images and logs a fatal on error
+func loginIfRequired(ctx context.Context, credentials []ContainerRegistryCredentials, push bool, containerImages ...string) {
+	err := loginIfRequiredExtended(ctx, credentials, push, containerImages...)
+	foundation.HandleError(err)
+}
+
+// loginIfRequiredExtended logs in with the credentials for the container images and returns an error if a login fails
+func loginIfRequiredExtended(ctx context.Context, credentials []ContainerRegistryCredentials, push bool, containerImages ...string) error {
 
 	log.Info().Msgf("Filtering credentials for images %v", containerImages)
 
-	// retrieve all credentials
-	filteredCredentialsMap := getCredentialsForContainers(credentials, containerImages)
+	scope := credentialsScopePull
+	if push {
+		scope = credentialsScopePush
+	}
+
+	// retrieve all credentials with the required scope
+	filteredCredentialsMap := getCredentialsForContainers(credentials, push, containerImages)
 
-	log.Info().Msgf("Filtered %v container-registry credentials down to %v", len(credentials), len(filteredCredentialsMap))
+	log.Info().Msgf("Filtered %v container-registry credentials down to %v with %v scope", len(credentials), len(filteredCredentialsMap), scope)
 
 	if push && len(filteredCredentialsMap) == 0 {
 		log.Warn().Msgf("No credentials found for images %v while it's needed for a push. Disable ", containerImages)
@@ -801,13 +799,15 @@ func loginIfRequired(credentials []ContainerRegistryCredentials, push bool, cont
 				continue
 			}
 
-			log.Info().Msgf("Logging in to repository '%v'", c.AdditionalProperties.Repository)
+			log.Info().Str("credentials", c.Name).Str("scope", scope).Msgf("Logging in to repository '%v' with %v credentials '%v'", c.AdditionalProperties.Repository, scope, c.Name)
+			if *dryRun {
+				plan.addCredentials(*c, scope)
+			}
 			loginArgs := []string{
 				"login",
 				"--username",
 				c.AdditionalProperties.Username,
-				"--password",
-				c.AdditionalProperties.Password,
+				"--password-stdin",
 			}
 
 			repositorySlice := strings.Split(c.AdditionalProperties.Repository, "/")
@@ -816,10 +816,14 @@ func loginIfRequired(credentials []ContainerRegistryCredentials, push bool, cont
 				loginArgs = append(loginArgs, server)
 			}
 
-			err := exec.Command("docker", loginArgs...).Run()
-			foundation.HandleError(err)
+			err := runDockerCommandWithRetryExtended(ctx, "login", loginArgs, c.AdditionalProperties.Password)
+			if err != nil {
+				return fmt.Errorf("logging in to repository %v with credentials %v failed: %w", c.AdditionalProperties.Repository, c.Name, err)
+			}
 		}
 	}
+
+	return nil
 }
 
 func tidyTag(tag string) string {
@@ -827,7 +831,7 @@ func tidyTag(tag string) string {
 	tag = regexp.MustCompile(`[^a-zA-Z0-9_.\-]+`).ReplaceAllString(tag, "-")
 
 	// A tag name may not start with a period or a dash
-	tag = regexp.MustCompile(`$[_.\-]+`).ReplaceAllString(tag, "")
+	tag = regexp.MustCompile(`^[.\-]+`).ReplaceAllString(tag, "")
 
 	// and may contain a maximum of 128 characters.
 	if len(tag) > 128 {
@@ -859,20 +863,22 @@ func pathExists(path string) (bool, error) {
 
 func expandEnvironmentVariablesIfSet(dockerfile string, dontExpand *string) string {
 
-	return os.Expand(dockerfile, func(envar string) string {
+	envarsToSkipForExpansion := []string{}
+	if dontExpand != nil {
+		envarsToSkipForExpansion = strings.Split(*dontExpand, ",")
+	}
 
-		envarsToSkipForExpansion := []string{}
-		if dontExpand != nil {
-			envarsToSkipForExpansion = strings.Split(*dontExpand, ",")
-		}
+	return expandDockerfile(dockerfile, func(text string) string {
+		return os.Expand(text, func(envar string) string {
 
-		if !contains(envarsToSkipForExpansion, envar) {
-			value := os.Getenv(envar)
-			if value != "" {
-				return value
+			if !contains(envarsToSkipForExpansion, envar) {
+				value := os.Getenv(envar)
+				if value != "" {
+					return value
+				}
 			}
-		}
 
-		return fmt.Sprintf("${%v}", envar)
-	})
+			return fmt.Sprintf("${%v}", envar)
+		})
+	}, *expandHeredocs, *expandMounts)
 }
@@ -16,7 +16,7 @@ func TestGetCredentialsForContainers(t *testing.T) {
 		}
 
 		// act
-		filteredCredentialsMap := getCredentialsForContainers(credentials, containerImages)
+		filteredCredentialsMap := getCredentialsForContainers(credentials, false, containerImages)
 
 		assert.Equal(t, 0, len(filteredCredentialsMap))
 	})
@@ -40,7 +40,7 @@ func TestGetCredentialsForContainers(t *testing.T) {
 		containerImages := []string{}
 
 		// act
-		filteredCredentialsMap := getCredentialsForContainers(credentials, containerImages)
+		filteredCredentialsMap := getCredentialsForContainers(credentials, false, containerImages)
 
 		assert.Equal(t, 0, len(filteredCredentialsMap))
 	})
@@ -66,7 +66,7 @@ func TestGetCredentialsForContainers(t *testing.T) {
 		}
 
 		// act
-		filteredCredentialsMap := getCredentialsForContainers(credentials, containerImages)
+		filteredCredentialsMap := getCredentialsForContainers(credentials, false, containerImages)
 
 		assert.Equal(t, 0, len(filteredCredentialsMap))
 	})
@@ -104,7 +104,7 @@ func TestGetCredentialsForContainers(t *testing.T) {
 		}
 
 		// act
-		filteredCredentialsMap := getCredentialsForContainers(credentials, containerImages)
+		filteredCredentialsMap := getCredentialsForContainers(credentials, false, containerImages)
 
 		assert.Equal(t, 1, len(filteredCredentialsMap))
 		assert.Equal(t, "container-registry-extensions", filteredCredentialsMap["extensions"].Name)
@@ -144,7 +144,7 @@ func TestGetCredentialsForContainers(t *testing.T) {
 		}
 
 		// act
-		filteredCredentialsMap := getCredentialsForContainers(credentials, containerImages)
+		filteredCredentialsMap := getCredentialsForContainers(credentials, false, containerImages)
 
 		assert.Equal(t, 2, len(filteredCredentialsMap))
 		assert.Equal(t, "container-registry-extensions", filteredCredentialsMap["extensions"].Name)
@@ -198,7 +198,7 @@ func TestGetCredentialsForContainers(t *testing.T) {
 		}
 
 		// act
-		filteredCredentialsMap := getCredentialsForContainers(credentials, containerImages)
+		filteredCredentialsMap := getCredentialsForContainers(credentials, false, containerImages)
 
 		assert.Equal(t, 2, len(filteredCredentialsMap))
 		assert.Equal(t, "container-registry-extensions", filteredCredentialsMap["extensions"].Name)
@@ -226,7 +226,7 @@ func TestGetCredentialsForContainers(t *testing.T) {
 		}
 
 		// act
-		filteredCredentialsMap := getCredentialsForContainers(credentials, containerImages)
+		filteredCredentialsMap := getCredentialsForContainers(credentials, false, containerImages)
 
 		assert.Equal(t, 1, len(filteredCredentialsMap))
 		assert.Equal(t, "container-registry-gcr-estafette", filteredCredentialsMap["gcr.io/estafette"].Name)
@@ -253,11 +253,179 @@ func TestGetCredentialsForContainers(t *testing.T) {
 		}
 
 		// act
-		filteredCredentialsMap := getCredentialsForContainers(credentials, containerImages)
+		filteredCredentialsMap := getCredentialsForContainers(credentials, false, containerImages)
 
 		assert.Equal(t, 1, len(filteredCredentialsMap))
 		assert.Equal(t, "container-registry-gcr-estafette-eu", filteredCredentialsMap["eu.gcr.io/estafette"].Name)
 	})
+
+	t.Run("ReturnsPullCredentialsIfNotPushing", func(t *testing.T) {
+
+		credentials := []ContainerRegistryCredentials{
+			ContainerRegistryCredentials{
+				Name: "container-registry-extensions-push",
+				Type: "container-registry",
+				AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{
+					Repository: "extensions",
+					Username:   "pusher",
+					Password:   "password",
+					Scope:      "push",
+				},
+			},
+			ContainerRegistryCredentials{
+				Name: "container-registry-extensions-pull",
+				Type: "container-registry",
+				AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{
+					Repository: "extensions",
+					Username:   "puller",
+					Password:   "password",
+					Scope:      "pull",
+				},
+			},
+		}
+		containerImages := []string{
+			"extensions/docker:stable",
+		}
+
+		// act
+		filteredCredentialsMap := getCredentialsForContainers(credentials, false, containerImages)
+
+		assert.Equal(t, 1, len(filteredCredentialsMap))
+		assert.Equal(t, "container-registry-extensions-pull", filteredCredentialsMap["extensions"].Name)
+	})
+
+	t.Run("ReturnsPushCredentialsIfPushing", func(t *testing.T) {
+
+		credentials := []ContainerRegistryCredentials{
+			ContainerRegistryCredentials{
+				Name: "container-registry-extensions-pull",
+				Type: "container-registry",
+				AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{
+					Repository: "extensions",
+					Username:   "puller",
+					Password:   "password",
+					Scope:      "pull",
+				},
+			},
+			ContainerRegistryCredentials{
+				Name: "container-registry-extensions-push",
+				Type: "container-registry",
+				AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{
+					Repository: "extensions",
+					Username:   "pusher",
+					Password:   "password",
+					Scope:      "push",
+				},
+			},
+		}
+		containerImages := []string{
+			"extensions/docker:stable",
+		}
+
+		// act
+		filteredCredentialsMap := getCredentialsForContainers(credentials, true, containerImages)
+
+		assert.Equal(t, 1, len(filteredCredentialsMap))
+		assert.Equal(t, "container-registry-extensions-push", filteredCredentialsMap["extensions"].Name)
+	})
+
+	t.Run("ReturnsEmptyMapIfOnlyPullCredentialsExistWhilePushing", func(t *testing.T) {
+
+		credentials := []ContainerRegistryCredentials{
+			ContainerRegistryCredentials{
+				Name: "container-registry-extensions-pull",
+				Type: "container-registry",
+				AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{
+					Repository: "extensions",
+					Username:   "puller",
+					Password:   "password",
+					Scope:      "pull",
+				},
+			},
+		}
+		containerImages := []string{
+			"extensions/docker:stable",
+		}
+
+		// act
+		filteredCredentialsMap := getCredentialsForContainers(credentials, true, containerImages)
+
+		assert.Equal(t, 0, len(filteredCredentialsMap))
+	})
+}
+
+func TestValidateScopes(t *testing.T) {
+	t.Run("ReturnsNilForPullPushAndEmptyScopes", func(t *testing.T) {
+
+		credentials := []ContainerRegistryCredentials{
+			{Name: "pull", AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{Scope: "pull"}},
+			{Name: "push", AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{Scope: "Pull, push"}},
+			{Name: "any"},
+		}
+
+		// act
+		err := validateScopes(credentials)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorForUnknownScope", func(t *testing.T) {
+
+		credentials := []ContainerRegistryCredentials{
+			{Name: "typo", AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{Scope: "write"}},
+		}
+
+		// act
+		err := validateScopes(credentials)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestHasScope(t *testing.T) {
+	t.Run("ReturnsTrueForPullAndPushIfScopeIsEmpty", func(t *testing.T) {
+
+		credential := ContainerRegistryCredentials{}
+
+		assert.True(t, hasScope(credential, false))
+		assert.True(t, hasScope(credential, true))
+	})
+
+	t.Run("ReturnsFalseForPushIfScopeIsPull", func(t *testing.T) {
+
+		credential := ContainerRegistryCredentials{
+			AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{
+				Scope: "pull",
+			},
+		}
+
+		assert.True(t, hasScope(credential, false))
+		assert.False(t, hasScope(credential, true))
+	})
+
+	t.Run("ReturnsFalseForPullIfScopeIsPush", func(t *testing.T) {
+
+		credential := ContainerRegistryCredentials{
+			AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{
+				Scope: "push",
+			},
+		}
+
+		assert.False(t, hasScope(credential, false))
+		assert.True(t, hasScope(credential, true))
+	})
+
+	t.Run("ReturnsTrueForPullAndPushIfScopeListsBoth", func(t *testing.T) {
+
+		credential := ContainerRegistryCredentials{
+			AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{
+				Scope: "Pull, push",
+			},
+		}
+
+		assert.True(t, hasScope(credential, false))
+		assert.True(t, hasScope(credential, true))
+	})
 }
 
 func TestGetFromImagePathsFromDockerfile(t *testing.T) {
@@ -356,6 +524,34 @@ ENTRYPOINT ["/estafette-extension-docker"]`
 		assert.Equal(t, "", containerImages[1].stageName)
 	})
 
+	t.Run("ReturnsContainerImageAndPlatformForFromWithPlatform", func(t *testing.T) {
+
+		dockerfileContent := "FROM --platform=$BUILDPLATFORM golang:1.22 AS builder\nFROM --platform=linux/arm64 prom/prometheus:latest"
+
+		// act
+		containerImages, err := getFromImagePathsFromDockerfile(dockerfileContent)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(containerImages))
+		assert.Equal(t, "golang:1.22", containerImages[0].imagePath)
+		assert.Equal(t, "$BUILDPLATFORM", containerImages[0].platform)
+		assert.Equal(t, "builder", containerImages[0].stageName)
+		assert.Equal(t, "prom/prometheus:latest", containerImages[1].imagePath)
+		assert.Equal(t, "linux/arm64", containerImages[1].platform)
+	})
+
+	t.Run("IgnoresFromLinesInHeredocBodies", func(t *testing.T) {
+
+		dockerfileContent := "# syntax=docker/dockerfile:1\nFROM postgres:16\nCOPY <<EOF /docker-entrypoint-initdb.d/init.sql\nSELECT *\nFROM users\nEOF"
+
+		// act
+		containerImages, err := getFromImagePathsFromDockerfile(dockerfileContent)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(containerImages))
+		assert.Equal(t, "postgres:16", containerImages[0].imagePath)
+	})
+
 	t.Run("TrimBomToFindFromPaths", func(t *testing.T) {
 
 		dockerfileBytes := []byte{0xef, 0xbb, 0xbf, 0x46, 0x52, 0x4f, 0x4d, 0x20, 0x6d, 0x63, 0x72, 0x2e, 0x6d, 0x69, 0x63, 0x72, 0x6f, 0x73, 0x6f, 0x66, 0x74, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x64, 0x6f, 0x74, 0x6e, 0x65, 0x74, 0x2f, 0x72, 0x75, 0x6e, 0x74, 0x69, 0x6d, 0x65, 0x2d, 0x64, 0x65, 0x70, 0x73, 0x3a, 0x35, 0x2e, 0x30, 0xa, 0xa, 0x57, 0x4f, 0x52, 0x4b, 0x44, 0x49, 0x52, 0x20, 0x2f, 0x61, 0x70, 0x70, 0xa, 0x43, 0x4f, 0x50, 0x59, 0x20, 0x2e, 0x20, 0x2e, 0x2f, 0xa, 0xa, 0x52, 0x55, 0x4e, 0x20, 0x61, 0x70, 0x74, 0x2d, 0x67, 0x65, 0x74, 0x20, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x20, 0x5c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x26, 0x26, 0x20, 0x61, 0x70, 0x74, 0x2d, 0x67, 0x65, 0x74, 0x20, 0x69, 0x6e, 0x73, 0x74, 0x61, 0x6c, 0x6c, 0x20, 0x2d, 0x79, 0x20, 0x2d, 0x2d, 0x61, 0x6c, 0x6c, 0x6f, 0x77, 0x2d, 0x75, 0x6e, 0x61, 0x75, 0x74, 0x68, 0x65, 0x6e, 0x74, 0x69, 0x63, 0x61, 0x74, 0x65, 0x64, 0x20, 0x5c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x6c, 0x69, 0x62, 0x63, 0x36, 0x2d, 0x64, 0x65, 0x76, 0x20, 0x5c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x6c, 0x69, 0x62, 0x67, 0x64, 0x69, 0x70, 0x6c, 0x75, 0x73, 0x20, 0x5c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x6c, 0x69, 0x62, 0x78, 0x31, 0x31, 0x2d, 0x64, 0x65, 0x76, 0x20, 0x5c, 0xa, 0x20, 0x20, 0x20, 0x20, 0x20, 0x26, 0x26, 0x20, 0x72, 0x6d, 0x20, 0x2d, 0x72, 0x66, 0x20, 0x2f, 0x76, 0x61, 0x72, 0x2f, 0x6c, 0x69, 0x62, 0x2f, 0x61, 0x70, 0x74, 0x2f, 0x6c, 0x69, 0x73, 0x74, 0x73, 0x2f, 0x2a, 0xa, 0xa, 0x43, 0x4d, 0x44, 0x20, 0x5b, 0x22, 0x64, 0x6f, 0x74, 0x6e, 0x65, 0x74, 0x22, 0x2c, 0x20, 0x22, 0x2e, 0x2f, 0x53, 0x6f, 0x6d, 0x65, 0x2e, 0x64, 0x6c, 0x6c, 0x22, 0x5d, 0xa}
@@ -373,6 +569,20 @@ ENTRYPOINT ["/estafette-extension-docker"]`
 		assert.Equal(t, "mcr.microsoft.com/dotnet/runtime-deps:5.0", containerImages[0].imagePath)
 		assert.Equal(t, false, containerImages[0].isOfficialDockerHubImage)
 	})
+
+	t.Run("ReturnsAllStagesIfRunUsesHereString", func(t *testing.T) {
+
+		dockerfileContent := "FROM golang AS builder\nRUN read x <<<hello\nFROM alpine:3.19"
+
+		// act
+		containerImages, err := getFromImagePathsFromDockerfile(dockerfileContent)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(containerImages)) {
+			assert.Equal(t, "golang", containerImages[0].imagePath)
+			assert.Equal(t, "alpine:3.19", containerImages[1].imagePath)
+		}
+	})
 }
 
 func TestTidyBuildVersionAsTag(t *testing.T) {
@@ -395,4 +605,56 @@ func TestTidyBuildVersionAsTag(t *testing.T) {
 
 		assert.Equal(t, "0.0.187-release-release-x", tag)
 	})
+
+	t.Run("ReturnsLeadingPeriodsAndDashesRemoved", func(t *testing.T) {
+
+		buildVersion := ".-1.0.23"
+
+		// act
+		tag := tidyTag(buildVersion)
+
+		assert.Equal(t, "1.0.23", tag)
+	})
+}
+
+func TestValidateContainerImages(t *testing.T) {
+	t.Run("ReturnsNilForValidContainerImages", func(t *testing.T) {
+
+		// act
+		err := validateContainerImages("push", []string{"extensions", "eu.gcr.io/travix-com"}, "docker", []string{"stable", "1.0.0"})
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorForInvalidRepository", func(t *testing.T) {
+
+		// act
+		err := validateContainerImages("push", []string{"Extensions"}, "docker", []string{})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForInvalidContainer", func(t *testing.T) {
+
+		// act
+		err := validateContainerImages("build", []string{"extensions"}, "docker_", []string{})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForMissingContainer", func(t *testing.T) {
+
+		// act
+		err := validateContainerImages("build", []string{"extensions"}, "", []string{})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForInvalidTag", func(t *testing.T) {
+
+		// act
+		err := validateContainerImages("tag", []string{"extensions"}, "docker", []string{"-stable"})
+
+		assert.NotNil(t, err)
+	})
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/estafette/estafette-extension-docker/reference"
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+// getMirrorTags returns the tags matching both the tag filter and the semver range, if set
+func getMirrorTags(tags []string, tagFilter *regexp.Regexp, versionRange semverRange) []string {
+
+	var filteredTags []string
+	for _, t := range tags {
+		if tagFilter != nil && !tagFilter.MatchString(t) {
+			continue
+		}
+		if versionRange != nil {
+			v, err := parseSemanticVersion(t)
+			if err != nil || !versionRange.matches(v) {
+				continue
+			}
+		}
+		filteredTags = append(filteredTags, t)
+	}
+
+	return filteredTags
+}
+
+// mirrorContainerImages copies all platforms of the source images to the target repositories; sources without tag get all their tags copied, limited by tag filter and semver range
+func mirrorContainerImages(ctx context.Context, credentials []ContainerRegistryCredentials, sources, targetRepositories []string, tagFilterValue, versionRangeValue string) error {
+
+	var tagFilter *regexp.Regexp
+	if tagFilterValue != "" {
+		var err error
+		tagFilter, err = regexp.Compile(fmt.Sprintf("^(%v)$", tagFilterValue))
+		if err != nil {
+			return fmt.Errorf("invalid mirror tag filter %v: %w", tagFilterValue, err)
+		}
+	}
+
+	var versionRange semverRange
+	if versionRangeValue != "" {
+		var err error
+		versionRange, err = parseSemverRange(versionRangeValue)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := checkBuildxAvailable(ctx)
+	if err != nil {
+		return err
+	}
+
+	client := newRegistryClient(credentials)
+
+	var mirrorErrors []error
+	for _, s := range sources {
+
+		sourceReference, err := reference.Parse(s)
+		if err != nil {
+			return err
+		}
+		sourceRepository, sourceTag, sourceDigest := sourceReference.Name(), sourceReference.Tag, sourceReference.Digest
+		if sourceTag == "" && sourceDigest != "" {
+			return fmt.Errorf("source %v has a digest but no tag; add the tag to mirror it under", s)
+		}
+
+		tags := []string{sourceTag}
+		if sourceTag == "" {
+			log.Info().Msgf("Listing tags for %v...", sourceRepository)
+			allTags, err := client.listTags(ctx, sourceRepository)
+			if err != nil {
+				return fmt.Errorf("failed listing tags for %v: %w", sourceRepository, err)
+			}
+			tags = getMirrorTags(allTags, tagFilter, versionRange)
+			log.Info().Msgf("Selected %v out of %v tags for %v", len(tags), len(allTags), sourceRepository)
+		}
+
+		var targetRepositoriesForSource []string
+		for _, r := range targetRepositories {
+			targetRepositoriesForSource = append(targetRepositoriesForSource, fmt.Sprintf("%v/%v", r, sourceRepository[strings.LastIndex(sourceRepository, "/")+1:]))
+		}
+
+		// a docker login replaces the one for the same registry server (moby#37569), so log in right before mirroring to each group of target repositories with the same credentials
+		sourceServer, _ := reference.SplitDomain(sourceRepository)
+		sourceCredentials := getCredentialsName(credentials, false, sourceRepository)
+		for _, g := range getPushGroups(credentials, targetRepositoriesForSource) {
+
+			if g.server == sourceServer && sourceCredentials != "" && g.credentials != "" && sourceCredentials != g.credentials {
+				log.Warn().Msgf("Source %v and target repositories %v are on registry server %v with different credentials %v and %v; the latter are used to pull as well", sourceRepository, g.containerPaths, g.server, sourceCredentials, g.credentials)
+			}
+
+			err := loginIfRequiredExtended(ctx, credentials, false, sourceRepository)
+			if err == nil {
+				err = loginIfRequiredExtended(ctx, credentials, true, g.containerPaths...)
+			}
+			if err != nil {
+				mirrorErrors = append(mirrorErrors, fmt.Errorf("mirroring %v to %v failed: %w", sourceRepository, g.containerPaths, err))
+				continue
+			}
+
+			for _, targetRepository := range g.containerPaths {
+				for _, t := range tags {
+
+					sourceReference := fmt.Sprintf("%v:%v", sourceRepository, t)
+					if sourceDigest != "" {
+						sourceReference += "@" + sourceDigest
+					}
+					targetReference := fmt.Sprintf("%v:%v", targetRepository, t)
+
+					// skip copying if the target already points at the same manifest
+					sourceManifestDigest := sourceDigest
+					if sourceManifestDigest == "" {
+						var err error
+						sourceManifestDigest, err = client.getManifestDigest(ctx, sourceRepository, t)
+						if err != nil {
+							mirrorErrors = append(mirrorErrors, fmt.Errorf("failed retrieving digest for %v: %w", sourceReference, err))
+							continue
+						}
+					}
+					targetManifestDigest, err := client.getManifestDigest(ctx, targetRepository, t)
+					if err != nil && !errors.Is(err, errManifestUnknown) {
+						log.Warn().Err(err).Msgf("Failed retrieving digest for %v, copying anyway", targetReference)
+					}
+					if targetManifestDigest == sourceManifestDigest {
+						log.Info().Msgf("Skipping %v, %v is already at %v", sourceReference, targetReference, sourceManifestDigest)
+						continue
+					}
+
+					// imagetools copies all platforms registry to registry and only uploads blobs missing in the target
+					log.Info().Msgf("Mirroring container image %v to %v", sourceReference, targetReference)
+					imagetoolsArgs := []string{
+						"buildx",
+						"imagetools",
+						"create",
+						"--tag",
+						targetReference,
+						sourceReference,
+					}
+					err = runDockerCommandWithRetryExtended(ctx, "mirror", imagetoolsArgs, "")
+					if err != nil {
+						mirrorErrors = append(mirrorErrors, fmt.Errorf("mirroring %v to %v failed: %w", sourceReference, targetReference, err))
+					}
+				}
+
+			}
+		}
+	}
+
+	return errors.Join(mirrorErrors...)
+}
+
+// checkBuildxAvailable returns an error if the docker cli has no buildx plugin, which mirroring needs for imagetools
+func checkBuildxAvailable(ctx context.Context) error {
+
+	if *dryRun {
+		plan.addStep("check docker buildx is available", "docker", []string{"buildx", "version"})
+		return nil
+	}
+
+	_, err := foundation.GetCommandWithArgsOutput(ctx, "docker", []string{"buildx", "version"})
+	if err != nil {
+		return fmt.Errorf("Mirroring needs the docker buildx plugin for `docker buildx imagetools`, but it isn't available in this docker cli: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMirrorTags(t *testing.T) {
+
+	tags := []string{"latest", "7.0.15", "7.2.4", "7.2.5", "7.2.5-debian-12", "7.4.0", "8.0.0-rc1"}
+
+	t.Run("ReturnsAllTagsWithoutFilters", func(t *testing.T) {
+
+		// act
+		filteredTags := getMirrorTags(tags, nil, nil)
+
+		assert.Equal(t, tags, filteredTags)
+	})
+
+	t.Run("ReturnsTagsFullyMatchingTagFilter", func(t *testing.T) {
+
+		// act
+		filteredTags := getMirrorTags(tags, regexp.MustCompile(`^(7\.2\.\d+)$`), nil)
+
+		assert.Equal(t, []string{"7.2.4", "7.2.5"}, filteredTags)
+	})
+
+	t.Run("ReturnsTagsMatchingSemverRange", func(t *testing.T) {
+
+		versionRange, _ := parseSemverRange("^7.2")
+
+		// act
+		filteredTags := getMirrorTags(tags, nil, versionRange)
+
+		assert.Equal(t, []string{"7.2.4", "7.2.5", "7.4.0"}, filteredTags)
+	})
+}
+
+func TestCheckBuildxAvailable(t *testing.T) {
+	t.Run("RecordsBuildxVersionStepInDryRun", func(t *testing.T) {
+
+		originalDryRun, originalPlan := *dryRun, plan
+		defer func() { *dryRun, plan = originalDryRun, originalPlan }()
+		*dryRun = true
+		plan = &executionPlan{}
+
+		// act
+		err := checkBuildxAvailable(context.Background())
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(plan.Steps))
+		assert.Equal(t, []string{"buildx", "version"}, plan.Steps[0].Args)
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+// executionPlan collects everything an action would do when running with dry-run
+type executionPlan struct {
+	mutex sync.Mutex
+
+	Action       string            `json:"action"`
+	Container    string            `json:"container,omitempty"`
+	Images       []string          `json:"images,omitempty"`
+	Repositories []string          `json:"repositories,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	VersionTag   string            `json:"versionTag,omitempty"`
+	Credentials  []planCredentials `json:"credentials,omitempty"`
+	Steps        []planStep        `json:"steps"`
+}
+
+// planCredentials are the credentials an action would log in with
+type planCredentials struct {
+	Name       string `json:"name"`
+	Repository string `json:"repository"`
+	Scope      string `json:"scope"`
+}
+
+// planStep is a single command or file operation an action would execute
+type planStep struct {
+	Description string   `json:"description"`
+	Command     string   `json:"command,omitempty"`
+	Args        []string `json:"args,omitempty"`
+}
+
+var (
+	plan = &executionPlan{}
+)
+
+func (p *executionPlan) addStep(description, command string, args []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.Steps = append(p.Steps, planStep{
+		Description: description,
+		Command:     command,
+		Args:        maskBuildArgs(args),
+	})
+}
+
+func (p *executionPlan) addCredentials(credential ContainerRegistryCredentials, scope string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, c := range p.Credentials {
+		if c.Name == credential.Name && c.Scope == scope {
+			return
+		}
+	}
+	p.Credentials = append(p.Credentials, planCredentials{
+		Name:       credential.Name,
+		Repository: credential.AdditionalProperties.Repository,
+		Scope:      scope,
+	})
+}
+
+// maskBuildArgs hides build argument and environment variable values, since they're often taken from secret environment variables
+func maskBuildArgs(args []string) []string {
+	if args == nil {
+		return nil
+	}
+
+	maskedArgs := make([]string, len(args))
+	for i, a := range args {
+		maskedArgs[i] = a
+		if i > 0 && (args[i-1] == "--build-arg" || args[i-1] == "--env") {
+			name, _, hasValue := strings.Cut(a, "=")
+			if hasValue && !strings.HasPrefix(name, "BUILDKIT_") && name != "SOURCE_DATE_EPOCH" {
+				maskedArgs[i] = name + "=***"
+			}
+		}
+	}
+
+	return maskedArgs
+}
+
+// printPlan logs the execution plan in human readable form followed by json
+func printPlan() {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+
+	log.Info().Msgf("Execution plan for action %v (dry-run, nothing has been executed):", plan.Action)
+	for _, c := range plan.Credentials {
+		log.Info().Msgf("- log in to %v with %v credentials %v", c.Repository, c.Scope, c.Name)
+	}
+	for i, s := range plan.Steps {
+		if s.Command != "" {
+			log.Info().Msgf("%v. %v: %v %v", i+1, s.Description, s.Command, strings.Join(s.Args, " "))
+		} else {
+			log.Info().Msgf("%v. %v", i+1, s.Description)
+		}
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed marshalling execution plan to json")
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// runCommand runs a command and logs a fatal on error; with dry-run it only adds the command to the execution plan
+func runCommand(ctx context.Context, description, command string, args []string) {
+	err := runCommandExtended(ctx, description, command, args)
+	foundation.HandleError(err)
+}
+
+// runCommandExtended runs a command and returns an error if it failed; with dry-run it only adds the command to the execution plan
+func runCommandExtended(ctx context.Context, description, command string, args []string) error {
+	if *dryRun {
+		plan.addStep(description, command, args)
+		return nil
+	}
+	return foundation.RunCommandWithArgsExtended(ctx, command, args)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskBuildArgs(t *testing.T) {
+	t.Run("ReturnsNilForNilArgs", func(t *testing.T) {
+
+		// act
+		maskedArgs := maskBuildArgs(nil)
+
+		assert.Nil(t, maskedArgs)
+	})
+
+	t.Run("MasksBuildArgValues", func(t *testing.T) {
+
+		args := []string{"build", "--build-arg", "NPM_TOKEN=secret", "--file", "Dockerfile", "."}
+
+		// act
+		maskedArgs := maskBuildArgs(args)
+
+		assert.Equal(t, []string{"build", "--build-arg", "NPM_TOKEN=***", "--file", "Dockerfile", "."}, maskedArgs)
+		assert.Equal(t, "NPM_TOKEN=secret", args[2])
+	})
+
+	t.Run("KeepsBuildkitBuildArgValues", func(t *testing.T) {
+
+		args := []string{"build", "--build-arg", "BUILDKIT_INLINE_CACHE=1"}
+
+		// act
+		maskedArgs := maskBuildArgs(args)
+
+		assert.Equal(t, []string{"build", "--build-arg", "BUILDKIT_INLINE_CACHE=1"}, maskedArgs)
+	})
+
+	t.Run("MasksEnvValues", func(t *testing.T) {
+
+		args := []string{"run", "--env", "TOKEN=secret", "extensions/docker:1.0.0"}
+
+		// act
+		maskedArgs := maskBuildArgs(args)
+
+		assert.Equal(t, []string{"run", "--env", "TOKEN=***", "extensions/docker:1.0.0"}, maskedArgs)
+	})
+
+	t.Run("KeepsOtherArgsContainingEqualSign", func(t *testing.T) {
+
+		args := []string{"build", "--label", "version=1.0.0"}
+
+		// act
+		maskedArgs := maskBuildArgs(args)
+
+		assert.Equal(t, args, maskedArgs)
+	})
+}
+
+func TestExecutionPlanAddCredentials(t *testing.T) {
+	t.Run("AddsCredentialsOncePerScope", func(t *testing.T) {
+
+		p := &executionPlan{}
+		credential := ContainerRegistryCredentials{Name: "container-registry-extensions"}
+		credential.AdditionalProperties.Repository = "extensions"
+
+		// act
+		p.addCredentials(credential, credentialsScopePull)
+		p.addCredentials(credential, credentialsScopePull)
+		p.addCredentials(credential, credentialsScopePush)
+
+		assert.Equal(t, []planCredentials{
+			{Name: "container-registry-extensions", Repository: "extensions", Scope: credentialsScopePull},
+			{Name: "container-registry-extensions", Repository: "extensions", Scope: credentialsScopePush},
+		}, p.Credentials)
+	})
+}
+
+func TestRunCommandExtended(t *testing.T) {
+	t.Run("AddsStepToPlanInsteadOfRunningCommandWhenDryRun", func(t *testing.T) {
+
+		originalDryRun, originalPlan := *dryRun, plan
+		defer func() { *dryRun, plan = originalDryRun, originalPlan }()
+		*dryRun = true
+		plan = &executionPlan{}
+
+		// act
+		err := runCommandExtended(context.Background(), "docker build", "command-that-does-not-exist", []string{"build", "--build-arg", "TOKEN=secret"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []planStep{{Description: "docker build", Command: "command-that-does-not-exist", Args: []string{"build", "--build-arg", "TOKEN=***"}}}, plan.Steps)
+	})
+
+	t.Run("AddsDockerCommandToPlanInsteadOfRetryingWhenDryRun", func(t *testing.T) {
+
+		originalDryRun, originalPlan := *dryRun, plan
+		defer func() { *dryRun, plan = originalDryRun, originalPlan }()
+		*dryRun = true
+		plan = &executionPlan{}
+
+		// act
+		err := runDockerCommandWithRetryExtended(context.Background(), "push", []string{"push", "extensions/docker:1.0.0"}, "")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []planStep{{Description: "docker push", Command: "docker", Args: []string{"push", "extensions/docker:1.0.0"}}}, plan.Steps)
+	})
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/estafette/estafette-extension-docker/reference"
+	"github.com/rs/zerolog/log"
+)
+
+// pushError holds the error for a single container image that failed to push
+type pushError struct {
+	containerPath string
+	err           error
+}
+
+func (e pushError) Error() string {
+	return fmt.Sprintf("pushing container image %v failed: %v", e.containerPath, e.err)
+}
+
+func (e pushError) Unwrap() error {
+	return e.err
+}
+
+// pushContainerImages pushes all container images with at most parallelism pushes in flight; it returns an error per failed container image joined together
+func pushContainerImages(ctx context.Context, containerPaths []string, parallelism int) error {
+
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	return pushContainerImagesWithSlots(ctx, containerPaths, make(chan struct{}, parallelism))
+}
+
+// pushContainerImagesWithSlots pushes all container images, each taking one of the slots shared with other pushes while in flight
+func pushContainerImagesWithSlots(ctx context.Context, containerPaths []string, slots chan struct{}) error {
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var pushErrors []error
+	addError := func(containerPath string, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		pushErrors = append(pushErrors, pushError{containerPath: containerPath, err: err})
+	}
+
+	for _, cp := range containerPaths {
+		// wait for a free slot, unless sigterm cancels the context
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			addError(cp, ctx.Err())
+			continue
+		}
+
+		wg.Add(1)
+		go func(containerPath string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if ctx.Err() != nil {
+				addError(containerPath, ctx.Err())
+				return
+			}
+
+			log.Info().Msgf("Pushing container image %v", containerPath)
+			pushArgs := []string{
+				"push",
+				containerPath,
+			}
+			err := runDockerCommandWithRetryExtended(ctx, "push", pushArgs, "")
+			if err != nil {
+				addError(containerPath, err)
+			}
+		}(cp)
+	}
+
+	// wait for all in-flight pushes to finish
+	wg.Wait()
+
+	return errors.Join(pushErrors...)
+}
+
+// pushGroup holds the container images on a registry server pushed with the same credentials
+type pushGroup struct {
+	server         string
+	credentials    string
+	containerPaths []string
+}
+
+// getPushGroups groups the container images by registry server and push credentials, in order of appearance
+func getPushGroups(credentials []ContainerRegistryCredentials, containerPaths []string) []pushGroup {
+
+	var groups []pushGroup
+	groupIndexes := map[string]int{}
+	for _, cp := range containerPaths {
+		server, _ := reference.SplitDomain(cp)
+		credentialsName := getCredentialsName(credentials, true, cp)
+
+		key := server + "/" + credentialsName
+		index, ok := groupIndexes[key]
+		if !ok {
+			index = len(groups)
+			groupIndexes[key] = index
+			groups = append(groups, pushGroup{server: server, credentials: credentialsName})
+		}
+		groups[index].containerPaths = append(groups[index].containerPaths, cp)
+	}
+
+	return groups
+}
+
+// getCredentialsName returns the name of the credentials used to log in for the container image with the required scope, or empty if there are none
+func getCredentialsName(credentials []ContainerRegistryCredentials, push bool, containerPath string) string {
+	if c, ok := getCredentialsForContainers(credentials, push, []string{containerPath})[getCredentialsRepository(containerPath)]; ok && c != nil {
+		return c.Name
+	}
+	return ""
+}
+
+// loginAndPushContainerImages logs in and pushes per group of container images with the same credentials; a docker login replaces the one for the same registry server (moby#37569), so groups on the same server run one after another while different servers run in parallel
+func loginAndPushContainerImages(ctx context.Context, credentials []ContainerRegistryCredentials, containerPaths []string, parallelism int) error {
+
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	var servers []string
+	groupsPerServer := map[string][]pushGroup{}
+	for _, g := range getPushGroups(credentials, containerPaths) {
+		if _, ok := groupsPerServer[g.server]; !ok {
+			servers = append(servers, g.server)
+		}
+		groupsPerServer[g.server] = append(groupsPerServer[g.server], g)
+	}
+
+	slots := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+	// logins write the same docker config file, so they don't run at the same time
+	var loginMutex sync.Mutex
+	var mutex sync.Mutex
+	var groupErrors []error
+
+	for _, s := range servers {
+		wg.Add(1)
+		go func(groups []pushGroup) {
+			defer wg.Done()
+			for _, g := range groups {
+				loginMutex.Lock()
+				err := loginIfRequiredExtended(ctx, credentials, true, g.containerPaths...)
+				loginMutex.Unlock()
+				if err == nil {
+					err = pushContainerImagesWithSlots(ctx, g.containerPaths, slots)
+				}
+				if err != nil {
+					mutex.Lock()
+					groupErrors = append(groupErrors, err)
+					mutex.Unlock()
+				}
+			}
+		}(groupsPerServer[s])
+	}
+
+	wg.Wait()
+
+	return errors.Join(groupErrors...)
+}
+
+// pushContainerImage tags the built image with every repository and tag combination and pushes them
+func pushContainerImage(ctx context.Context, credentials []ContainerRegistryCredentials, image buildImage, version, versionTag, versionTagSuffix string) error {
+
+	sourceContainerPath := fmt.Sprintf("%v/%v:%v", image.Repositories[0], image.Container, versionTag)
+
+	if !*pushVersionTag && len(image.Tags) == 0 {
+		return fmt.Errorf("When setting pushVersionTag to false you need at least one tag")
+	}
+
+	backwardFloatingTags, err := getImageBackwardFloatingTags(ctx, credentials, image, version, versionTagSuffix)
+	if err != nil {
+		return err
+	}
+
+	// tag each repository + tag combination
+	var targetContainerPaths []string
+	for i, r := range image.Repositories {
+
+		targetContainerPath := fmt.Sprintf("%v/%v:%v", r, image.Container, versionTag)
+
+		if i > 0 {
+			// tag container with default tag (it already exists for the first repository)
+			log.Info().Msgf("Tagging container image %v", targetContainerPath)
+			tagArgs := []string{
+				"tag",
+				sourceContainerPath,
+				targetContainerPath,
+			}
+			err := runDockerCommandWithRetryExtended(ctx, "tag", tagArgs, "")
+			if err != nil {
+				return err
+			}
+		}
+
+		if *pushVersionTag {
+			targetContainerPaths = append(targetContainerPaths, targetContainerPath)
+		} else {
+			log.Info().Msg("Skipping pushing version tag, because pushVersionTag is set to false; this make promoting a version to a tag at a later stage impossible!")
+		}
+
+		// tag additional tags
+		for _, t := range image.Tags {
+
+			if r == image.Repositories[0] && t == versionTag {
+				continue
+			}
+
+			targetContainerPath := fmt.Sprintf("%v/%v:%v", r, image.Container, t)
+			if backwardFloatingTags[targetContainerPath] {
+				continue
+			}
+
+			// tag container with additional tag
+			log.Info().Msgf("Tagging container image %v", targetContainerPath)
+			tagArgs := []string{
+				"tag",
+				sourceContainerPath,
+				targetContainerPath,
+			}
+			err := runDockerCommandWithRetryExtended(ctx, "tag", tagArgs, "")
+			if err != nil {
+				return err
+			}
+
+			targetContainerPaths = append(targetContainerPaths, targetContainerPath)
+		}
+	}
+
+	// push each repository + tag combination
+	return loginAndPushContainerImages(ctx, credentials, targetContainerPaths, *pushParallelism)
+}
+
+// tagContainerImage pulls the version tag of a previously pushed image and pushes it with every repository and tag combination
+func tagContainerImage(ctx context.Context, credentials []ContainerRegistryCredentials, image buildImage, version, versionTag, versionTagSuffix string) error {
+
+	sourceContainerPath := fmt.Sprintf("%v/%v:%v", image.Repositories[0], image.Container, versionTag)
+
+	err := loginIfRequiredExtended(ctx, credentials, false, sourceContainerPath)
+	if err != nil {
+		return err
+	}
+
+	// pull source container first
+	log.Info().Msgf("Pulling container image %v", sourceContainerPath)
+	pullArgs := []string{
+		"pull",
+		sourceContainerPath,
+	}
+	err = runDockerCommandWithRetryExtended(ctx, "pull", pullArgs, "")
+	if err != nil {
+		return err
+	}
+
+	backwardFloatingTags, err := getImageBackwardFloatingTags(ctx, credentials, image, version, versionTagSuffix)
+	if err != nil {
+		return err
+	}
+
+	// tag each repository + tag combination
+	var targetContainerPaths []string
+	for i, r := range image.Repositories {
+
+		targetContainerPath := fmt.Sprintf("%v/%v:%v", r, image.Container, versionTag)
+
+		if i > 0 {
+			// tag container with default tag
+			log.Info().Msgf("Tagging container image %v", targetContainerPath)
+			tagArgs := []string{
+				"tag",
+				sourceContainerPath,
+				targetContainerPath,
+			}
+			err := runDockerCommandWithRetryExtended(ctx, "tag", tagArgs, "")
+			if err != nil {
+				return err
+			}
+
+			targetContainerPaths = append(targetContainerPaths, targetContainerPath)
+		}
+
+		// tag additional tags
+		for _, t := range image.Tags {
+
+			targetContainerPath := fmt.Sprintf("%v/%v:%v", r, image.Container, t)
+			if backwardFloatingTags[targetContainerPath] {
+				continue
+			}
+
+			// tag container with additional tag
+			log.Info().Msgf("Tagging container image %v", targetContainerPath)
+			tagArgs := []string{
+				"tag",
+				sourceContainerPath,
+				targetContainerPath,
+			}
+			err := runDockerCommandWithRetryExtended(ctx, "tag", tagArgs, "")
+			if err != nil {
+				return err
+			}
+
+			targetContainerPaths = append(targetContainerPaths, targetContainerPath)
+		}
+	}
+
+	// push each repository + tag combination
+	return loginAndPushContainerImages(ctx, credentials, targetContainerPaths, *pushParallelism)
+}
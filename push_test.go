@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushContainerImages(t *testing.T) {
+	t.Run("ReturnsNilIfThereAreNoContainerImages", func(t *testing.T) {
+
+		// act
+		err := pushContainerImages(context.Background(), []string{}, 4)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorForEachContainerImageIfContextIsCancelled", func(t *testing.T) {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		containerPaths := []string{
+			"extensions/docker:1.0.0",
+			"extensions/docker:stable",
+			"estafette/docker:1.0.0",
+		}
+
+		// act
+		err := pushContainerImages(ctx, containerPaths, 2)
+
+		assert.NotNil(t, err)
+		assert.True(t, errors.Is(err, context.Canceled))
+		for _, cp := range containerPaths {
+			assert.Contains(t, err.Error(), cp)
+		}
+	})
+}
+
+func TestGetPushGroups(t *testing.T) {
+	t.Run("ReturnsGroupPerServerAndCredentials", func(t *testing.T) {
+
+		credentials := []ContainerRegistryCredentials{
+			{Name: "gcr-extensions", AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{Repository: "eu.gcr.io/extensions", Username: "_json_key", Password: "extensions-key"}},
+			{Name: "gcr-estafette", AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{Repository: "eu.gcr.io/estafette", Username: "_json_key", Password: "estafette-key"}},
+		}
+		containerPaths := []string{
+			"eu.gcr.io/extensions/docker:1.0.0",
+			"eu.gcr.io/estafette/docker:1.0.0",
+			"eu.gcr.io/extensions/docker:stable",
+			"extensions/docker:1.0.0",
+		}
+
+		// act
+		groups := getPushGroups(credentials, containerPaths)
+
+		assert.Equal(t, []pushGroup{
+			{server: "eu.gcr.io", credentials: "gcr-extensions", containerPaths: []string{"eu.gcr.io/extensions/docker:1.0.0", "eu.gcr.io/extensions/docker:stable"}},
+			{server: "eu.gcr.io", credentials: "gcr-estafette", containerPaths: []string{"eu.gcr.io/estafette/docker:1.0.0"}},
+			{server: "docker.io", credentials: "", containerPaths: []string{"extensions/docker:1.0.0"}},
+		}, groups)
+	})
+}
+
+func TestLoginAndPushContainerImages(t *testing.T) {
+	t.Run("LogsInBeforePushingEachGroupOnTheSameServer", func(t *testing.T) {
+
+		originalDryRun, originalPlan := *dryRun, plan
+		defer func() { *dryRun, plan = originalDryRun, originalPlan }()
+		*dryRun = true
+		plan = &executionPlan{}
+
+		credentials := []ContainerRegistryCredentials{
+			{Name: "gcr-extensions", AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{Repository: "eu.gcr.io/extensions", Username: "_json_key", Password: "extensions-key"}},
+			{Name: "gcr-estafette", AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{Repository: "eu.gcr.io/estafette", Username: "_json_key", Password: "estafette-key"}},
+		}
+
+		// act
+		err := loginAndPushContainerImages(context.Background(), credentials, []string{"eu.gcr.io/extensions/docker:1.0.0", "eu.gcr.io/estafette/docker:1.0.0"}, 4)
+
+		assert.Nil(t, err)
+		var steps []string
+		for _, s := range plan.Steps {
+			steps = append(steps, s.Description+" "+s.Args[len(s.Args)-1])
+		}
+		assert.Equal(t, []string{
+			"docker login eu.gcr.io",
+			"docker push eu.gcr.io/extensions/docker:1.0.0",
+			"docker login eu.gcr.io",
+			"docker push eu.gcr.io/estafette/docker:1.0.0",
+		}, steps)
+		assert.Equal(t, []string{"gcr-extensions", "gcr-estafette"}, []string{plan.Credentials[0].Name, plan.Credentials[1].Name})
+	})
+}
+
+func TestGetCredentialsName(t *testing.T) {
+	credentials := []ContainerRegistryCredentials{
+		{Name: "gcr-extensions", AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{Repository: "eu.gcr.io/extensions", Username: "_json_key", Password: "extensions-key"}},
+	}
+
+	t.Run("ReturnsNameOfMatchingCredentials", func(t *testing.T) {
+
+		// act
+		name := getCredentialsName(credentials, true, "eu.gcr.io/extensions/docker")
+
+		assert.Equal(t, "gcr-extensions", name)
+	})
+
+	t.Run("ReturnsEmptyIfNoCredentialsMatch", func(t *testing.T) {
+
+		// act
+		name := getCredentialsName(credentials, true, "eu.gcr.io/estafette/docker")
+
+		assert.Equal(t, "", name)
+	})
+}
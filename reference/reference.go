@@ -0,0 +1,162 @@
+// Package reference parses and validates container image references like eu.gcr.io/travix-com/docker:1.0.0@sha256:... following the distribution spec
+package reference
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultDomain is the domain for images without a registry, like extensions/docker
+	DefaultDomain = "docker.io"
+
+	officialRepositoryPrefix = "library/"
+	nameTotalLengthMax       = 255
+)
+
+var (
+	// ErrReferenceInvalidFormat is returned when a reference doesn't follow the registry/repository:tag@digest grammar
+	ErrReferenceInvalidFormat = errors.New("invalid reference format")
+	// ErrNameEmpty is returned for an empty repository name
+	ErrNameEmpty = errors.New("repository name must have at least one component")
+	// ErrNameContainsUppercase is returned when the repository name has uppercase characters, which registries don't allow
+	ErrNameContainsUppercase = errors.New("repository name must be lowercase")
+	// ErrNameTooLong is returned when the repository name exceeds 255 characters
+	ErrNameTooLong = fmt.Errorf("repository name must not be more than %v characters", nameTotalLengthMax)
+	// ErrTagInvalidFormat is returned for tags that don't follow the tag grammar
+	ErrTagInvalidFormat = errors.New("tag may only contain letters, digits, underscores, periods and dashes, can't start with a period or dash and has a maximum of 128 characters")
+	// ErrDigestInvalidFormat is returned for digests that don't follow the digest grammar
+	ErrDigestInvalidFormat = errors.New("digest must look like <algorithm>:<hex>")
+
+	domainRegex        = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?$`)
+	pathComponentRegex = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*$`)
+	tagRegex           = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegex        = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// Reference is a parsed container image reference
+type Reference struct {
+	// Domain is the registry, empty if the reference has none
+	Domain string
+	// Path is the repository path within the registry
+	Path   string
+	Tag    string
+	Digest string
+}
+
+// Name returns the repository name including the domain, if any
+func (r Reference) Name() string {
+	if r.Domain == "" {
+		return r.Path
+	}
+	return r.Domain + "/" + r.Path
+}
+
+// String returns the full reference
+func (r Reference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// SplitDomain splits a repository name into its (normalized) domain and path without validating it
+func SplitDomain(name string) (domain, path string) {
+	domain, path = splitDomain(name)
+	return normalize(domain, path)
+}
+
+// Parse parses a reference like eu.gcr.io/travix-com/docker:1.0.0@sha256:... and validates every part
+func Parse(s string) (Reference, error) {
+
+	if s == "" {
+		return Reference{}, fmt.Errorf("%w: %q", ErrNameEmpty, s)
+	}
+
+	var r Reference
+	name, digest, hasDigest := strings.Cut(s, "@")
+	if hasDigest {
+		if !digestRegex.MatchString(digest) {
+			return Reference{}, fmt.Errorf("invalid digest in %q: %w", s, ErrDigestInvalidFormat)
+		}
+		r.Digest = digest
+	}
+
+	// a colon after the last slash separates the tag, one before it is the registry port
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.Tag = name[:i], name[i+1:]
+		if err := ValidateTag(r.Tag); err != nil {
+			return Reference{}, fmt.Errorf("invalid tag in %q: %w", s, err)
+		}
+	}
+
+	if err := ValidateName(name); err != nil {
+		return Reference{}, fmt.Errorf("invalid reference %q: %w", s, err)
+	}
+	r.Domain, r.Path = splitDomain(name)
+
+	return r, nil
+}
+
+// ValidateName validates a repository name without tag or digest, like eu.gcr.io/travix-com or extensions/docker
+func ValidateName(name string) error {
+
+	if name == "" {
+		return ErrNameEmpty
+	}
+	if len(name) > nameTotalLengthMax {
+		return ErrNameTooLong
+	}
+
+	domain, path := splitDomain(name)
+	if domain != "" && !domainRegex.MatchString(domain) {
+		return fmt.Errorf("%w: domain %q", ErrReferenceInvalidFormat, domain)
+	}
+	if path == "" {
+		return ErrNameEmpty
+	}
+	for _, c := range strings.Split(path, "/") {
+		if pathComponentRegex.MatchString(c) {
+			continue
+		}
+		if pathComponentRegex.MatchString(strings.ToLower(c)) {
+			return fmt.Errorf("%w: %q", ErrNameContainsUppercase, c)
+		}
+		return fmt.Errorf("%w: path component %q may only contain lowercase letters, digits and separators", ErrReferenceInvalidFormat, c)
+	}
+
+	return nil
+}
+
+// ValidateTag validates a tag against the docker tag grammar
+func ValidateTag(tag string) error {
+	if !tagRegex.MatchString(tag) {
+		return fmt.Errorf("%w: %q", ErrTagInvalidFormat, tag)
+	}
+	return nil
+}
+
+// splitDomain splits off the first component if it looks like a registry host, like docker does
+func splitDomain(name string) (domain, path string) {
+	i := strings.Index(name, "/")
+	if i == -1 || (!strings.ContainsAny(name[:i], ".:") && name[:i] != "localhost" && strings.ToLower(name[:i]) == name[:i]) {
+		return "", name
+	}
+	return name[:i], name[i+1:]
+}
+
+func normalize(domain, path string) (string, string) {
+	if domain == "" || domain == "index.docker.io" {
+		domain = DefaultDomain
+	}
+	if domain == DefaultDomain && !strings.Contains(path, "/") {
+		path = officialRepositoryPrefix + path
+	}
+	return domain, path
+}
@@ -0,0 +1,101 @@
+package reference
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("ReturnsPathForOfficialImage", func(t *testing.T) {
+
+		// act
+		r, err := Parse("golang")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "", r.Domain)
+		assert.Equal(t, "golang", r.Path)
+		assert.Equal(t, "", r.Tag)
+	})
+
+	t.Run("ReturnsDomainPathTagAndDigest", func(t *testing.T) {
+
+		// act
+		r, err := Parse("eu.gcr.io/travix-com/docker:1.0.0@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "eu.gcr.io", r.Domain)
+		assert.Equal(t, "travix-com/docker", r.Path)
+		assert.Equal(t, "1.0.0", r.Tag)
+		assert.Equal(t, "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", r.Digest)
+		assert.Equal(t, "eu.gcr.io/travix-com/docker", r.Name())
+	})
+
+	t.Run("ReturnsDomainWithPortWithoutTag", func(t *testing.T) {
+
+		// act
+		r, err := Parse("localhost:5000/extensions/docker")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "localhost:5000", r.Domain)
+		assert.Equal(t, "extensions/docker", r.Path)
+		assert.Equal(t, "", r.Tag)
+	})
+
+	t.Run("ReturnsErrorForUppercaseRepository", func(t *testing.T) {
+
+		// act
+		_, err := Parse("extensions/Docker:1.0.0")
+
+		assert.True(t, errors.Is(err, ErrNameContainsUppercase))
+	})
+
+	t.Run("ReturnsErrorForTagStartingWithPeriod", func(t *testing.T) {
+
+		// act
+		_, err := Parse("extensions/docker:.1.0.0")
+
+		assert.True(t, errors.Is(err, ErrTagInvalidFormat))
+	})
+
+	t.Run("ReturnsErrorForInvalidDigest", func(t *testing.T) {
+
+		// act
+		_, err := Parse("extensions/docker@sha256:xyz")
+
+		assert.True(t, errors.Is(err, ErrDigestInvalidFormat))
+	})
+
+	t.Run("ReturnsErrorForEmptyPathComponent", func(t *testing.T) {
+
+		// act
+		_, err := Parse("extensions//docker")
+
+		assert.True(t, errors.Is(err, ErrReferenceInvalidFormat))
+	})
+
+	t.Run("ReturnsErrorForTooLongName", func(t *testing.T) {
+
+		// act
+		_, err := Parse("extensions/" + strings.Repeat("a", 250))
+
+		assert.True(t, errors.Is(err, ErrNameTooLong))
+	})
+}
+
+func TestValidateTag(t *testing.T) {
+	t.Run("ReturnsNilForValidTag", func(t *testing.T) {
+		assert.Nil(t, ValidateTag("1.0.23-beta_B"))
+		assert.Nil(t, ValidateTag("_underscore"))
+	})
+
+	t.Run("ReturnsErrorForInvalidTag", func(t *testing.T) {
+		assert.NotNil(t, ValidateTag(""))
+		assert.NotNil(t, ValidateTag("-dash"))
+		assert.NotNil(t, ValidateTag(".period"))
+		assert.NotNil(t, ValidateTag("release/x"))
+		assert.NotNil(t, ValidateTag(strings.Repeat("a", 129)))
+	})
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/estafette/estafette-extension-docker/reference"
+)
+
+const (
+	dockerHubRegistry = "registry-1.docker.io"
+)
+
+var (
+	manifestMediaTypes = []string{
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+	}
+
+	errManifestUnknown = errors.New("manifest unknown")
+	errUnsupported     = errors.New("unsupported by the registry")
+
+	authenticateParamRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	nextLinkRegex          = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+)
+
+// registryClient talks to the registry http api directly for operations the docker cli doesn't support, like listing tags
+type registryClient struct {
+	credentials []ContainerRegistryCredentials
+	httpClient  *http.Client
+
+	mutex  sync.Mutex
+	tokens map[string]string
+}
+
+func newRegistryClient(credentials []ContainerRegistryCredentials) *registryClient {
+	return &registryClient{
+		credentials: credentials,
+		httpClient: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+		tokens: map[string]string{},
+	}
+}
+
+// splitImageRepository splits a repository like eu.gcr.io/travix-com/docker into its registry api host and path, normalizing docker hub names
+func splitImageRepository(repository string) (registry, path string) {
+
+	registry, path = reference.SplitDomain(repository)
+	if registry == reference.DefaultDomain {
+		registry = dockerHubRegistry
+	}
+
+	return
+}
+
+// listTags returns all tags for repository, following pagination
+func (c *registryClient) listTags(ctx context.Context, repository string) ([]string, error) {
+
+	registry, path := splitImageRepository(repository)
+	requestURL := fmt.Sprintf("https://%v/v2/%v/tags/list?n=1000", registry, path)
+
+	var tags []string
+	for requestURL != "" {
+		response, err := c.do(ctx, http.MethodGet, requestURL, repository, false, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&body)
+		response.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed decoding tags for %v: %w", repository, err)
+		}
+		tags = append(tags, body.Tags...)
+
+		requestURL = ""
+		if matches := nextLinkRegex.FindStringSubmatch(response.Header.Get("Link")); matches != nil {
+			next, err := url.Parse(matches[1])
+			if err == nil {
+				requestURL = response.Request.URL.ResolveReference(next).String()
+			}
+		}
+	}
+
+	return tags, nil
+}
+
+// getManifestDigest returns the digest for a tag or digest reference in repository, or errManifestUnknown if it doesn't exist
+func (c *registryClient) getManifestDigest(ctx context.Context, repository, reference string) (string, error) {
+
+	registry, path := splitImageRepository(repository)
+	requestURL := fmt.Sprintf("https://%v/v2/%v/manifests/%v", registry, path, reference)
+
+	response, err := c.do(ctx, http.MethodHead, requestURL, repository, false, map[string]string{"Accept": strings.Join(manifestMediaTypes, ", ")})
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+
+	digest := response.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry %v returned no digest for %v:%v", registry, path, reference)
+	}
+
+	return digest, nil
+}
+
+// do executes a request against the registry, authenticating with a bearer token or basic auth when the registry asks for it
+func (c *registryClient) do(ctx context.Context, method, requestURL, repository string, push bool, headers map[string]string) (*http.Response, error) {
+
+	registry, path := splitImageRepository(repository)
+	actions := "pull"
+	if push {
+		actions = "pull,push,delete"
+	}
+	tokenKey := fmt.Sprintf("%v/%v:%v", registry, path, actions)
+
+	newRequest := func() (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, method, requestURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range headers {
+			request.Header.Set(k, v)
+		}
+		return request, nil
+	}
+
+	request, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	authorization, hasAuthorization := c.tokens[tokenKey]
+	c.mutex.Unlock()
+	if hasAuthorization {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusUnauthorized {
+		challenge := response.Header.Get("WWW-Authenticate")
+		response.Body.Close()
+
+		authorization, err = c.authorize(ctx, challenge, repository, push, actions)
+		if err != nil {
+			return nil, err
+		}
+		c.mutex.Lock()
+		c.tokens[tokenKey] = authorization
+		c.mutex.Unlock()
+
+		request, err = newRequest()
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", authorization)
+
+		response, err = c.httpClient.Do(request)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, fmt.Errorf("%v %v: %w", method, requestURL, errManifestUnknown)
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		response.Body.Close()
+		if response.StatusCode == http.StatusMethodNotAllowed || strings.Contains(string(body), `"UNSUPPORTED"`) {
+			return nil, fmt.Errorf("%v %v returned status %v: %w", method, requestURL, response.StatusCode, errUnsupported)
+		}
+		return nil, fmt.Errorf("%v %v returned status %v: %v", method, requestURL, response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return response, nil
+}
+
+// authorize returns the authorization header value for the challenge returned by the registry
+func (c *registryClient) authorize(ctx context.Context, challenge, repository string, push bool, actions string) (string, error) {
+
+	var username, password string
+	if credential, ok := getCredentialsForContainers(c.credentials, push, []string{repository})[getCredentialsRepository(repository)]; ok && credential != nil {
+		username, password = credential.AdditionalProperties.Username, credential.AdditionalProperties.Password
+	}
+
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if username == "" {
+			return "", fmt.Errorf("registry requires basic auth for %v, but no credentials are available", repository)
+		}
+		request, _ := http.NewRequest(http.MethodGet, "/", nil)
+		request.SetBasicAuth(username, password)
+		return request.Header.Get("Authorization"), nil
+
+	case "bearer":
+		challengeParams := map[string]string{}
+		for _, m := range authenticateParamRegex.FindAllStringSubmatch(params, -1) {
+			challengeParams[m[1]] = m[2]
+		}
+
+		// the realm can have a query of its own
+		tokenURL, err := url.Parse(challengeParams["realm"])
+		if err != nil {
+			return "", fmt.Errorf("invalid token realm %v for %v: %w", challengeParams["realm"], repository, err)
+		}
+		_, path := splitImageRepository(repository)
+		query := tokenURL.Query()
+		if challengeParams["service"] != "" {
+			query.Set("service", challengeParams["service"])
+		}
+		query.Set("scope", fmt.Sprintf("repository:%v:%v", path, actions))
+		tokenURL.RawQuery = query.Encode()
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if username != "" {
+			request.SetBasicAuth(username, password)
+		}
+
+		response, err := c.httpClient.Do(request)
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("requesting token for %v returned status %v", repository, response.StatusCode)
+		}
+
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&token)
+		if err != nil {
+			return "", err
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+
+		return "Bearer " + token.Token, nil
+	}
+
+	return "", fmt.Errorf("unsupported registry authentication challenge %q for %v", challenge, repository)
+}
+
+// getCredentialsRepository returns the part of an image repository credentials are matched on, e.g. extensions for extensions/docker
+func getCredentialsRepository(repository string) string {
+	slice := strings.Split(repository, "/")
+	return strings.Join(slice[:len(slice)-1], "/")
+}
+
+// imageConfig contains the fields of an image configuration blob used for inspecting images in the registry
+type imageConfig struct {
+	Created      time.Time `json:"created"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+type manifest struct {
+	MediaType string `json:"mediaType"`
+	Config    struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Layers []struct {
+		Digest string `json:"digest"`
+		Size   int64  `json:"size"`
+	} `json:"layers"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// getManifest returns the image manifest for reference; for multi-platform images it returns the linux/amd64 manifest or the first one if that platform is missing
+func (c *registryClient) getManifest(ctx context.Context, repository, reference string) (manifest, error) {
+
+	registry, path := splitImageRepository(repository)
+
+	var m manifest
+	for i := 0; i < 2; i++ {
+		requestURL := fmt.Sprintf("https://%v/v2/%v/manifests/%v", registry, path, reference)
+		response, err := c.do(ctx, http.MethodGet, requestURL, repository, false, map[string]string{"Accept": strings.Join(manifestMediaTypes, ", ")})
+		if err != nil {
+			return m, err
+		}
+		m = manifest{}
+		err = json.NewDecoder(response.Body).Decode(&m)
+		response.Body.Close()
+		if err != nil {
+			return m, fmt.Errorf("failed decoding manifest for %v:%v: %w", repository, reference, err)
+		}
+
+		if len(m.Manifests) == 0 {
+			return m, nil
+		}
+
+		reference = m.Manifests[0].Digest
+		for _, pm := range m.Manifests {
+			if pm.Platform.OS == "linux" && pm.Platform.Architecture == "amd64" {
+				reference = pm.Digest
+				break
+			}
+		}
+	}
+
+	return m, fmt.Errorf("manifest for %v:%v is nested too deep", repository, reference)
+}
+
+// getImageConfig returns the image configuration for reference, which holds the creation time and labels
+func (c *registryClient) getImageConfig(ctx context.Context, repository, reference string) (imageConfig, error) {
+
+	var config imageConfig
+
+	m, err := c.getManifest(ctx, repository, reference)
+	if err != nil {
+		return config, err
+	}
+	if m.Config.Digest == "" {
+		return config, fmt.Errorf("manifest for %v:%v has no config", repository, reference)
+	}
+
+	registry, path := splitImageRepository(repository)
+	requestURL := fmt.Sprintf("https://%v/v2/%v/blobs/%v", registry, path, m.Config.Digest)
+	response, err := c.do(ctx, http.MethodGet, requestURL, repository, false, nil)
+	if err != nil {
+		return config, err
+	}
+	defer response.Body.Close()
+
+	err = json.NewDecoder(response.Body).Decode(&config)
+	if err != nil {
+		return config, fmt.Errorf("failed decoding image config for %v:%v: %w", repository, reference, err)
+	}
+
+	return config, nil
+}
+
+// deleteManifest deletes a tag or digest reference from repository; not every registry supports deleting by tag, those return errUnsupported
+func (c *registryClient) deleteManifest(ctx context.Context, repository, reference string) error {
+
+	registry, path := splitImageRepository(repository)
+	requestURL := fmt.Sprintf("https://%v/v2/%v/manifests/%v", registry, path, reference)
+
+	response, err := c.do(ctx, http.MethodDelete, requestURL, repository, true, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitImageRepository(t *testing.T) {
+	t.Run("ReturnsDockerHubLibraryPathForOfficialImage", func(t *testing.T) {
+
+		// act
+		registry, path := splitImageRepository("golang")
+
+		assert.Equal(t, "registry-1.docker.io", registry)
+		assert.Equal(t, "library/golang", path)
+	})
+
+	t.Run("ReturnsDockerHubForImageWithoutRegistry", func(t *testing.T) {
+
+		// act
+		registry, path := splitImageRepository("extensions/docker")
+
+		assert.Equal(t, "registry-1.docker.io", registry)
+		assert.Equal(t, "extensions/docker", path)
+	})
+
+	t.Run("ReturnsRegistryForImageWithRegistry", func(t *testing.T) {
+
+		// act
+		registry, path := splitImageRepository("eu.gcr.io/travix-com/docker")
+
+		assert.Equal(t, "eu.gcr.io", registry)
+		assert.Equal(t, "travix-com/docker", path)
+	})
+
+	t.Run("ReturnsRegistryWithPort", func(t *testing.T) {
+
+		// act
+		registry, path := splitImageRepository("localhost:5000/docker")
+
+		assert.Equal(t, "localhost:5000", registry)
+		assert.Equal(t, "docker", path)
+	})
+}
+
+func TestRegistryClient(t *testing.T) {
+
+	newTestRegistry := func(t *testing.T) (*httptest.Server, *registryClient, string) {
+		var server *httptest.Server
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/token":
+				username, password, _ := r.BasicAuth()
+				if username != "user" || password != "password" || r.URL.Query().Get("scope") != "repository:extensions/docker:pull" || r.URL.Query().Get("tenant") != "test" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				fmt.Fprint(w, `{"token":"secret-token"}`)
+			case r.Header.Get("Authorization") != "Bearer secret-token":
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%v/token?tenant=test",service="test"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+			case r.URL.Path == "/v2/extensions/docker/tags/list" && r.URL.Query().Get("last") == "":
+				w.Header().Set("Link", `</v2/extensions/docker/tags/list?n=1000&last=1.0.1>; rel="next"`)
+				fmt.Fprint(w, `{"name":"extensions/docker","tags":["1.0.0","1.0.1"]}`)
+			case r.URL.Path == "/v2/extensions/docker/tags/list":
+				fmt.Fprint(w, `{"name":"extensions/docker","tags":["stable"]}`)
+			case r.URL.Path == "/v2/extensions/docker/manifests/stable":
+				w.Header().Set("Docker-Content-Digest", "sha256:abc")
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		host := strings.TrimPrefix(server.URL, "https://")
+		client := newRegistryClient([]ContainerRegistryCredentials{
+			{
+				Name: "container-registry-test",
+				Type: "container-registry",
+				AdditionalProperties: ContainerRegistryCredentialsAdditionalProperties{
+					Repository: host + "/extensions",
+					Username:   "user",
+					Password:   "password",
+				},
+			},
+		})
+		client.httpClient = server.Client()
+
+		return server, client, host + "/extensions/docker"
+	}
+
+	t.Run("ListTagsReturnsTagsFromAllPages", func(t *testing.T) {
+
+		server, client, repository := newTestRegistry(t)
+		defer server.Close()
+
+		// act
+		tags, err := client.listTags(context.Background(), repository)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1.0.0", "1.0.1", "stable"}, tags)
+	})
+
+	t.Run("GetManifestDigestReturnsDigest", func(t *testing.T) {
+
+		server, client, repository := newTestRegistry(t)
+		defer server.Close()
+
+		// act
+		digest, err := client.getManifestDigest(context.Background(), repository, "stable")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "sha256:abc", digest)
+	})
+
+	t.Run("GetManifestDigestReturnsManifestUnknownForMissingTag", func(t *testing.T) {
+
+		server, client, repository := newTestRegistry(t)
+		defer server.Close()
+
+		// act
+		_, err := client.getManifestDigest(context.Background(), repository, "missing")
+
+		assert.True(t, errors.Is(err, errManifestUnknown))
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+// getSourceDateEpoch returns SOURCE_DATE_EPOCH if set, otherwise the commit time of the checked out git revision
+func getSourceDateEpoch(ctx context.Context) (int64, error) {
+
+	if value := os.Getenv("SOURCE_DATE_EPOCH"); value != "" {
+		epoch, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("SOURCE_DATE_EPOCH %v is not a unix timestamp: %w", value, err)
+		}
+		return epoch, nil
+	}
+
+	output, err := foundation.GetCommandWithArgsOutput(ctx, "git", []string{"log", "-1", "--format=%ct"})
+	if err != nil {
+		return 0, fmt.Errorf("failed retrieving git commit time for SOURCE_DATE_EPOCH: %w", err)
+	}
+	epoch, err := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("git commit time %v is not a unix timestamp: %w", strings.TrimSpace(output), err)
+	}
+
+	return epoch, nil
+}
+
+// getReproducibleBuildArgs returns the arguments for BuildKit to use the epoch for the image creation time and, for the final image only, to clamp file timestamps in the layers to it; intermediate stages get the epoch as well so their cache matches the stages of the final build
+func getReproducibleBuildArgs(epoch int64, isFinalLayer bool) []string {
+	args := []string{"--build-arg", fmt.Sprintf("SOURCE_DATE_EPOCH=%v", epoch)}
+	if isFinalLayer {
+		args = append(args, "--output", "type=docker,rewrite-timestamp=true")
+	}
+	return args
+}
+
+// normalizeFiles sets the modification time of path and everything below it to modTime and ownership to root, so the files copied into the build context are identical between builds
+func normalizeFiles(path string, modTime time.Time) error {
+
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		err = os.Lchown(p, 0, 0)
+		if err != nil && !errors.Is(err, fs.ErrPermission) {
+			return err
+		}
+
+		// changing times on a symlink would change its target instead
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		return os.Chtimes(p, modTime, modTime)
+	})
+}
+
+// getVerifyBuildArgs returns the build arguments to rebuild the image without cache under verifyTag only
+func getVerifyBuildArgs(args []string, verifyTag string) []string {
+
+	verifyArgs := []string{"build", "--no-cache", "--tag", verifyTag}
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "--tag", "--cache-from":
+			i++
+		case "--no-cache":
+		default:
+			verifyArgs = append(verifyArgs, args[i])
+		}
+	}
+
+	return verifyArgs
+}
+
+// verifyReproducibleBuild rebuilds the image without cache and fails if the image id differs from the one of containerPath
+func verifyReproducibleBuild(ctx context.Context, buildArgs []string, containerPath string) error {
+
+	verifyTag := containerPath + "-verify"
+	verifyArgs := getVerifyBuildArgs(buildArgs, verifyTag)
+
+	log.Info().Msgf("Rebuilding container image %v without cache to verify the build is reproducible...", containerPath)
+	err := runCommandExtended(ctx, "docker build to verify reproducibility", "docker", verifyArgs)
+	if err != nil {
+		return fmt.Errorf("rebuilding %v to verify reproducibility failed: %w", containerPath, err)
+	}
+	if *dryRun {
+		plan.addStep(fmt.Sprintf("compare image ids of %v and %v", containerPath, verifyTag), "", nil)
+		return nil
+	}
+	defer func() {
+		_, _ = foundation.GetCommandWithArgsOutput(context.Background(), "docker", []string{"rmi", verifyTag})
+	}()
+
+	imageID, err := foundation.GetCommandWithArgsOutput(ctx, "docker", []string{"image", "inspect", "--format", "{{.Id}}", containerPath})
+	if err != nil {
+		return err
+	}
+	verifyImageID, err := foundation.GetCommandWithArgsOutput(ctx, "docker", []string{"image", "inspect", "--format", "{{.Id}}", verifyTag})
+	if err != nil {
+		return err
+	}
+
+	imageID, verifyImageID = strings.TrimSpace(imageID), strings.TrimSpace(verifyImageID)
+	if imageID != verifyImageID {
+		return fmt.Errorf("The build isn't reproducible: rebuilding %v resulted in image %v instead of %v", containerPath, verifyImageID, imageID)
+	}
+
+	log.Info().Msgf("Build of %v is reproducible, rebuilding resulted in the same image %v", containerPath, imageID)
+
+	return nil
+}
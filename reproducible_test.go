@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSourceDateEpoch(t *testing.T) {
+	t.Run("ReturnsSourceDateEpochEnvvarIfSet", func(t *testing.T) {
+
+		os.Setenv("SOURCE_DATE_EPOCH", "1709288100")
+		defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+		// act
+		epoch, err := getSourceDateEpoch(context.Background())
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1709288100), epoch)
+	})
+
+	t.Run("ReturnsErrorIfSourceDateEpochEnvvarIsNotATimestamp", func(t *testing.T) {
+
+		os.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+		defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+		// act
+		_, err := getSourceDateEpoch(context.Background())
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetReproducibleBuildArgs(t *testing.T) {
+	t.Run("ReturnsSourceDateEpochBuildArgAndTimestampRewritingOutputForFinalImage", func(t *testing.T) {
+
+		// act
+		args := getReproducibleBuildArgs(1709288100, true)
+
+		assert.Equal(t, []string{"--build-arg", "SOURCE_DATE_EPOCH=1709288100", "--output", "type=docker,rewrite-timestamp=true"}, args)
+	})
+
+	t.Run("ReturnsOnlySourceDateEpochBuildArgForIntermediateStage", func(t *testing.T) {
+
+		// act
+		args := getReproducibleBuildArgs(1709288100, false)
+
+		assert.Equal(t, []string{"--build-arg", "SOURCE_DATE_EPOCH=1709288100"}, args)
+	})
+}
+
+func TestNormalizeFiles(t *testing.T) {
+	t.Run("SetsModificationTimeOfAllFilesAndDirectories", func(t *testing.T) {
+
+		dir := filepath.Join(t.TempDir(), "publish")
+		assert.Nil(t, os.MkdirAll(filepath.Join(dir, "static"), 0755))
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, "app"), []byte("app"), 0755))
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, "static", "index.html"), []byte("<html/>"), 0644))
+		modTime := time.Unix(1709288100, 0)
+
+		// act
+		err := normalizeFiles(dir, modTime)
+
+		assert.Nil(t, err)
+		for _, p := range []string{dir, filepath.Join(dir, "app"), filepath.Join(dir, "static"), filepath.Join(dir, "static", "index.html")} {
+			fi, err := os.Stat(p)
+			assert.Nil(t, err)
+			assert.True(t, modTime.Equal(fi.ModTime()), p)
+		}
+	})
+
+	t.Run("ReturnsErrorIfPathDoesNotExist", func(t *testing.T) {
+
+		// act
+		err := normalizeFiles(filepath.Join(t.TempDir(), "missing"), time.Unix(1709288100, 0))
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetVerifyBuildArgs(t *testing.T) {
+	t.Run("ReplacesTagsAndCacheWithVerifyTagAndNoCache", func(t *testing.T) {
+
+		args := []string{
+			"build",
+			"--build-arg", "BUILDKIT_INLINE_CACHE=1",
+			"--cache-from", "extensions/docker:dlc",
+			"--tag", "extensions/docker:dlc",
+			"--tag", "extensions/docker:1.0.0",
+			"--build-arg", "SOURCE_DATE_EPOCH=1709288100",
+			"--output", "type=docker,rewrite-timestamp=true",
+			"--file", "Dockerfile",
+			".",
+		}
+
+		// act
+		verifyArgs := getVerifyBuildArgs(args, "extensions/docker:1.0.0-verify")
+
+		assert.Equal(t, []string{
+			"build",
+			"--no-cache",
+			"--tag", "extensions/docker:1.0.0-verify",
+			"--build-arg", "BUILDKIT_INLINE_CACHE=1",
+			"--build-arg", "SOURCE_DATE_EPOCH=1709288100",
+			"--output", "type=docker,rewrite-timestamp=true",
+			"--file", "Dockerfile",
+			".",
+		}, verifyArgs)
+	})
+}
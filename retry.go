@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// output of failed registry operations that indicates the failure is permanent and retrying is pointless
+	fatalCommandOutputRegex = regexp.MustCompile(`(?i)(unauthorized|denied|forbidden|manifest unknown|not found|invalid reference format|no basic auth credentials|authentication required)`)
+
+	// output of failed registry operations that indicates rate limiting, which is reported as denied by some registries
+	rateLimitedCommandOutputRegex = regexp.MustCompile(`(?i)(toomanyrequests|too many requests|\b429\b)`)
+
+	// output of failed registry operations that indicates the failure is transient and worth retrying
+	retryableCommandOutputRegex = regexp.MustCompile(`(?i)(\b500 internal server error|\b502\b|bad gateway|\b503\b|service unavailable|\b504\b|gateway time-?out|tls handshake timeout|i/o timeout|connection reset by peer|connection refused|broken pipe|unexpected eof|net/http: request canceled|temporary failure|server misbehaving|received unexpected http status: 5\d\d)`)
+)
+
+// commandError wraps the error of a failed command together with its output and whether it's worth retrying
+type commandError struct {
+	err       error
+	output    string
+	retryable bool
+}
+
+func (e *commandError) Error() string {
+	return e.err.Error()
+}
+
+// isRetryableCommandOutput classifies the output of a failed registry operation as transient (true) or fatal (false)
+func isRetryableCommandOutput(output string) bool {
+	if rateLimitedCommandOutputRegex.MatchString(output) {
+		return true
+	}
+	if fatalCommandOutputRegex.MatchString(output) {
+		return false
+	}
+	return retryableCommandOutputRegex.MatchString(output)
+}
+
+type retrySummary struct {
+	mutex   sync.Mutex
+	retries map[string]int
+}
+
+var (
+	retries = retrySummary{retries: map[string]int{}}
+)
+
+func (s *retrySummary) add(operation string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.retries[operation]++
+}
+
+// logRetrySummary logs how many retries were needed for each type of registry operation
+func logRetrySummary() {
+	retries.mutex.Lock()
+	defer retries.mutex.Unlock()
+
+	if len(retries.retries) == 0 {
+		return
+	}
+
+	operations := make([]string, 0, len(retries.retries))
+	for o := range retries.retries {
+		operations = append(operations, o)
+	}
+	sort.Strings(operations)
+
+	total := 0
+	summary := make([]string, 0, len(operations))
+	for _, o := range operations {
+		total += retries.retries[o]
+		summary = append(summary, fmt.Sprintf("%v: %v", o, retries.retries[o]))
+	}
+
+	log.Info().Msgf("Retried registry operations %v times (%v)", total, strings.Join(summary, ", "))
+}
+
+// retrySummaryHook logs the retry summary before a fatal log exits the process, since deferred calls don't run then
+type retrySummaryHook struct{}
+
+func (h retrySummaryHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if level == zerolog.FatalLevel {
+		logRetrySummary()
+	}
+}
+
+// runDockerCommandWithRetry runs a docker registry operation and retries it on transient failures; it logs a fatal on error
+func runDockerCommandWithRetry(ctx context.Context, operation string, args []string) {
+	err := runDockerCommandWithRetryExtended(ctx, operation, args, "")
+	foundation.HandleError(err)
+}
+
+// runDockerCommandWithRetryExtended runs a docker registry operation and retries it on transient failures; it returns the last error if all attempts fail
+func runDockerCommandWithRetryExtended(ctx context.Context, operation string, args []string, stdin string) error {
+
+	if *dryRun {
+		plan.addStep(fmt.Sprintf("docker %v", operation), "docker", args)
+		return nil
+	}
+
+	attempts := *retryAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delayConfig := &foundation.RetryConfig{DelayMillisecond: *retryDelay, DelayType: foundation.ExponentialBackOffDelay}
+	// jitter is +-25%, which can't be applied to very small delays
+	if *retryJitter && *retryDelay >= 4 {
+		delayConfig.DelayType = foundation.ExponentialJitterBackoffDelay
+	}
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			retries.add(operation)
+		}
+
+		output, err := runCommandWithOutput(ctx, "docker", args, stdin)
+		if err == nil {
+			return nil
+		}
+
+		// don't retry when the command got cancelled by sigterm
+		if ctx.Err() != nil {
+			return &commandError{err: ctx.Err(), output: output}
+		}
+
+		cmdErr := &commandError{err: fmt.Errorf("docker %v failed: %w", operation, err), output: output, retryable: isRetryableCommandOutput(output)}
+		if !cmdErr.retryable || attempt >= attempts {
+			return cmdErr
+		}
+		log.Warn().Err(err).Msgf("Attempt %v/%v for docker %v failed with a transient error, retrying...", attempt, attempts, operation)
+
+		err = sleepWithContext(ctx, delayConfig.DelayType(uint(attempt-1), delayConfig))
+		if err != nil {
+			return &commandError{err: err, output: output}
+		}
+	}
+}
+
+// sleepWithContext waits for the duration, but returns the context error as soon as it gets cancelled by sigterm
+func sleepWithContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// runCommandWithOutput runs a command while streaming its output to stdout and stderr and returns the combined output for inspection; it's a variable so tests can replace it
+var runCommandWithOutput = func(ctx context.Context, command string, args []string, stdin string) (string, error) {
+
+	var output bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = os.Environ()
+	cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &output)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+
+	err := cmd.Run()
+
+	return output.String(), err
+}
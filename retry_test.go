@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryableCommandOutput(t *testing.T) {
+	t.Run("ReturnsTrueForBadGateway", func(t *testing.T) {
+
+		output := "error parsing HTTP 502 response body: invalid character '<' looking for beginning of value: \"<html><head><title>502 Bad Gateway</title></head></html>\""
+
+		// act
+		retryable := isRetryableCommandOutput(output)
+
+		assert.True(t, retryable)
+	})
+
+	t.Run("ReturnsTrueForDockerHubRateLimit", func(t *testing.T) {
+
+		output := "Error response from daemon: toomanyrequests: You have reached your pull rate limit. You may increase the limit by authenticating and upgrading: https://www.docker.com/increase-rate-limit"
+
+		// act
+		retryable := isRetryableCommandOutput(output)
+
+		assert.True(t, retryable)
+	})
+
+	t.Run("ReturnsTrueForTLSHandshakeTimeout", func(t *testing.T) {
+
+		output := "Error response from daemon: Get \"https://registry-1.docker.io/v2/\": net/http: TLS handshake timeout"
+
+		// act
+		retryable := isRetryableCommandOutput(output)
+
+		assert.True(t, retryable)
+	})
+
+	t.Run("ReturnsFalseForDeniedPush", func(t *testing.T) {
+
+		output := "denied: requested access to the resource is denied"
+
+		// act
+		retryable := isRetryableCommandOutput(output)
+
+		assert.False(t, retryable)
+	})
+
+	t.Run("ReturnsFalseForManifestUnknown", func(t *testing.T) {
+
+		output := "Error response from daemon: manifest for extensions/docker:0.0.0 not found: manifest unknown: manifest unknown"
+
+		// act
+		retryable := isRetryableCommandOutput(output)
+
+		assert.False(t, retryable)
+	})
+
+	t.Run("ReturnsFalseForUnknownError", func(t *testing.T) {
+
+		output := "invalid argument \"Extensions/Docker\" for \"-t, --tag\" flag"
+
+		// act
+		retryable := isRetryableCommandOutput(output)
+
+		assert.False(t, retryable)
+	})
+}
+
+func TestRunDockerCommandWithRetryExtended(t *testing.T) {
+
+	// runWithOutputs runs a push against a command returning the outputs in order, failing for every non-empty one; it returns the number of attempts and the recorded retries
+	runWithOutputs := func(outputs ...string) (int, map[string]int, error) {
+		originalRunner, originalRetries := runCommandWithOutput, retries.retries
+		originalDryRun, originalAttempts, originalDelay, originalJitter := *dryRun, *retryAttempts, *retryDelay, *retryJitter
+		defer func() {
+			runCommandWithOutput, retries.retries = originalRunner, originalRetries
+			*dryRun, *retryAttempts, *retryDelay, *retryJitter = originalDryRun, originalAttempts, originalDelay, originalJitter
+		}()
+		*dryRun = false
+		*retryAttempts = 3
+		*retryDelay = 0
+		*retryJitter = false
+		retries.retries = map[string]int{}
+
+		attempts := 0
+		runCommandWithOutput = func(ctx context.Context, command string, args []string, stdin string) (string, error) {
+			output := outputs[attempts]
+			attempts++
+			if output == "" {
+				return "", nil
+			}
+			return output, errors.New("exit status 1")
+		}
+
+		err := runDockerCommandWithRetryExtended(context.Background(), "push", []string{"push", "extensions/docker:1.0.0"}, "")
+
+		return attempts, retries.retries, err
+	}
+
+	t.Run("RetriesOnTransientOutput", func(t *testing.T) {
+
+		// act
+		attempts, _, err := runWithOutputs("received unexpected HTTP status: 502 Bad Gateway", "")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("DoesNotRetryOnFatalOutput", func(t *testing.T) {
+
+		// act
+		attempts, _, err := runWithOutputs("denied: requested access to the resource is denied", "")
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("StopsAfterRetryAttempts", func(t *testing.T) {
+
+		// act
+		attempts, _, err := runWithOutputs("503 Service Unavailable", "503 Service Unavailable", "503 Service Unavailable", "")
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("RecordsRetriesForSummary", func(t *testing.T) {
+
+		// act
+		_, recordedRetries, _ := runWithOutputs("i/o timeout", "i/o timeout", "")
+
+		assert.Equal(t, map[string]int{"push": 2}, recordedRetries)
+	})
+}
+
+func TestSleepWithContext(t *testing.T) {
+	t.Run("ReturnsContextErrorWithoutWaitingWhenCancelled", func(t *testing.T) {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		start := time.Now()
+
+		// act
+		err := sleepWithContext(ctx, time.Minute)
+
+		assert.Equal(t, context.Canceled, err)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("ReturnsNilAfterDuration", func(t *testing.T) {
+
+		// act
+		err := sleepWithContext(context.Background(), time.Millisecond)
+
+		assert.Nil(t, err)
+	})
+}
+
+func TestRetrySummaryHook(t *testing.T) {
+	t.Run("LogsRetrySummaryOnFatalLevel", func(t *testing.T) {
+
+		var output bytes.Buffer
+		originalLogger, originalRetries := log.Logger, retries.retries
+		defer func() { log.Logger, retries.retries = originalLogger, originalRetries }()
+		log.Logger = zerolog.New(&output)
+		retries.retries = map[string]int{"push": 2}
+
+		// act
+		retrySummaryHook{}.Run(nil, zerolog.FatalLevel, "Fatal error")
+
+		assert.Contains(t, output.String(), "Retried registry operations 2 times (push: 2)")
+	})
+
+	t.Run("DoesNotLogRetrySummaryOnOtherLevels", func(t *testing.T) {
+
+		var output bytes.Buffer
+		originalLogger, originalRetries := log.Logger, retries.retries
+		defer func() { log.Logger, retries.retries = originalLogger, originalRetries }()
+		log.Logger = zerolog.New(&output)
+		retries.retries = map[string]int{"push": 2}
+
+		// act
+		retrySummaryHook{}.Run(nil, zerolog.ErrorLevel, "push failed")
+
+		assert.Equal(t, "", output.String())
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	semanticVersionRegex = regexp.MustCompile(`^v?(0|[1-9][0-9]*)(?:\.(0|[1-9][0-9]*))?(?:\.(0|[1-9][0-9]*))?(?:-([0-9A-Za-z\-\.]+))?(?:\+([0-9A-Za-z\-\.]+))?$`)
+)
+
+// semanticVersion is a parsed version like 1.4.7-beta+build; minor and patch are optional so tags like 1.21 can be compared as well
+type semanticVersion struct {
+	major      int
+	minor      int
+	patch      int
+	preRelease string
+	build      string
+	// parts is the number of numeric parts present in the original string
+	parts int
+}
+
+func parseSemanticVersion(version string) (semanticVersion, error) {
+
+	matches := semanticVersionRegex.FindStringSubmatch(version)
+	if matches == nil {
+		return semanticVersion{}, fmt.Errorf("%v is not a semantic version", version)
+	}
+
+	v := semanticVersion{
+		preRelease: matches[4],
+		build:      matches[5],
+		parts:      1,
+	}
+	v.major, _ = strconv.Atoi(matches[1])
+	if matches[2] != "" {
+		v.minor, _ = strconv.Atoi(matches[2])
+		v.parts++
+	}
+	if matches[3] != "" {
+		v.patch, _ = strconv.Atoi(matches[3])
+		v.parts++
+	}
+
+	return v, nil
+}
+
+func (v semanticVersion) String() string {
+	version := fmt.Sprintf("%v.%v.%v", v.major, v.minor, v.patch)
+	if v.preRelease != "" {
+		version += "-" + v.preRelease
+	}
+	if v.build != "" {
+		version += "+" + v.build
+	}
+	return version
+}
+
+// isPreRelease returns true for versions with a pre-release label like 1.4.7-beta
+func (v semanticVersion) isPreRelease() bool {
+	return v.preRelease != ""
+}
+
+// compare returns -1, 0 or 1 when v is lower than, equal to or higher than other, following semver precedence rules
+func (v semanticVersion) compare(other semanticVersion) int {
+
+	for _, d := range []int{v.major - other.major, v.minor - other.minor, v.patch - other.patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+
+	// a version without pre-release has higher precedence than one with
+	switch {
+	case v.preRelease == other.preRelease:
+		return 0
+	case v.preRelease == "":
+		return 1
+	case other.preRelease == "":
+		return -1
+	}
+
+	identifiers := strings.Split(v.preRelease, ".")
+	otherIdentifiers := strings.Split(other.preRelease, ".")
+	for i := 0; i < len(identifiers) && i < len(otherIdentifiers); i++ {
+		a, aErr := strconv.Atoi(identifiers[i])
+		b, bErr := strconv.Atoi(otherIdentifiers[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if a != b {
+				if a < b {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			// numeric identifiers have lower precedence than alphanumeric ones
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(identifiers[i], otherIdentifiers[i]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	switch {
+	case len(identifiers) < len(otherIdentifiers):
+		return -1
+	case len(identifiers) > len(otherIdentifiers):
+		return 1
+	}
+
+	return 0
+}
+
+// semverComparator is a single condition like >=1.2.0
+type semverComparator struct {
+	operator string
+	version  semanticVersion
+}
+
+func (c semverComparator) matches(v semanticVersion) bool {
+	r := v.compare(c.version)
+	switch c.operator {
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	case "<":
+		return r < 0
+	case "<=":
+		return r <= 0
+	case "!=":
+		return r != 0
+	}
+	return r == 0
+}
+
+// semverRange is a set of alternatives separated by || where each alternative requires all its comparators to match
+type semverRange [][]semverComparator
+
+var (
+	semverComparatorRegex = regexp.MustCompile(`^(>=|<=|>|<|=|!=|\^|~)?\s*(.+)$`)
+	semverOperatorRegex   = regexp.MustCompile(`^(>=|<=|>|<|=|!=|\^|~)$`)
+)
+
+// parseSemverRange parses ranges like ">=1.2.0 <2.0.0", "^1.4", "~1.4.2", "1.x" or "1.2 || 1.4"
+func parseSemverRange(value string) (semverRange, error) {
+
+	var r semverRange
+	for _, alternative := range strings.Split(value, "||") {
+
+		// glue an operator separated by a space, like in ">= 1.2.0", to its version
+		var conditions []string
+		for _, f := range strings.FieldsFunc(alternative, func(r rune) bool { return r == ' ' || r == ',' }) {
+			if len(conditions) > 0 && semverOperatorRegex.MatchString(conditions[len(conditions)-1]) {
+				conditions[len(conditions)-1] += f
+				continue
+			}
+			conditions = append(conditions, f)
+		}
+
+		var comparators []semverComparator
+		for _, c := range conditions {
+
+			matches := semverComparatorRegex.FindStringSubmatch(c)
+			if matches == nil {
+				return nil, fmt.Errorf("invalid semver range condition %v", c)
+			}
+			operator, version := matches[1], strings.TrimSuffix(strings.TrimSuffix(matches[2], ".x"), ".*")
+			if version == "x" || version == "*" {
+				continue
+			}
+
+			v, err := parseSemanticVersion(version)
+			if err != nil {
+				return nil, fmt.Errorf("invalid semver range condition %v: %w", c, err)
+			}
+
+			switch {
+			case operator == "^":
+				upper := semanticVersion{major: v.major + 1}
+				if v.major == 0 && v.minor == 0 && v.parts == 3 {
+					upper = semanticVersion{patch: v.patch + 1}
+				} else if v.major == 0 && v.parts > 1 {
+					upper = semanticVersion{minor: v.minor + 1}
+				}
+				comparators = append(comparators, semverComparator{">=", v}, semverComparator{"<", upper})
+			case operator == "~" || (operator == "" && v.parts < 3) || (operator == "=" && v.parts < 3):
+				// partial versions and tilde ranges match everything within the specified parts
+				upper := semanticVersion{major: v.major + 1}
+				if v.parts > 1 {
+					upper = semanticVersion{major: v.major, minor: v.minor + 1}
+				}
+				comparators = append(comparators, semverComparator{">=", v}, semverComparator{"<", upper})
+			case operator == "":
+				comparators = append(comparators, semverComparator{"=", v})
+			default:
+				comparators = append(comparators, semverComparator{operator, v})
+			}
+		}
+		r = append(r, comparators)
+	}
+
+	return r, nil
+}
+
+// matches returns true if any alternative matches; pre-release versions only match alternatives that mention a pre-release themselves
+func (r semverRange) matches(v semanticVersion) bool {
+	for _, comparators := range r {
+
+		allowsPreRelease := false
+		for _, c := range comparators {
+			if c.version.isPreRelease() {
+				allowsPreRelease = true
+			}
+		}
+		if v.isPreRelease() && !allowsPreRelease {
+			continue
+		}
+
+		matchesAll := true
+		for _, c := range comparators {
+			if !c.matches(v) {
+				matchesAll = false
+				break
+			}
+		}
+		if matchesAll {
+			return true
+		}
+	}
+	return false
+}
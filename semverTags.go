@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+// formatVersionTag turns a version into a tag, adding the version tag prefix and suffix if set
+func formatVersionTag(version, versionTagPrefix, versionTagSuffix string) string {
+
+	versionTag := tidyTag(version)
+	if versionTagPrefix != "" {
+		versionTag = tidyTag(versionTagPrefix + "-" + versionTag)
+	}
+	if versionTagSuffix != "" {
+		versionTag = tidyTag(versionTag + "-" + versionTagSuffix)
+	}
+
+	return versionTag
+}
+
+// getSemverFloatingTags returns the major and major.minor tags for a version like 1.4.7; pre-release versions don't move floating tags, so they get none
+func getSemverFloatingTags(version, versionTagPrefix, versionTagSuffix string) ([]string, error) {
+
+	v, err := parseSemanticVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if v.isPreRelease() {
+		return nil, nil
+	}
+
+	return []string{
+		formatVersionTag(fmt.Sprintf("%v", v.major), versionTagPrefix, versionTagSuffix),
+		formatVersionTag(fmt.Sprintf("%v.%v", v.major, v.minor), versionTagPrefix, versionTagSuffix),
+	}, nil
+}
+
+// isFloatingTagFor returns true if floating tag belongs to the same major or major.minor line as version
+func isFloatingTagFor(floatingTag string, version semanticVersion, versionTagPrefix, versionTagSuffix string) (major bool, ok bool) {
+	switch floatingTag {
+	case formatVersionTag(fmt.Sprintf("%v", version.major), versionTagPrefix, versionTagSuffix):
+		return true, true
+	case formatVersionTag(fmt.Sprintf("%v.%v", version.major, version.minor), versionTagPrefix, versionTagSuffix):
+		return false, true
+	}
+	return false, false
+}
+
+// getHigherVersionTags returns the version tags in the same line as the floating tag that are higher than version, lowest first
+func getHigherVersionTags(tags []string, floatingTag string, version semanticVersion, versionTagPrefix, versionTagSuffix string) []string {
+
+	major, ok := isFloatingTagFor(floatingTag, version, versionTagPrefix, versionTagSuffix)
+	if !ok {
+		return nil
+	}
+
+	type versionTag struct {
+		tag     string
+		version semanticVersion
+	}
+
+	var higherVersionTags []versionTag
+	for _, t := range tags {
+		v, ok := getVersionFromTag(t, versionTagPrefix, versionTagSuffix, version.preRelease)
+		if !ok || v.isPreRelease() || v.major != version.major || (!major && v.minor != version.minor) {
+			continue
+		}
+		if v.compare(version) > 0 {
+			higherVersionTags = append(higherVersionTags, versionTag{tag: t, version: v})
+		}
+	}
+
+	sort.SliceStable(higherVersionTags, func(i, j int) bool {
+		return higherVersionTags[i].version.compare(higherVersionTags[j].version) < 0
+	})
+
+	result := make([]string, 0, len(higherVersionTags))
+	for _, vt := range higherVersionTags {
+		result = append(result, vt.tag)
+	}
+
+	return result
+}
+
+// getBackwardFloatingTags returns the repository:tag combinations where a floating tag currently points at a higher version than version, so they shouldn't be moved
+func getBackwardFloatingTags(ctx context.Context, client *registryClient, repositories []string, container string, floatingTags []string, version, versionTagPrefix, versionTagSuffix string) (map[string]bool, error) {
+
+	backwardFloatingTags := map[string]bool{}
+
+	v, err := parseSemanticVersion(version)
+	if err != nil {
+		return backwardFloatingTags, err
+	}
+
+	for _, r := range repositories {
+		repository := fmt.Sprintf("%v/%v", r, container)
+
+		var tags []string
+		for _, ft := range floatingTags {
+
+			floatingDigest, err := client.getManifestDigest(ctx, repository, ft)
+			if errors.Is(err, errManifestUnknown) {
+				continue
+			}
+			if err != nil {
+				return backwardFloatingTags, fmt.Errorf("failed retrieving digest for %v:%v: %w", repository, ft, err)
+			}
+
+			if tags == nil {
+				tags, err = client.listTags(ctx, repository)
+				if err != nil {
+					return backwardFloatingTags, fmt.Errorf("failed listing tags for %v: %w", repository, err)
+				}
+			}
+
+			for _, ht := range getHigherVersionTags(tags, ft, v, versionTagPrefix, versionTagSuffix) {
+				digest, err := client.getManifestDigest(ctx, repository, ht)
+				if err != nil {
+					return backwardFloatingTags, fmt.Errorf("failed retrieving digest for %v:%v: %w", repository, ht, err)
+				}
+				if digest == floatingDigest {
+					log.Warn().Msgf("Not moving %v:%v backwards, it points at higher version %v", repository, ft, ht)
+					backwardFloatingTags[fmt.Sprintf("%v:%v", repository, ft)] = true
+					break
+				}
+			}
+		}
+	}
+
+	return backwardFloatingTags, nil
+}
+
+// getImageBackwardFloatingTags returns the floating semver tags of the image that shouldn't be moved per repository
+func getImageBackwardFloatingTags(ctx context.Context, credentials []ContainerRegistryCredentials, image buildImage, version, versionTagSuffix string) (map[string]bool, error) {
+
+	if len(image.semverFloatingTags) == 0 {
+		return map[string]bool{}, nil
+	}
+
+	backwardFloatingTags, err := getBackwardFloatingTags(ctx, newRegistryClient(credentials), image.Repositories, image.Container, image.semverFloatingTags, version, *versionTagPrefix, versionTagSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("Failed verifying floating semver tags don't move backwards: %w", err)
+	}
+
+	return backwardFloatingTags, nil
+}
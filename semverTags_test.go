@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatVersionTag(t *testing.T) {
+	t.Run("ReturnsTidiedVersion", func(t *testing.T) {
+
+		// act
+		tag := formatVersionTag("0.0.187-release/release-x", "", "")
+
+		assert.Equal(t, "0.0.187-release-release-x", tag)
+	})
+
+	t.Run("ReturnsVersionWithPrefixAndSuffix", func(t *testing.T) {
+
+		// act
+		tag := formatVersionTag("1.4.7", "api", "windows")
+
+		assert.Equal(t, "api-1.4.7-windows", tag)
+	})
+}
+
+func TestGetSemverFloatingTags(t *testing.T) {
+	t.Run("ReturnsMajorAndMinorTags", func(t *testing.T) {
+
+		// act
+		tags, err := getSemverFloatingTags("1.4.7", "", "")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1", "1.4"}, tags)
+	})
+
+	t.Run("ReturnsMajorAndMinorTagsWithPrefixAndSuffix", func(t *testing.T) {
+
+		// act
+		tags, err := getSemverFloatingTags("1.4.7", "api", "windows")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"api-1-windows", "api-1.4-windows"}, tags)
+	})
+
+	t.Run("ReturnsNoTagsForPreRelease", func(t *testing.T) {
+
+		// act
+		tags, err := getSemverFloatingTags("1.4.7-feature-branch", "", "")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(tags))
+	})
+
+	t.Run("ReturnsErrorForNonSemanticVersion", func(t *testing.T) {
+
+		// act
+		_, err := getSemverFloatingTags("build-23", "", "")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetHigherVersionTags(t *testing.T) {
+
+	tags := []string{"1", "1.4", "1.3.9", "1.4.6", "1.4.8", "1.4.10", "1.5.0", "1.5.1-beta", "2.0.0", "stable"}
+	version, _ := parseSemanticVersion("1.4.7")
+
+	t.Run("ReturnsHigherVersionsInSameMinorLineForMinorTag", func(t *testing.T) {
+
+		// act
+		higherVersionTags := getHigherVersionTags(tags, "1.4", version, "", "")
+
+		assert.Equal(t, []string{"1.4.8", "1.4.10"}, higherVersionTags)
+	})
+
+	t.Run("ReturnsHigherVersionsInSameMajorLineForMajorTag", func(t *testing.T) {
+
+		// act
+		higherVersionTags := getHigherVersionTags(tags, "1", version, "", "")
+
+		assert.Equal(t, []string{"1.4.8", "1.4.10", "1.5.0"}, higherVersionTags)
+	})
+
+	t.Run("ReturnsNothingForOtherTags", func(t *testing.T) {
+
+		// act
+		higherVersionTags := getHigherVersionTags(tags, "stable", version, "", "")
+
+		assert.Equal(t, 0, len(higherVersionTags))
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSemanticVersion(t *testing.T) {
+	t.Run("ReturnsVersionForFullVersion", func(t *testing.T) {
+
+		// act
+		v, err := parseSemanticVersion("1.4.7")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, v.major)
+		assert.Equal(t, 4, v.minor)
+		assert.Equal(t, 7, v.patch)
+		assert.Equal(t, 3, v.parts)
+		assert.False(t, v.isPreRelease())
+	})
+
+	t.Run("ReturnsVersionWithPreReleaseAndBuild", func(t *testing.T) {
+
+		// act
+		v, err := parseSemanticVersion("v1.4.7-beta.2+abc123")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "beta.2", v.preRelease)
+		assert.Equal(t, "abc123", v.build)
+		assert.Equal(t, "1.4.7-beta.2+abc123", v.String())
+	})
+
+	t.Run("ReturnsVersionForPartialVersion", func(t *testing.T) {
+
+		// act
+		v, err := parseSemanticVersion("1.21")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, v.major)
+		assert.Equal(t, 21, v.minor)
+		assert.Equal(t, 2, v.parts)
+	})
+
+	t.Run("ReturnsErrorForNonVersion", func(t *testing.T) {
+
+		// act
+		_, err := parseSemanticVersion("stable")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestSemanticVersionCompare(t *testing.T) {
+	t.Run("OrdersVersionsBySemverPrecedence", func(t *testing.T) {
+
+		versions := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "2.0.0"}
+
+		for i := 0; i < len(versions)-1; i++ {
+			a, _ := parseSemanticVersion(versions[i])
+			b, _ := parseSemanticVersion(versions[i+1])
+
+			// act
+			assert.Equal(t, -1, a.compare(b), "%v < %v", versions[i], versions[i+1])
+			assert.Equal(t, 1, b.compare(a), "%v > %v", versions[i+1], versions[i])
+		}
+	})
+}
+
+func TestSemverRange(t *testing.T) {
+
+	matches := func(rangeValue, version string) bool {
+		r, err := parseSemverRange(rangeValue)
+		assert.Nil(t, err)
+		v, err := parseSemanticVersion(version)
+		assert.Nil(t, err)
+		return r.matches(v)
+	}
+
+	t.Run("MatchesComparators", func(t *testing.T) {
+		assert.True(t, matches(">=1.2.0 <2.0.0", "1.2.0"))
+		assert.True(t, matches(">=1.2.0 <2.0.0", "1.9.9"))
+		assert.False(t, matches(">=1.2.0 <2.0.0", "2.0.0"))
+		assert.False(t, matches(">=1.2.0 <2.0.0", "1.1.9"))
+	})
+
+	t.Run("MatchesComparatorsWithSpaceAfterOperator", func(t *testing.T) {
+		assert.True(t, matches(">= 1.2.0", "1.2.0"))
+		assert.True(t, matches(">= 1.2.0 < 2.0.0", "1.9.9"))
+		assert.False(t, matches(">= 1.2.0 < 2.0.0", "2.0.0"))
+		assert.True(t, matches("^ 1.4, != 1.5.0", "1.4.2"))
+		assert.False(t, matches("^ 1.4, != 1.5.0", "1.5.0"))
+	})
+
+	t.Run("MatchesCaretRange", func(t *testing.T) {
+		assert.True(t, matches("^1.4", "1.9.0"))
+		assert.False(t, matches("^1.4", "1.3.0"))
+		assert.False(t, matches("^1.4", "2.0.0"))
+		assert.True(t, matches("^0.4", "0.4.9"))
+		assert.False(t, matches("^0.4", "0.5.0"))
+		assert.True(t, matches("^0.0.3", "0.0.3"))
+		assert.False(t, matches("^0.0.3", "0.0.4"))
+		assert.True(t, matches("^0.0", "0.0.9"))
+		assert.False(t, matches("^0.0", "0.1.0"))
+	})
+
+	t.Run("MatchesTildeAndPartialRange", func(t *testing.T) {
+		assert.True(t, matches("~1.4.2", "1.4.9"))
+		assert.False(t, matches("~1.4.2", "1.5.0"))
+		assert.True(t, matches("1.21", "1.21.3"))
+		assert.True(t, matches("1.x", "1.21.3"))
+		assert.False(t, matches("1.21", "1.22.0"))
+	})
+
+	t.Run("MatchesAnyAlternative", func(t *testing.T) {
+		assert.True(t, matches("1.2 || 1.4", "1.4.1"))
+		assert.False(t, matches("1.2 || 1.4", "1.3.1"))
+	})
+
+	t.Run("DoesNotMatchPreReleaseUnlessRangeMentionsOne", func(t *testing.T) {
+		assert.False(t, matches("^1.4", "1.5.0-beta"))
+		assert.True(t, matches(">=1.5.0-alpha <1.6.0", "1.5.0-beta"))
+	})
+
+	t.Run("ReturnsErrorForInvalidRange", func(t *testing.T) {
+
+		// act
+		_, err := parseSemverRange(">=latest")
+
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	byteSizeRegex = regexp.MustCompile(`^\s*([0-9]+(?:\.[0-9]+)?)\s*([kKmMgGtT]i?)?[bB]?\s*$`)
+)
+
+// sizeBudgets are the limits the built image is checked against; zero disables a limit
+type sizeBudgets struct {
+	maxImageSize  int64
+	maxLayerCount int
+	maxLayerSize  int64
+}
+
+func (b sizeBudgets) isSet() bool {
+	return b.maxImageSize > 0 || b.maxLayerCount > 0 || b.maxLayerSize > 0
+}
+
+// imageLayer is a layer of a local image as listed by docker history
+type imageLayer struct {
+	createdBy string
+	size      int64
+}
+
+// layerDiff is the change in size of a layer compared to the previous version; previousSize or size is -1 for added or removed layers
+type layerDiff struct {
+	createdBy    string
+	previousSize int64
+	size         int64
+}
+
+// parseByteSize parses sizes like 500MB, 1.5GiB, 800k or a plain number of bytes; units without i are powers of 1000
+func parseByteSize(value string) (int64, error) {
+
+	if value == "" {
+		return 0, nil
+	}
+
+	matches := byteSizeRegex.FindStringSubmatch(value)
+	if matches == nil {
+		return 0, fmt.Errorf("%v is not a valid size, use a number with an optional unit like 500MB or 2GiB", value)
+	}
+
+	number, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	unit := strings.ToLower(matches[2])
+	base := 1000.0
+	if strings.HasSuffix(unit, "i") {
+		base = 1024.0
+	}
+	multiplier := 1.0
+	switch strings.TrimSuffix(unit, "i") {
+	case "k":
+		multiplier = base
+	case "m":
+		multiplier = base * base
+	case "g":
+		multiplier = base * base * base
+	case "t":
+		multiplier = base * base * base * base
+	}
+
+	return int64(number * multiplier), nil
+}
+
+// formatByteSize formats a number of bytes like docker does, with powers of 1000
+func formatByteSize(size int64) string {
+
+	value := float64(size)
+	for _, unit := range []string{"B", "kB", "MB", "GB"} {
+		if value < 1000 && value > -1000 {
+			if unit == "B" {
+				return fmt.Sprintf("%v%v", size, unit)
+			}
+			return fmt.Sprintf("%.1f%v", value, unit)
+		}
+		value /= 1000
+	}
+
+	return fmt.Sprintf("%.1fTB", value)
+}
+
+// checkSizeBudgets returns a description for every budget the image exceeds
+func checkSizeBudgets(imageSize int64, layerCount int, layers []imageLayer, budgets sizeBudgets) []string {
+
+	var violations []string
+	if budgets.maxImageSize > 0 && imageSize > budgets.maxImageSize {
+		violations = append(violations, fmt.Sprintf("image size %v exceeds maxImageSize %v", formatByteSize(imageSize), formatByteSize(budgets.maxImageSize)))
+	}
+	if budgets.maxLayerCount > 0 && layerCount > budgets.maxLayerCount {
+		violations = append(violations, fmt.Sprintf("layer count %v exceeds maxLayerCount %v", layerCount, budgets.maxLayerCount))
+	}
+	if budgets.maxLayerSize > 0 {
+		for _, l := range layers {
+			if l.size > budgets.maxLayerSize {
+				violations = append(violations, fmt.Sprintf("layer size %v of '%v' exceeds maxLayerSize %v", formatByteSize(l.size), l.createdBy, formatByteSize(budgets.maxLayerSize)))
+			}
+		}
+	}
+
+	return violations
+}
+
+// diffImageLayers matches the layers of both images on the instruction that created them and returns the ones that changed in size, were added or removed
+func diffImageLayers(previousLayers, layers []imageLayer) []layerDiff {
+
+	var diffs []layerDiff
+	matched := make([]bool, len(previousLayers))
+	for _, l := range layers {
+		previousSize := int64(-1)
+		for i, pl := range previousLayers {
+			if !matched[i] && pl.createdBy == l.createdBy {
+				matched[i] = true
+				previousSize = pl.size
+				break
+			}
+		}
+		if previousSize != l.size {
+			diffs = append(diffs, layerDiff{createdBy: l.createdBy, previousSize: previousSize, size: l.size})
+		}
+	}
+	for i, pl := range previousLayers {
+		if !matched[i] {
+			diffs = append(diffs, layerDiff{createdBy: pl.createdBy, previousSize: pl.size, size: -1})
+		}
+	}
+
+	return diffs
+}
+
+// parseImageHistory parses the output of docker history with format '{{.Size}}\t{{.CreatedBy}}' into non-empty layers, bottom layer first
+func parseImageHistory(output string) ([]imageLayer, error) {
+
+	var layers []imageLayer
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		sizeValue, createdBy, _ := strings.Cut(line, "\t")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeValue), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing layer size %v: %w", sizeValue, err)
+		}
+		if size == 0 {
+			continue
+		}
+		// docker history lists the most recent layer first
+		layers = append([]imageLayer{{createdBy: strings.TrimSpace(createdBy), size: size}}, layers...)
+	}
+
+	return layers, nil
+}
+
+func getImageLayers(ctx context.Context, containerPath string) ([]imageLayer, error) {
+	output, err := foundation.GetCommandWithArgsOutput(ctx, "docker", []string{"history", "--no-trunc", "--human=false", "--format", "{{.Size}}\t{{.CreatedBy}}", containerPath})
+	if err != nil {
+		return nil, fmt.Errorf("failed retrieving history of %v: %w", containerPath, err)
+	}
+	return parseImageHistory(output)
+}
+
+// getPreviousVersionTag returns the highest version tag lower than version, or the highest version tag if version isn't a semantic version
+func getPreviousVersionTag(tags []string, version, versionTag, versionTagPrefix, versionTagSuffix string) string {
+
+	currentVersion, err := parseSemanticVersion(version)
+	isSemanticVersion := err == nil
+
+	previousTag := ""
+	var previousVersion semanticVersion
+	for _, t := range tags {
+		if t == versionTag {
+			continue
+		}
+		v, ok := getVersionFromTag(t, versionTagPrefix, versionTagSuffix, currentVersion.preRelease)
+		if !ok || (isSemanticVersion && v.compare(currentVersion) >= 0) {
+			continue
+		}
+		if previousTag == "" || v.compare(previousVersion) > 0 {
+			previousTag, previousVersion = t, v
+		}
+	}
+
+	return previousTag
+}
+
+// checkImageSize checks the built image against the size budgets and logs which layers grew compared to the previous version in the first repository
+func checkImageSize(ctx context.Context, credentials []ContainerRegistryCredentials, image buildImage, containerPath, version, versionTag, versionTagSuffix string, budgets sizeBudgets) error {
+
+	if *dryRun {
+		plan.addStep(fmt.Sprintf("check size of %v against budgets", containerPath), "", nil)
+		return nil
+	}
+
+	output, err := foundation.GetCommandWithArgsOutput(ctx, "docker", []string{"image", "inspect", "--format", "{{.Size}} {{len .RootFS.Layers}}", containerPath})
+	if err != nil {
+		return fmt.Errorf("failed inspecting %v: %w", containerPath, err)
+	}
+	var imageSize int64
+	var layerCount int
+	_, err = fmt.Sscan(output, &imageSize, &layerCount)
+	if err != nil {
+		return fmt.Errorf("failed reading size of %v: %w", containerPath, err)
+	}
+	layers, err := getImageLayers(ctx, containerPath)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Container image %v has size %v in %v layers", containerPath, formatByteSize(imageSize), layerCount)
+
+	logSizeDiffWithPreviousVersion(ctx, credentials, image, layers, imageSize, version, versionTag, versionTagSuffix)
+
+	violations := checkSizeBudgets(imageSize, layerCount, layers, budgets)
+	if len(violations) == 0 {
+		return nil
+	}
+	if *sizeBudgetMode == "warn" {
+		for _, v := range violations {
+			log.Warn().Msgf("Container image %v: %v", containerPath, v)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Container image %v exceeds its size budget: %v", containerPath, strings.Join(violations, "; "))
+}
+
+// logSizeDiffWithPreviousVersion pulls the previous version of the image and logs the layers that changed in size; failures only get logged since the diff is informational
+func logSizeDiffWithPreviousVersion(ctx context.Context, credentials []ContainerRegistryCredentials, image buildImage, layers []imageLayer, imageSize int64, version, versionTag, versionTagSuffix string) {
+
+	repository := fmt.Sprintf("%v/%v", image.Repositories[0], image.Container)
+	tags, err := newRegistryClient(credentials).listTags(ctx, repository)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed listing tags of %v, skipping size comparison with previous version", repository)
+		return
+	}
+	previousTag := getPreviousVersionTag(tags, version, versionTag, *versionTagPrefix, versionTagSuffix)
+	if previousTag == "" {
+		log.Info().Msgf("No previous version of %v found, skipping size comparison", repository)
+		return
+	}
+
+	previousContainerPath := fmt.Sprintf("%v:%v", repository, previousTag)
+	err = loginIfRequiredExtended(ctx, credentials, false, previousContainerPath)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed logging in for %v, skipping size comparison with previous version", previousContainerPath)
+		return
+	}
+	err = runDockerCommandWithRetryExtended(ctx, "pull", []string{"pull", previousContainerPath}, "")
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed pulling %v, skipping size comparison with previous version", previousContainerPath)
+		return
+	}
+
+	output, err := foundation.GetCommandWithArgsOutput(ctx, "docker", []string{"image", "inspect", "--format", "{{.Size}}", previousContainerPath})
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed inspecting %v, skipping size comparison with previous version", previousContainerPath)
+		return
+	}
+	previousImageSize, _ := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	previousLayers, err := getImageLayers(ctx, previousContainerPath)
+	if err != nil {
+		log.Warn().Err(err).Msg("Skipping size comparison with previous version")
+		return
+	}
+
+	log.Info().Msgf("Size changed from %v for %v to %v (%+d bytes)", formatByteSize(previousImageSize), previousTag, formatByteSize(imageSize), imageSize-previousImageSize)
+	for _, d := range diffImageLayers(previousLayers, layers) {
+		switch {
+		case d.previousSize < 0:
+			log.Info().Msgf("- added layer of %v: %v", formatByteSize(d.size), d.createdBy)
+		case d.size < 0:
+			log.Info().Msgf("- removed layer of %v: %v", formatByteSize(d.previousSize), d.createdBy)
+		case d.size > d.previousSize:
+			log.Warn().Msgf("- layer grew from %v to %v: %v", formatByteSize(d.previousSize), formatByteSize(d.size), d.createdBy)
+		default:
+			log.Info().Msgf("- layer shrank from %v to %v: %v", formatByteSize(d.previousSize), formatByteSize(d.size), d.createdBy)
+		}
+	}
+}
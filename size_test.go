@@ -0,0 +1,184 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseByteSize(t *testing.T) {
+	t.Run("ReturnsZeroForEmptyValue", func(t *testing.T) {
+
+		// act
+		size, err := parseByteSize("")
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), size)
+	})
+
+	t.Run("ReturnsSizeForUnitsAndPlainNumbers", func(t *testing.T) {
+
+		values := map[string]int64{
+			"1024":    1024,
+			"500B":    500,
+			"800k":    800000,
+			"500MB":   500000000,
+			"1.5GB":   1500000000,
+			"2GiB":    2147483648,
+			"200 MiB": 209715200,
+			"1kib":    1024,
+		}
+
+		for value, expected := range values {
+
+			// act
+			size, err := parseByteSize(value)
+
+			assert.Nil(t, err, value)
+			assert.Equal(t, expected, size, value)
+		}
+	})
+
+	t.Run("ReturnsErrorForInvalidSize", func(t *testing.T) {
+
+		// act
+		_, err := parseByteSize("large")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestFormatByteSize(t *testing.T) {
+	t.Run("ReturnsSizeWithLargestFittingUnit", func(t *testing.T) {
+
+		assert.Equal(t, "512B", formatByteSize(512))
+		assert.Equal(t, "1.5kB", formatByteSize(1500))
+		assert.Equal(t, "500.0MB", formatByteSize(500000000))
+		assert.Equal(t, "2.1GB", formatByteSize(2147483648))
+	})
+}
+
+func TestCheckSizeBudgets(t *testing.T) {
+
+	layers := []imageLayer{
+		{createdBy: "ADD alpine-minirootfs.tar.gz /", size: 7000000},
+		{createdBy: "COPY app /", size: 250000000},
+	}
+
+	t.Run("ReturnsNoViolationsIfWithinBudgets", func(t *testing.T) {
+
+		// act
+		violations := checkSizeBudgets(257000000, 2, layers, sizeBudgets{maxImageSize: 500000000, maxLayerCount: 10, maxLayerSize: 300000000})
+
+		assert.Equal(t, 0, len(violations))
+	})
+
+	t.Run("ReturnsViolationForEveryExceededBudget", func(t *testing.T) {
+
+		// act
+		violations := checkSizeBudgets(257000000, 2, layers, sizeBudgets{maxImageSize: 200000000, maxLayerCount: 1, maxLayerSize: 100000000})
+
+		assert.Equal(t, []string{
+			"image size 257.0MB exceeds maxImageSize 200.0MB",
+			"layer count 2 exceeds maxLayerCount 1",
+			"layer size 250.0MB of 'COPY app /' exceeds maxLayerSize 100.0MB",
+		}, violations)
+	})
+
+	t.Run("IgnoresUnsetBudgets", func(t *testing.T) {
+
+		// act
+		violations := checkSizeBudgets(257000000, 2, layers, sizeBudgets{})
+
+		assert.Equal(t, 0, len(violations))
+	})
+}
+
+func TestDiffImageLayers(t *testing.T) {
+	t.Run("ReturnsChangedAddedAndRemovedLayers", func(t *testing.T) {
+
+		previousLayers := []imageLayer{
+			{createdBy: "ADD alpine-minirootfs.tar.gz /", size: 7000000},
+			{createdBy: "RUN apk add curl", size: 3000000},
+			{createdBy: "COPY app /", size: 20000000},
+		}
+		layers := []imageLayer{
+			{createdBy: "ADD alpine-minirootfs.tar.gz /", size: 7000000},
+			{createdBy: "COPY app /", size: 25000000},
+			{createdBy: "COPY static /static", size: 1000000},
+		}
+
+		// act
+		diffs := diffImageLayers(previousLayers, layers)
+
+		assert.Equal(t, []layerDiff{
+			{createdBy: "COPY app /", previousSize: 20000000, size: 25000000},
+			{createdBy: "COPY static /static", previousSize: -1, size: 1000000},
+			{createdBy: "RUN apk add curl", previousSize: 3000000, size: -1},
+		}, diffs)
+	})
+}
+
+func TestParseImageHistory(t *testing.T) {
+	t.Run("ReturnsNonEmptyLayersBottomLayerFirst", func(t *testing.T) {
+
+		output := "25000000\tCOPY app / # buildkit\n0\tENV PORT=8080\n7000000\t/bin/sh -c #(nop) ADD file:abc in / \n"
+
+		// act
+		layers, err := parseImageHistory(output)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []imageLayer{
+			{createdBy: "/bin/sh -c #(nop) ADD file:abc in /", size: 7000000},
+			{createdBy: "COPY app / # buildkit", size: 25000000},
+		}, layers)
+	})
+
+	t.Run("ReturnsErrorIfSizeIsNotANumber", func(t *testing.T) {
+
+		// act
+		_, err := parseImageHistory("25MB\tCOPY app /")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetPreviousVersionTag(t *testing.T) {
+	t.Run("ReturnsHighestVersionLowerThanVersion", func(t *testing.T) {
+
+		tags := []string{"1.0.0", "1.2.0", "1.10.0", "2.0.0", "1.1.0", "stable", "dlc"}
+
+		// act
+		previousTag := getPreviousVersionTag(tags, "1.10.0", "1.10.0", "", "")
+
+		assert.Equal(t, "1.2.0", previousTag)
+	})
+
+	t.Run("ReturnsHighestVersionIfVersionIsNotSemantic", func(t *testing.T) {
+
+		tags := []string{"1.0.0", "1.2.0", "stable"}
+
+		// act
+		previousTag := getPreviousVersionTag(tags, "main-42", "main-42", "", "")
+
+		assert.Equal(t, "1.2.0", previousTag)
+	})
+
+	t.Run("RespectsVersionTagPrefix", func(t *testing.T) {
+
+		tags := []string{"api-1.0.0", "web-1.1.0", "1.1.0"}
+
+		// act
+		previousTag := getPreviousVersionTag(tags, "1.2.0", "api-1.2.0", "api", "")
+
+		assert.Equal(t, "api-1.0.0", previousTag)
+	})
+
+	t.Run("ReturnsEmptyStringIfThereIsNoPreviousVersion", func(t *testing.T) {
+
+		// act
+		previousTag := getPreviousVersionTag([]string{"1.0.0", "stable"}, "1.0.0", "1.0.0", "", "")
+
+		assert.Equal(t, "", previousTag)
+	})
+}
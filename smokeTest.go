@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	smokeTestDefaultTimeoutSeconds = 60
+	smokeTestPollInterval          = time.Second
+)
+
+// smokeTest runs the built image to verify it starts; it's ready once its HEALTHCHECK reports healthy and the port answers, or, without either, once it exits with code 0
+type smokeTest struct {
+	Env            map[string]string `yaml:"env"`
+	Args           []string          `yaml:"args"`
+	Port           int               `yaml:"port"`
+	HTTPPath       string            `yaml:"httpPath"`
+	TimeoutSeconds int               `yaml:"timeoutSeconds"`
+}
+
+// parseSmokeTest parses the `smokeTest:` block (yaml or json); it returns nil if it isn't set
+func parseSmokeTest(value string) (*smokeTest, error) {
+
+	if value == "" {
+		return nil, nil
+	}
+
+	var test smokeTest
+	err := yaml.Unmarshal([]byte(value), &test)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing `smokeTest:`: %w", err)
+	}
+
+	err = test.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &test, nil
+}
+
+func (t smokeTest) validate() error {
+	if t.Port < 0 || t.Port > 65535 {
+		return fmt.Errorf("smokeTest port %v is not a valid port", t.Port)
+	}
+	if t.HTTPPath != "" && t.Port == 0 {
+		return fmt.Errorf("smokeTest httpPath %v requires a port", t.HTTPPath)
+	}
+	if t.HTTPPath != "" && !strings.HasPrefix(t.HTTPPath, "/") {
+		return fmt.Errorf("smokeTest httpPath %v should start with /", t.HTTPPath)
+	}
+	if t.TimeoutSeconds < 0 {
+		return fmt.Errorf("smokeTest timeoutSeconds can't be negative")
+	}
+	return nil
+}
+
+func (t smokeTest) timeout() time.Duration {
+	if t.TimeoutSeconds == 0 {
+		return smokeTestDefaultTimeoutSeconds * time.Second
+	}
+	return time.Duration(t.TimeoutSeconds) * time.Second
+}
+
+// getSmokeTestRunArgs returns the arguments to start the image in the background with the smoke test env and args
+func getSmokeTestRunArgs(containerPath, name string, test smokeTest) []string {
+
+	args := []string{
+		"run",
+		"--detach",
+		"--name",
+		name,
+	}
+
+	names := make([]string, 0, len(test.Env))
+	for n := range test.Env {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		args = append(args, "--env", fmt.Sprintf("%v=%v", n, os.ExpandEnv(test.Env[n])))
+	}
+
+	args = append(args, containerPath)
+	args = append(args, test.Args...)
+
+	return args
+}
+
+// containerState contains the fields of docker inspect's State used to follow a smoke test
+type containerState struct {
+	Status   string `json:"Status"`
+	Running  bool   `json:"Running"`
+	ExitCode int    `json:"ExitCode"`
+	Health   *struct {
+		Status string `json:"Status"`
+	} `json:"Health"`
+}
+
+// evaluateSmokeTest returns whether the container is ready, or an error if it crashed or became unhealthy
+func evaluateSmokeTest(state containerState, portReady bool, test smokeTest) (bool, error) {
+
+	hasHealthcheck := state.Health != nil && state.Health.Status != "" && state.Health.Status != "none"
+
+	if hasHealthcheck && state.Health.Status == "unhealthy" {
+		return false, fmt.Errorf("container became unhealthy")
+	}
+
+	if !state.Running {
+		if !hasHealthcheck && test.Port == 0 && state.Status == "exited" {
+			if state.ExitCode == 0 {
+				return true, nil
+			}
+			return false, fmt.Errorf("container exited with code %v", state.ExitCode)
+		}
+		if state.Status == "created" {
+			return false, nil
+		}
+		return false, fmt.Errorf("container stopped with status %v and exit code %v before becoming ready", state.Status, state.ExitCode)
+	}
+
+	if !hasHealthcheck && test.Port == 0 {
+		// wait for the command to finish
+		return false, nil
+	}
+	if hasHealthcheck && state.Health.Status != "healthy" {
+		return false, nil
+	}
+	if test.Port > 0 && !portReady {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// runSmokeTest starts the image, waits for it to become ready within the timeout and logs its output; the container is always removed afterwards
+func runSmokeTest(ctx context.Context, containerPath string, test smokeTest) error {
+
+	name := fmt.Sprintf("smoke-test-%v", time.Now().UnixNano())
+	runArgs := getSmokeTestRunArgs(containerPath, name, test)
+
+	if *dryRun {
+		plan.addStep("smoke test", "docker", runArgs)
+		return nil
+	}
+
+	log.Info().Msgf("Smoke testing container image %v for at most %v...", containerPath, test.timeout())
+	_, err := foundation.GetCommandWithArgsOutput(ctx, "docker", runArgs)
+	defer func() {
+		// use a fresh context, so the container gets removed on sigterm as well
+		cleanupCtx := context.Background()
+		logs, _ := foundation.GetCommandWithArgsOutput(cleanupCtx, "docker", []string{"logs", name})
+		log.Info().Msgf("Smoke test logs of %v:\n%v", containerPath, logs)
+		_, _ = foundation.GetCommandWithArgsOutput(cleanupCtx, "docker", []string{"rm", "--force", name})
+	}()
+	if err != nil {
+		return fmt.Errorf("smoke test failed starting container image %v: %w", containerPath, err)
+	}
+
+	deadline := time.Now().Add(test.timeout())
+	address := ""
+	for {
+		output, err := foundation.GetCommandWithArgsOutput(ctx, "docker", []string{"inspect", "--format", "{{json .State}}", name})
+		if err != nil {
+			return fmt.Errorf("smoke test failed inspecting container %v: %w", name, err)
+		}
+		var state containerState
+		err = json.Unmarshal([]byte(strings.TrimSpace(output)), &state)
+		if err != nil {
+			return fmt.Errorf("smoke test failed reading state of container %v: %w", name, err)
+		}
+
+		portReady := false
+		if test.Port > 0 && state.Running {
+			if address == "" {
+				address = getSmokeTestAddress(ctx, name, test.Port)
+			}
+			portReady = address != "" && probeSmokeTestPort(ctx, address, test.HTTPPath)
+		}
+
+		ready, err := evaluateSmokeTest(state, portReady, test)
+		if err != nil {
+			return fmt.Errorf("smoke test of container image %v failed: %w", containerPath, err)
+		}
+		if ready {
+			log.Info().Msgf("Smoke test of container image %v succeeded", containerPath)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("smoke test of container image %v failed: container didn't become ready within %v", containerPath, test.timeout())
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(smokeTestPollInterval):
+		}
+	}
+}
+
+// getSmokeTestAddress returns the ip address and port to probe the container on, or an empty string if it has no ip address yet
+func getSmokeTestAddress(ctx context.Context, name string, port int) string {
+	output, err := foundation.GetCommandWithArgsOutput(ctx, "docker", []string{"inspect", "--format", "{{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}", name})
+	if err != nil {
+		return ""
+	}
+	ipAddresses := strings.Fields(output)
+	if len(ipAddresses) == 0 {
+		return ""
+	}
+	return net.JoinHostPort(ipAddresses[0], strconv.Itoa(port))
+}
+
+// probeSmokeTestPort returns true if the address accepts a tcp connection, or if httpPath is set, responds with a 2xx or 3xx status
+func probeSmokeTestPort(ctx context.Context, address, httpPath string) bool {
+
+	if httpPath == "" {
+		conn, err := net.DialTimeout("tcp", address, 2*time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%v%v", address, httpPath), nil)
+	if err != nil {
+		return false
+	}
+	client := &http.Client{
+		Timeout: 2 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+
+	return response.StatusCode >= 200 && response.StatusCode < 400
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSmokeTest(t *testing.T) {
+	t.Run("ReturnsNilIfValueIsEmpty", func(t *testing.T) {
+
+		// act
+		test, err := parseSmokeTest("")
+
+		assert.Nil(t, err)
+		assert.Nil(t, test)
+	})
+
+	t.Run("ReturnsSmokeTestFromYaml", func(t *testing.T) {
+
+		value := "env:\n  PORT: \"8080\"\nargs:\n- serve\nport: 8080\nhttpPath: /readiness\ntimeoutSeconds: 30"
+
+		// act
+		test, err := parseSmokeTest(value)
+
+		assert.Nil(t, err)
+		assert.Equal(t, &smokeTest{Env: map[string]string{"PORT": "8080"}, Args: []string{"serve"}, Port: 8080, HTTPPath: "/readiness", TimeoutSeconds: 30}, test)
+	})
+
+	t.Run("ReturnsSmokeTestFromJson", func(t *testing.T) {
+
+		// act
+		test, err := parseSmokeTest(`{"args":["--version"]}`)
+
+		assert.Nil(t, err)
+		assert.Equal(t, &smokeTest{Args: []string{"--version"}}, test)
+	})
+
+	t.Run("ReturnsErrorIfHttpPathIsSetWithoutPort", func(t *testing.T) {
+
+		// act
+		_, err := parseSmokeTest("httpPath: /readiness")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfPortIsInvalid", func(t *testing.T) {
+
+		// act
+		_, err := parseSmokeTest("port: 70000")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestSmokeTestTimeout(t *testing.T) {
+	t.Run("ReturnsDefaultIfNotSet", func(t *testing.T) {
+
+		// act
+		timeout := smokeTest{}.timeout()
+
+		assert.Equal(t, 60*time.Second, timeout)
+	})
+
+	t.Run("ReturnsTimeoutSeconds", func(t *testing.T) {
+
+		// act
+		timeout := smokeTest{TimeoutSeconds: 5}.timeout()
+
+		assert.Equal(t, 5*time.Second, timeout)
+	})
+}
+
+func TestGetSmokeTestRunArgs(t *testing.T) {
+	t.Run("ReturnsRunArgsWithSortedExpandedEnvAndArgsAfterImage", func(t *testing.T) {
+
+		os.Setenv("SMOKE_TEST_TOKEN", "abc")
+		defer os.Unsetenv("SMOKE_TEST_TOKEN")
+		test := smokeTest{Env: map[string]string{"TOKEN": "${SMOKE_TEST_TOKEN}", "MODE": "test"}, Args: []string{"serve", "--verbose"}}
+
+		// act
+		args := getSmokeTestRunArgs("extensions/docker:1.0.0", "smoke-test-1", test)
+
+		assert.Equal(t, []string{"run", "--detach", "--name", "smoke-test-1", "--env", "MODE=test", "--env", "TOKEN=abc", "extensions/docker:1.0.0", "serve", "--verbose"}, args)
+	})
+}
+
+func TestEvaluateSmokeTest(t *testing.T) {
+
+	withHealth := func(state containerState, status string) containerState {
+		state.Health = &struct {
+			Status string `json:"Status"`
+		}{Status: status}
+		return state
+	}
+
+	t.Run("ReturnsReadyIfHealthcheckIsHealthy", func(t *testing.T) {
+
+		state := withHealth(containerState{Status: "running", Running: true}, "healthy")
+
+		// act
+		ready, err := evaluateSmokeTest(state, false, smokeTest{})
+
+		assert.Nil(t, err)
+		assert.True(t, ready)
+	})
+
+	t.Run("ReturnsNotReadyIfHealthcheckIsStarting", func(t *testing.T) {
+
+		state := withHealth(containerState{Status: "running", Running: true}, "starting")
+
+		// act
+		ready, err := evaluateSmokeTest(state, false, smokeTest{})
+
+		assert.Nil(t, err)
+		assert.False(t, ready)
+	})
+
+	t.Run("ReturnsErrorIfHealthcheckIsUnhealthy", func(t *testing.T) {
+
+		state := withHealth(containerState{Status: "running", Running: true}, "unhealthy")
+
+		// act
+		_, err := evaluateSmokeTest(state, false, smokeTest{})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsNotReadyIfPortDoesNotAnswerYet", func(t *testing.T) {
+
+		state := containerState{Status: "running", Running: true}
+
+		// act
+		ready, err := evaluateSmokeTest(state, false, smokeTest{Port: 8080})
+
+		assert.Nil(t, err)
+		assert.False(t, ready)
+	})
+
+	t.Run("ReturnsReadyIfPortAnswers", func(t *testing.T) {
+
+		state := containerState{Status: "running", Running: true}
+
+		// act
+		ready, err := evaluateSmokeTest(state, true, smokeTest{Port: 8080})
+
+		assert.Nil(t, err)
+		assert.True(t, ready)
+	})
+
+	t.Run("ReturnsNotReadyIfHealthyButPortDoesNotAnswer", func(t *testing.T) {
+
+		state := withHealth(containerState{Status: "running", Running: true}, "healthy")
+
+		// act
+		ready, err := evaluateSmokeTest(state, false, smokeTest{Port: 8080})
+
+		assert.Nil(t, err)
+		assert.False(t, ready)
+	})
+
+	t.Run("ReturnsErrorIfContainerExitsBeforePortAnswers", func(t *testing.T) {
+
+		state := containerState{Status: "exited", ExitCode: 0}
+
+		// act
+		_, err := evaluateSmokeTest(state, false, smokeTest{Port: 8080})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsReadyIfContainerWithoutChecksExitsWithCodeZero", func(t *testing.T) {
+
+		state := containerState{Status: "exited", ExitCode: 0}
+
+		// act
+		ready, err := evaluateSmokeTest(state, false, smokeTest{})
+
+		assert.Nil(t, err)
+		assert.True(t, ready)
+	})
+
+	t.Run("ReturnsErrorIfContainerWithoutChecksExitsWithNonZeroCode", func(t *testing.T) {
+
+		state := containerState{Status: "exited", ExitCode: 127}
+
+		// act
+		_, err := evaluateSmokeTest(state, false, smokeTest{})
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "127")
+	})
+
+	t.Run("ReturnsNotReadyIfContainerWithoutChecksIsStillRunning", func(t *testing.T) {
+
+		state := containerState{Status: "running", Running: true}
+
+		// act
+		ready, err := evaluateSmokeTest(state, false, smokeTest{})
+
+		assert.Nil(t, err)
+		assert.False(t, ready)
+	})
+}
+
+func TestProbeSmokeTestPort(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readiness" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	t.Run("ReturnsTrueIfTcpPortAcceptsConnections", func(t *testing.T) {
+
+		// act
+		ok := probeSmokeTestPort(context.Background(), address, "")
+
+		assert.True(t, ok)
+	})
+
+	t.Run("ReturnsTrueIfHttpPathRespondsWithSuccess", func(t *testing.T) {
+
+		// act
+		ok := probeSmokeTestPort(context.Background(), address, "/readiness")
+
+		assert.True(t, ok)
+	})
+
+	t.Run("ReturnsFalseIfHttpPathRespondsWithError", func(t *testing.T) {
+
+		// act
+		ok := probeSmokeTestPort(context.Background(), address, "/liveness")
+
+		assert.False(t, ok)
+	})
+
+	t.Run("ReturnsFalseIfNothingListens", func(t *testing.T) {
+
+		// act
+		ok := probeSmokeTestPort(context.Background(), "127.0.0.1:1", "")
+
+		assert.False(t, ok)
+	})
+}
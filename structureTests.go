@@ -0,0 +1,506 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// structureTest asserts something about the built image; a single test can set several assertions, each of them is reported separately
+type structureTest struct {
+	Name string `yaml:"name"`
+
+	FileExists  string `yaml:"fileExists"`
+	FileAbsent  string `yaml:"fileAbsent"`
+	FileContent string `yaml:"fileContent"`
+
+	// Command runs in the image with the first item as entrypoint
+	Command  []string `yaml:"command"`
+	ExitCode int      `yaml:"exitCode"`
+
+	// Matches is a regular expression for the content of FileContent or the output of Command
+	Matches string `yaml:"matches"`
+
+	Entrypoint   []string          `yaml:"entrypoint"`
+	Cmd          []string          `yaml:"cmd"`
+	Env          map[string]string `yaml:"env"`
+	User         *string           `yaml:"user"`
+	ExposedPorts []string          `yaml:"exposedPorts"`
+	Labels       map[string]string `yaml:"labels"`
+}
+
+// structureTestImageConfig contains the fields of docker inspect's Config that can be asserted
+type structureTestImageConfig struct {
+	Entrypoint   []string            `json:"Entrypoint"`
+	Cmd          []string            `json:"Cmd"`
+	Env          []string            `json:"Env"`
+	User         string              `json:"User"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+	Labels       map[string]string   `json:"Labels"`
+}
+
+// structureTestImage gives access to the built image for evaluating structure tests
+type structureTestImage interface {
+	getConfig() (structureTestImageConfig, error)
+	readFile(path string) (content []byte, exists bool, err error)
+	runCommand(command []string) (output string, exitCode int, err error)
+}
+
+// assertionResult is the outcome of a single assertion; failure is empty if it passed
+type assertionResult struct {
+	name     string
+	failure  string
+	duration time.Duration
+}
+
+// parseStructureTests parses the `tests:` list passed as yaml or json, or the file at testsFilePath with a `tests:` list at its root
+func parseStructureTests(testsValue, testsFilePath string) ([]structureTest, error) {
+
+	if testsValue != "" && testsFilePath != "" {
+		return nil, fmt.Errorf("Set either `tests:` or `testsFile:`, not both")
+	}
+
+	var tests []structureTest
+	switch {
+	case testsValue != "":
+		err := yaml.Unmarshal([]byte(testsValue), &tests)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing `tests:`: %w", err)
+		}
+
+	case testsFilePath != "":
+		data, err := os.ReadFile(testsFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading tests file %v: %w", testsFilePath, err)
+		}
+		var testsFile struct {
+			Tests []structureTest `yaml:"tests"`
+		}
+		err = yaml.Unmarshal(data, &testsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing tests file %v: %w", testsFilePath, err)
+		}
+		tests = testsFile.Tests
+	}
+
+	err := validateStructureTests(tests)
+	if err != nil {
+		return nil, err
+	}
+
+	return tests, nil
+}
+
+// validateStructureTests checks the regular expressions and that matches is used with fileContent or command
+func validateStructureTests(tests []structureTest) error {
+	for i, t := range tests {
+		if t.Matches != "" {
+			if _, err := regexp.Compile(t.Matches); err != nil {
+				return fmt.Errorf("test %v has invalid matches regular expression: %w", i+1, err)
+			}
+			if t.FileContent == "" && len(t.Command) == 0 {
+				return fmt.Errorf("test %v sets matches without fileContent or command", i+1)
+			}
+		}
+		if t.FileContent != "" && t.Matches == "" {
+			return fmt.Errorf("test %v sets fileContent without matches", i+1)
+		}
+	}
+
+	return nil
+}
+
+// runStructureTests evaluates every assertion of the tests against the image
+func runStructureTests(image structureTestImage, tests []structureTest) []assertionResult {
+
+	var results []assertionResult
+
+	var config *structureTestImageConfig
+	var configErr error
+	getConfig := func() (structureTestImageConfig, error) {
+		if config == nil && configErr == nil {
+			c, err := image.getConfig()
+			config, configErr = &c, err
+		}
+		return *config, configErr
+	}
+
+	for _, t := range tests {
+		add := func(description string, assert func() string) {
+			name := description
+			if t.Name != "" {
+				name = fmt.Sprintf("%v: %v", t.Name, description)
+			}
+			start := time.Now()
+			failure := assert()
+			results = append(results, assertionResult{name: name, failure: failure, duration: time.Since(start)})
+		}
+
+		if t.FileExists != "" {
+			add(fmt.Sprintf("file %v exists", t.FileExists), func() string {
+				_, exists, err := image.readFile(t.FileExists)
+				if err != nil {
+					return err.Error()
+				}
+				if !exists {
+					return fmt.Sprintf("file %v doesn't exist", t.FileExists)
+				}
+				return ""
+			})
+		}
+
+		if t.FileAbsent != "" {
+			add(fmt.Sprintf("file %v is absent", t.FileAbsent), func() string {
+				_, exists, err := image.readFile(t.FileAbsent)
+				if err != nil {
+					return err.Error()
+				}
+				if exists {
+					return fmt.Sprintf("file %v exists", t.FileAbsent)
+				}
+				return ""
+			})
+		}
+
+		if t.FileContent != "" {
+			add(fmt.Sprintf("file %v content matches %v", t.FileContent, t.Matches), func() string {
+				content, exists, err := image.readFile(t.FileContent)
+				if err != nil {
+					return err.Error()
+				}
+				if !exists {
+					return fmt.Sprintf("file %v doesn't exist", t.FileContent)
+				}
+				if !regexp.MustCompile(t.Matches).Match(content) {
+					return fmt.Sprintf("content of file %v doesn't match %v:\n%v", t.FileContent, t.Matches, string(content))
+				}
+				return ""
+			})
+		}
+
+		if len(t.Command) > 0 {
+			add(fmt.Sprintf("command %v exits with %v", strings.Join(t.Command, " "), t.ExitCode), func() string {
+				output, exitCode, err := image.runCommand(t.Command)
+				if err != nil {
+					return err.Error()
+				}
+				if exitCode != t.ExitCode {
+					return fmt.Sprintf("command exited with %v instead of %v:\n%v", exitCode, t.ExitCode, output)
+				}
+				if t.Matches != "" && !regexp.MustCompile(t.Matches).MatchString(output) {
+					return fmt.Sprintf("command output doesn't match %v:\n%v", t.Matches, output)
+				}
+				return ""
+			})
+		}
+
+		if t.Entrypoint != nil {
+			add(fmt.Sprintf("entrypoint is %v", t.Entrypoint), func() string {
+				c, err := getConfig()
+				if err != nil {
+					return err.Error()
+				}
+				return compareStringSlices("entrypoint", t.Entrypoint, c.Entrypoint)
+			})
+		}
+
+		if t.Cmd != nil {
+			add(fmt.Sprintf("cmd is %v", t.Cmd), func() string {
+				c, err := getConfig()
+				if err != nil {
+					return err.Error()
+				}
+				return compareStringSlices("cmd", t.Cmd, c.Cmd)
+			})
+		}
+
+		for _, name := range sortedKeys(t.Env) {
+			name, value := name, t.Env[name]
+			add(fmt.Sprintf("env %v is %v", name, value), func() string {
+				c, err := getConfig()
+				if err != nil {
+					return err.Error()
+				}
+				for _, e := range c.Env {
+					if n, v, _ := strings.Cut(e, "="); n == name {
+						if v != value {
+							return fmt.Sprintf("env %v is %v instead of %v", name, v, value)
+						}
+						return ""
+					}
+				}
+				return fmt.Sprintf("env %v isn't set", name)
+			})
+		}
+
+		if t.User != nil {
+			add(fmt.Sprintf("user is %v", *t.User), func() string {
+				c, err := getConfig()
+				if err != nil {
+					return err.Error()
+				}
+				if c.User != *t.User {
+					return fmt.Sprintf("user is %q instead of %q", c.User, *t.User)
+				}
+				return ""
+			})
+		}
+
+		for _, port := range t.ExposedPorts {
+			port := port
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			add(fmt.Sprintf("port %v is exposed", port), func() string {
+				c, err := getConfig()
+				if err != nil {
+					return err.Error()
+				}
+				if _, ok := c.ExposedPorts[port]; !ok {
+					return fmt.Sprintf("port %v isn't exposed", port)
+				}
+				return ""
+			})
+		}
+
+		for _, name := range sortedKeys(t.Labels) {
+			name, value := name, t.Labels[name]
+			add(fmt.Sprintf("label %v is %v", name, value), func() string {
+				c, err := getConfig()
+				if err != nil {
+					return err.Error()
+				}
+				v, ok := c.Labels[name]
+				if !ok {
+					return fmt.Sprintf("label %v isn't set", name)
+				}
+				if v != value {
+					return fmt.Sprintf("label %v is %v instead of %v", name, v, value)
+				}
+				return ""
+			})
+		}
+	}
+
+	return results
+}
+
+func compareStringSlices(name string, expected, actual []string) string {
+	if len(expected) == 0 && len(actual) == 0 {
+		return ""
+	}
+	if strings.Join(expected, "\x00") != strings.Join(actual, "\x00") || len(expected) != len(actual) {
+		return fmt.Sprintf("%v is %q instead of %q", name, actual, expected)
+	}
+	return ""
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// junitTestSuites is the root of a JUnit XML report
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message  string `xml:"message,attr"`
+	Contents string `xml:",chardata"`
+}
+
+// newJUnitTestSuite converts the assertion results for a container into a JUnit test suite
+func newJUnitTestSuite(container string, results []assertionResult) junitTestSuite {
+
+	suite := junitTestSuite{
+		Name:  container,
+		Tests: len(results),
+	}
+
+	var total time.Duration
+	for _, r := range results {
+		total += r.duration
+		testCase := junitTestCase{
+			Name:      r.name,
+			ClassName: container,
+			Time:      fmt.Sprintf("%.3f", r.duration.Seconds()),
+		}
+		if r.failure != "" {
+			suite.Failures++
+			message, _, _ := strings.Cut(r.failure, "\n")
+			testCase.Failure = &junitFailure{Message: message, Contents: r.failure}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total.Seconds())
+
+	return suite
+}
+
+var (
+	// structureTestsReport collects the test suites of all images, so the report file covers all of them
+	structureTestsReport      = junitTestSuites{}
+	structureTestsReportMutex sync.Mutex
+)
+
+// writeStructureTestsReport adds the suite to the report and writes the complete report to reportPath
+func writeStructureTestsReport(reportPath string, suite junitTestSuite) error {
+
+	structureTestsReportMutex.Lock()
+	defer structureTestsReportMutex.Unlock()
+
+	structureTestsReport.TestSuites = append(structureTestsReport.TestSuites, suite)
+
+	data, err := xml.MarshalIndent(structureTestsReport, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(reportPath, append([]byte(xml.Header), data...), 0644)
+}
+
+// dockerStructureTestImage evaluates structure tests with the docker cli against a created, but not started container of the image
+type dockerStructureTestImage struct {
+	ctx           context.Context
+	containerPath string
+	containerID   string
+}
+
+func (i *dockerStructureTestImage) getConfig() (structureTestImageConfig, error) {
+	var config structureTestImageConfig
+	output, err := foundation.GetCommandWithArgsOutput(i.ctx, "docker", []string{"image", "inspect", "--format", "{{json .Config}}", i.containerPath})
+	if err != nil {
+		return config, fmt.Errorf("failed inspecting %v: %w", i.containerPath, err)
+	}
+	err = json.Unmarshal([]byte(strings.TrimSpace(output)), &config)
+	return config, err
+}
+
+func (i *dockerStructureTestImage) readFile(path string) ([]byte, bool, error) {
+
+	// docker cp works for images without shell as well
+	cmd := exec.CommandContext(i.ctx, "docker", "cp", fmt.Sprintf("%v:%v", i.containerID, path), "-")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		if strings.Contains(strings.ToLower(stderr.String()), "could not find the file") || strings.Contains(strings.ToLower(stderr.String()), "no such") {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed reading file %v: %v", path, strings.TrimSpace(stderr.String()))
+	}
+
+	reader := tar.NewReader(&stdout)
+	header, err := reader.Next()
+	if err != nil {
+		return nil, true, fmt.Errorf("failed reading file %v: %w", path, err)
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil, true, nil
+	}
+	content, err := io.ReadAll(reader)
+
+	return content, true, err
+}
+
+func (i *dockerStructureTestImage) runCommand(command []string) (string, int, error) {
+
+	args := []string{"run", "--rm", "--entrypoint", command[0], i.containerPath}
+	args = append(args, command[1:]...)
+
+	output, err := foundation.GetCommandWithArgsOutput(i.ctx, "docker", args)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return output, exitErr.ExitCode(), nil
+		}
+		return output, 0, err
+	}
+
+	return output, 0, nil
+}
+
+// runStructureTestsOnImage evaluates the tests against the built image, logs the outcome of every assertion, writes the JUnit report and returns an error if any assertion failed
+func runStructureTestsOnImage(ctx context.Context, container, containerPath string, tests []structureTest, reportPath string) error {
+
+	if *dryRun {
+		plan.addStep(fmt.Sprintf("run %v structure tests against %v", len(tests), containerPath), "", nil)
+		return nil
+	}
+
+	log.Info().Msgf("Running %v structure tests against container image %v...", len(tests), containerPath)
+	// the container never starts, the placeholder command only lets images without CMD or ENTRYPOINT - like FROM scratch - be created
+	output, err := foundation.GetCommandWithArgsOutput(ctx, "docker", []string{"create", containerPath, "structure-tests"})
+	if err != nil {
+		return fmt.Errorf("failed creating container for structure tests: %v", strings.TrimSpace(output))
+	}
+	containerID := strings.TrimSpace(output)
+	defer func() {
+		_, _ = foundation.GetCommandWithArgsOutput(context.Background(), "docker", []string{"rm", "--force", containerID})
+	}()
+
+	results := runStructureTests(&dockerStructureTestImage{ctx: ctx, containerPath: containerPath, containerID: containerID}, tests)
+
+	failures := 0
+	for _, r := range results {
+		if r.failure != "" {
+			failures++
+			log.Error().Msgf("- FAIL %v: %v", r.name, r.failure)
+		} else {
+			log.Info().Msgf("- PASS %v", r.name)
+		}
+	}
+
+	if reportPath != "" {
+		err = writeStructureTestsReport(reportPath, newJUnitTestSuite(container, results))
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed writing structure tests report to %v", reportPath)
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%v of %v structure test assertions failed for %v", failures, len(results), containerPath)
+	}
+
+	log.Info().Msgf("All %v structure test assertions passed for %v", len(results), containerPath)
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStructureTestImage struct {
+	config   structureTestImageConfig
+	files    map[string]string
+	commands map[string]struct {
+		output   string
+		exitCode int
+	}
+}
+
+func (i *fakeStructureTestImage) getConfig() (structureTestImageConfig, error) {
+	return i.config, nil
+}
+
+func (i *fakeStructureTestImage) readFile(path string) ([]byte, bool, error) {
+	content, ok := i.files[path]
+	return []byte(content), ok, nil
+}
+
+func (i *fakeStructureTestImage) runCommand(command []string) (string, int, error) {
+	result, ok := i.commands[strings.Join(command, " ")]
+	if !ok {
+		return "", 0, fmt.Errorf("unknown command %v", command)
+	}
+	return result.output, result.exitCode, nil
+}
+
+func TestParseStructureTests(t *testing.T) {
+	t.Run("ReturnsTestsFromYaml", func(t *testing.T) {
+
+		// act
+		tests, err := parseStructureTests("- name: binary\n  fileExists: /app\n- command: [/app, --version]\n  matches: ^1\\.", "")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(tests))
+		assert.Equal(t, "/app", tests[0].FileExists)
+		assert.Equal(t, []string{"/app", "--version"}, tests[1].Command)
+	})
+
+	t.Run("ReturnsTestsFromFile", func(t *testing.T) {
+
+		testsFilePath := filepath.Join(t.TempDir(), "tests.yaml")
+		assert.Nil(t, os.WriteFile(testsFilePath, []byte("tests:\n- user: nobody\n"), 0644))
+
+		// act
+		tests, err := parseStructureTests("", testsFilePath)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(tests))
+		assert.Equal(t, "nobody", *tests[0].User)
+	})
+
+	t.Run("ReturnsErrorIfBothTestsAndTestsFileAreSet", func(t *testing.T) {
+
+		// act
+		_, err := parseStructureTests("- fileExists: /app", "tests.yaml")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfMatchesIsInvalid", func(t *testing.T) {
+
+		// act
+		_, err := parseStructureTests("- fileContent: /etc/passwd\n  matches: '('", "")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfFileContentHasNoMatches", func(t *testing.T) {
+
+		// act
+		_, err := parseStructureTests("- fileContent: /etc/passwd", "")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestRunStructureTests(t *testing.T) {
+
+	user := "nobody"
+	image := &fakeStructureTestImage{
+		config: structureTestImageConfig{
+			Entrypoint:   []string{"/app"},
+			Env:          []string{"PATH=/usr/bin", "PORT=8080"},
+			User:         "nobody",
+			ExposedPorts: map[string]struct{}{"8080/tcp": {}},
+			Labels:       map[string]string{"org.opencontainers.image.version": "1.0.0"},
+		},
+		files: map[string]string{
+			"/app":        "",
+			"/etc/passwd": "root:x:0:0:root:/root:/bin/sh\nnobody:x:65534:65534:nobody:/:/sbin/nologin\n",
+		},
+		commands: map[string]struct {
+			output   string
+			exitCode int
+		}{
+			"/app --version": {output: "1.0.0\n"},
+			"/app --fail":    {output: "failed\n", exitCode: 2},
+		},
+	}
+
+	t.Run("ReturnsPassingResultForEveryAssertion", func(t *testing.T) {
+
+		tests := []structureTest{
+			{Name: "binary", FileExists: "/app", Command: []string{"/app", "--version"}, Matches: `^1\.0\.0`},
+			{FileAbsent: "/bin/sh"},
+			{FileContent: "/etc/passwd", Matches: "(?m)^nobody:"},
+			{Command: []string{"/app", "--fail"}, ExitCode: 2},
+			{Entrypoint: []string{"/app"}, Cmd: []string{}, Env: map[string]string{"PORT": "8080"}, User: &user, ExposedPorts: []string{"8080"}, Labels: map[string]string{"org.opencontainers.image.version": "1.0.0"}},
+		}
+
+		// act
+		results := runStructureTests(image, tests)
+
+		assert.Equal(t, 11, len(results))
+		assert.Equal(t, "binary: file /app exists", results[0].name)
+		assert.Equal(t, "binary: command /app --version exits with 0", results[1].name)
+		for _, r := range results {
+			assert.Equal(t, "", r.failure, r.name)
+		}
+	})
+
+	t.Run("ReturnsFailureForEveryFailingAssertion", func(t *testing.T) {
+
+		root := "root"
+		tests := []structureTest{
+			{FileExists: "/bin/sh", FileAbsent: "/app"},
+			{FileContent: "/etc/passwd", Matches: "(?m)^app:"},
+			{Command: []string{"/app", "--version"}, Matches: `^2\.`},
+			{Entrypoint: []string{"/bin/sh"}, Env: map[string]string{"PORT": "80", "HOST": "0.0.0.0"}, User: &root, ExposedPorts: []string{"80/tcp"}, Labels: map[string]string{"org.opencontainers.image.version": "2.0.0"}},
+		}
+
+		// act
+		results := runStructureTests(image, tests)
+
+		assert.Equal(t, 10, len(results))
+		assert.Equal(t, []string{
+			"file /bin/sh doesn't exist",
+			"file /app exists",
+			"content of file /etc/passwd doesn't match (?m)^app::",
+			"command output doesn't match ^2\\.:",
+			"entrypoint is [\"/app\"] instead of [\"/bin/sh\"]",
+			"env HOST isn't set",
+			"env PORT is 8080 instead of 80",
+			"user is \"nobody\" instead of \"root\"",
+			"port 80/tcp isn't exposed",
+			"label org.opencontainers.image.version is 1.0.0 instead of 2.0.0",
+		}, func() []string {
+			var failures []string
+			for _, r := range results {
+				failure, _, _ := strings.Cut(r.failure, "\n")
+				failures = append(failures, failure)
+			}
+			return failures
+		}())
+	})
+}
+
+func TestNewJUnitTestSuite(t *testing.T) {
+	t.Run("ReturnsTestCaseForEveryResultWithFailures", func(t *testing.T) {
+
+		results := []assertionResult{
+			{name: "file /app exists", duration: 1500 * time.Millisecond},
+			{name: "user is root", failure: "user is \"nobody\" instead of \"root\"\nsecond line", duration: 500 * time.Millisecond},
+		}
+
+		// act
+		suite := newJUnitTestSuite("extensions/docker", results)
+
+		assert.Equal(t, 2, suite.Tests)
+		assert.Equal(t, 1, suite.Failures)
+		assert.Equal(t, "2.000", suite.Time)
+		assert.Nil(t, suite.TestCases[0].Failure)
+		assert.Equal(t, "1.500", suite.TestCases[0].Time)
+		assert.Equal(t, "user is \"nobody\" instead of \"root\"", suite.TestCases[1].Failure.Message)
+
+		data, err := xml.Marshal(junitTestSuites{TestSuites: []junitTestSuite{suite}})
+		assert.Nil(t, err)
+		assert.Contains(t, string(data), `<testcase name="user is root" classname="extensions/docker" time="0.500"><failure message="user is &#34;nobody&#34; instead of &#34;root&#34;">`)
+	})
+}
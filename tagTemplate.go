@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/estafette/estafette-extension-docker/reference"
+)
+
+// templateData is the build metadata available in tag templates like `branch-{{ .Branch | tidyTag }}-{{ .ShortRevision }}`
+type templateData struct {
+	Branch        string
+	Revision      string
+	ShortRevision string
+	BuildDate     time.Time
+	Version       string
+	Major         string
+	Minor         string
+	Patch         string
+	PreRelease    string
+	Container     string
+}
+
+// newTemplateData collects build metadata from the environment variables set by Estafette
+func newTemplateData(container string) templateData {
+
+	data := templateData{
+		Branch:    os.Getenv("ESTAFETTE_GIT_BRANCH"),
+		Revision:  os.Getenv("ESTAFETTE_GIT_REVISION"),
+		Version:   os.Getenv("ESTAFETTE_BUILD_VERSION"),
+		Container: container,
+		BuildDate: time.Now().UTC(),
+	}
+
+	data.ShortRevision = data.Revision
+	if len(data.ShortRevision) > 7 {
+		data.ShortRevision = data.ShortRevision[:7]
+	}
+
+	if buildDate, err := time.Parse(time.RFC3339, os.Getenv("ESTAFETTE_BUILD_DATETIME")); err == nil {
+		data.BuildDate = buildDate.UTC()
+	}
+
+	if v, err := parseSemanticVersion(data.Version); err == nil {
+		data.Major = fmt.Sprint(v.major)
+		data.Minor = fmt.Sprint(v.minor)
+		data.Patch = fmt.Sprint(v.patch)
+		data.PreRelease = v.preRelease
+	}
+
+	return data
+}
+
+// templateFuncs are the helper functions available in templates
+var templateFuncs = template.FuncMap{
+	"tidyTag": tidyTag,
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": func(old, new, value string) string {
+		return strings.ReplaceAll(value, old, new)
+	},
+	"truncate": func(length int, value string) string {
+		if length >= 0 && len(value) > length {
+			return value[:length]
+		}
+		return value
+	},
+	"trimPrefix": func(prefix, value string) string {
+		return strings.TrimPrefix(value, prefix)
+	},
+	"trimSuffix": func(suffix, value string) string {
+		return strings.TrimSuffix(value, suffix)
+	},
+	"default": func(defaultValue, value string) string {
+		if value == "" {
+			return defaultValue
+		}
+		return value
+	},
+}
+
+// renderTag renders a tag template and validates the result against the docker tag grammar
+func renderTag(tag string, data templateData) (string, error) {
+
+	rendered := tag
+	if strings.Contains(tag, "{{") {
+		tmpl, err := template.New("tag").Funcs(templateFuncs).Option("missingkey=error").Parse(tag)
+		if err != nil {
+			return "", fmt.Errorf("invalid tag template %v: %w", tag, err)
+		}
+
+		var buffer bytes.Buffer
+		err = tmpl.Execute(&buffer, data)
+		if err != nil {
+			return "", fmt.Errorf("failed rendering tag template %v: %w", tag, err)
+		}
+		rendered = buffer.String()
+	}
+
+	if err := reference.ValidateTag(rendered); err != nil {
+		return "", fmt.Errorf("tag rendered from %q is invalid, use the tidyTag function to fix it: %w", tag, err)
+	}
+
+	return rendered, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderTag(t *testing.T) {
+
+	data := templateData{
+		Branch:        "feature/Add-Tags",
+		Revision:      "0f2c3a4b5d6e7f8091a2b3c4d5e6f708192a3b4c",
+		ShortRevision: "0f2c3a4",
+		BuildDate:     time.Date(2024, 6, 1, 13, 14, 15, 0, time.UTC),
+		Version:       "1.4.7",
+		Major:         "1",
+		Minor:         "4",
+		Patch:         "7",
+	}
+
+	t.Run("ReturnsStaticTagUnchanged", func(t *testing.T) {
+
+		// act
+		tag, err := renderTag("stable", data)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "stable", tag)
+	})
+
+	t.Run("ReturnsRenderedTagWithBranchAndShortRevision", func(t *testing.T) {
+
+		// act
+		tag, err := renderTag("branch-{{ .Branch | tidyTag | lower }}-{{ .ShortRevision }}", data)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "branch-feature-add-tags-0f2c3a4", tag)
+	})
+
+	t.Run("ReturnsRenderedTagWithVersionPartsAndBuildDate", func(t *testing.T) {
+
+		// act
+		tag, err := renderTag("{{ .Major }}.{{ .Minor }}-{{ .BuildDate.Format \"20060102\" }}", data)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "1.4-20240601", tag)
+	})
+
+	t.Run("ReturnsRenderedTagWithTruncateAndUpper", func(t *testing.T) {
+
+		// act
+		tag, err := renderTag("{{ .Revision | truncate 10 | upper }}", data)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "0F2C3A4B5D", tag)
+	})
+
+	t.Run("ReturnsErrorIfRenderedTagIsInvalid", func(t *testing.T) {
+
+		// act
+		_, err := renderTag("branch-{{ .Branch }}", data)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfTagStartsWithDash", func(t *testing.T) {
+
+		// act
+		_, err := renderTag("-stable", data)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForUnknownField", func(t *testing.T) {
+
+		// act
+		_, err := renderTag("{{ .Unknown }}", data)
+
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cpy "github.com/otiai10/copy"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// trivyCacheDirectory holds the vulnerability db in db/, the extension image comes with a db downloaded when it got built
+	trivyCacheDirectory = "/trivy-cache"
+
+	trivyDBSourceGCS       = "gcs"
+	trivyDBSourceBuiltin   = "builtin"
+	trivyDBSourceOCI       = "oci"
+	trivyDBSourceURL       = "url"
+	trivyDBSourceDirectory = "directory"
+)
+
+// trivyDBMetadata is the metadata.json trivy stores next to trivy.db
+type trivyDBMetadata struct {
+	Version      int       `json:"Version"`
+	NextUpdate   time.Time `json:"NextUpdate"`
+	UpdatedAt    time.Time `json:"UpdatedAt"`
+	DownloadedAt time.Time `json:"DownloadedAt"`
+}
+
+// getTrivyDBSourceType returns how to retrieve the trivy db from the source: the gcs bucket of the credentials (the default), the db built into the extension image, an oci artifact, an http(s) url or a local directory
+func getTrivyDBSourceType(source string) string {
+	switch {
+	case source == "" || source == trivyDBSourceGCS:
+		return trivyDBSourceGCS
+	case source == trivyDBSourceBuiltin:
+		return trivyDBSourceBuiltin
+	case strings.HasPrefix(source, "oci://"):
+		return trivyDBSourceOCI
+	case strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://"):
+		return trivyDBSourceURL
+	}
+	return trivyDBSourceDirectory
+}
+
+// validateTrivyDBSource returns an error if a url source has no valid sha256 checksum to verify the download with
+func validateTrivyDBSource(source, checksum string) error {
+
+	if getTrivyDBSourceType(source) != trivyDBSourceURL {
+		return nil
+	}
+	if checksum == "" {
+		return fmt.Errorf("Set `trivyDBChecksum:` to the sha256 checksum of the trivy db at %v", source)
+	}
+	if _, err := parseSHA256Checksum(checksum); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseSHA256Checksum returns the lowercase hex sha256 checksum, with or without sha256: prefix
+func parseSHA256Checksum(checksum string) (string, error) {
+
+	value := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(checksum), "sha256:"))
+	decoded, err := hex.DecodeString(value)
+	if err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("Trivy db checksum %v isn't a sha256 checksum", checksum)
+	}
+
+	return value, nil
+}
+
+// refreshTrivyDB retrieves the trivy db from the configured source into the trivy cache directory
+func refreshTrivyDB(ctx context.Context, credentials []ContainerRegistryCredentials, image buildImage) error {
+
+	source := os.ExpandEnv(*trivyDBSource)
+	dbDirectory := filepath.Join(trivyCacheDirectory, "db")
+
+	switch getTrivyDBSourceType(source) {
+	case trivyDBSourceBuiltin:
+		log.Info().Msg("Using the trivy db built into the extension image")
+		return nil
+
+	case trivyDBSourceGCS:
+		return downloadTrivyDBFromGCS(ctx, credentials, image)
+
+	case trivyDBSourceOCI:
+		repository := strings.TrimPrefix(source, "oci://")
+		loginIfRequired(ctx, credentials, false, repository)
+		log.Info().Msgf("Downloading trivy db from oci artifact %v...", repository)
+		return runCommandExtended(ctx, "download trivy db", "/trivy", []string{"--cache-dir", trivyCacheDirectory, "image", "--no-progress", "--download-db-only", "--db-repository", repository})
+
+	case trivyDBSourceURL:
+		log.Info().Msgf("Downloading trivy db from %v...", source)
+		if *dryRun {
+			plan.addStep(fmt.Sprintf("download trivy db from %v and verify checksum %v", source, *trivyDBChecksum), "", nil)
+			return runCommandExtended(ctx, "extract trivy db", "tar", []string{"-xzf", filepath.Join(os.TempDir(), "trivy-db.tar.gz"), "-C", dbDirectory})
+		}
+		archivePath, err := downloadTrivyDBArchive(ctx, source, *trivyDBChecksum)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(archivePath)
+		err = os.MkdirAll(dbDirectory, os.ModePerm)
+		if err != nil {
+			return err
+		}
+		return runCommandExtended(ctx, "extract trivy db", "tar", []string{"-xzf", archivePath, "-C", dbDirectory})
+	}
+
+	// a directory is either a trivy cache directory with a db subdirectory or the db directory itself
+	sourceDBDirectory := source
+	if ok, _ := pathExists(filepath.Join(source, "db", "metadata.json")); ok {
+		sourceDBDirectory = filepath.Join(source, "db")
+	} else if ok, _ := pathExists(filepath.Join(source, "metadata.json")); !ok {
+		return fmt.Errorf("Trivy db directory %v has no metadata.json, nor db/metadata.json", source)
+	}
+	log.Info().Msgf("Copying trivy db from %v...", sourceDBDirectory)
+	if *dryRun {
+		plan.addStep(fmt.Sprintf("copy trivy db from %v to %v", sourceDBDirectory, dbDirectory), "", nil)
+		return nil
+	}
+
+	return cpy.Copy(sourceDBDirectory, dbDirectory)
+}
+
+// downloadTrivyDBArchive downloads the db.tar.gz archive at the url to a temporary file and verifies its sha256 checksum
+func downloadTrivyDBArchive(ctx context.Context, url, checksum string) (string, error) {
+
+	expectedChecksum, err := parseSHA256Checksum(checksum)
+	if err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("Failed downloading trivy db from %v: %w", url, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed downloading trivy db from %v: status %v", url, response.Status)
+	}
+
+	archive, err := os.CreateTemp("", "trivy-db-*.tar.gz")
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(archive, hash), response.Body)
+	if err != nil {
+		os.Remove(archive.Name())
+		return "", fmt.Errorf("Failed downloading trivy db from %v: %w", url, err)
+	}
+
+	if actualChecksum := hex.EncodeToString(hash.Sum(nil)); actualChecksum != expectedChecksum {
+		os.Remove(archive.Name())
+		return "", fmt.Errorf("Trivy db from %v has checksum sha256:%v instead of sha256:%v", url, actualChecksum, expectedChecksum)
+	}
+
+	return archive.Name(), nil
+}
+
+// downloadTrivyDBFromGCS downloads the trivy cache from the gcs bucket configured on the credentials
+func downloadTrivyDBFromGCS(ctx context.Context, credentials []ContainerRegistryCredentials, image buildImage) error {
+
+	bucketName := ""
+	for i := range image.Repositories {
+		if credentials != nil && bucketName != credentials[i].AdditionalProperties.TrivyVulnerabilityDBGCSBucket {
+			credential := credentials[i]
+
+			if *dryRun {
+				plan.addStep(fmt.Sprintf("write service account keyfile of credentials %v to %v", credential.Name, os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")), "", nil)
+			} else {
+				pathDir := filepath.Dir(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+				if _, err := os.Stat(pathDir); os.IsNotExist(err) {
+					err = os.MkdirAll(pathDir, os.ModePerm)
+					if err != nil {
+						return fmt.Errorf("Failed creating directory: %w", err)
+					}
+				}
+				err := os.WriteFile(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), []byte(credential.AdditionalProperties.ServiceAccountKeyfile), 0666)
+				if err != nil {
+					return fmt.Errorf("Failed writing service account keyfile: %w", err)
+				}
+			}
+
+			var serviceAccountKeyFile struct {
+				ClientEmail string `json:"client_email"`
+			}
+			err := json.Unmarshal([]byte(credential.AdditionalProperties.ServiceAccountKeyfile), &serviceAccountKeyFile)
+			if err != nil {
+				return fmt.Errorf("Failed reading service account keyfile: %w", err)
+			}
+			log.Info().Msgf("Using service account to download Trivy db %v...", serviceAccountKeyFile.ClientEmail)
+
+			bucketName = credentials[i].AdditionalProperties.TrivyVulnerabilityDBGCSBucket
+
+			log.Info().Msg("Authenticating to google cloud")
+			err = runCommandExtended(ctx, "authenticate to google cloud", "gcloud", []string{"auth", "activate-service-account", serviceAccountKeyFile.ClientEmail, "--key-file", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")})
+			if err != nil {
+				return err
+			}
+
+			log.Info().Msg("Setting gcloud account")
+			err = runCommandExtended(ctx, "set gcloud account", "gcloud", []string{"config", "set", "account", serviceAccountKeyFile.ClientEmail})
+			if err != nil {
+				return err
+			}
+
+			log.Info().Msg("Setting gcloud project")
+			err = runCommandExtended(ctx, "set gcloud project", "gcloud", []string{"config", "set", "project", credentials[i].AdditionalProperties.TrivyVulnerabilityDBGCSProject})
+			if err != nil {
+				return err
+			}
+
+			err = runCommandExtended(ctx, "download trivy db", "gsutil", []string{"-m", "cp", "-r", fmt.Sprintf("gs://%v/trivy-cache/*", bucketName), trivyCacheDirectory})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readTrivyDBMetadata reads the metadata.json of the db in the trivy cache directory
+func readTrivyDBMetadata(cacheDirectory string) (trivyDBMetadata, error) {
+
+	var metadata trivyDBMetadata
+	data, err := os.ReadFile(filepath.Join(cacheDirectory, "db", "metadata.json"))
+	if err != nil {
+		return metadata, fmt.Errorf("Failed reading trivy db metadata: %w", err)
+	}
+	err = json.Unmarshal(data, &metadata)
+	if err != nil {
+		return metadata, fmt.Errorf("Failed unmarshalling trivy db metadata: %w", err)
+	}
+
+	return metadata, nil
+}
+
+// getTrivyDBAge returns how long ago the db got updated; a db without update time counts as infinitely old
+func getTrivyDBAge(metadata trivyDBMetadata, now time.Time) time.Duration {
+	if metadata.UpdatedAt.IsZero() {
+		return time.Duration(1<<63 - 1)
+	}
+	return now.Sub(metadata.UpdatedAt)
+}
+
+// checkTrivyDBFreshness warns or returns an error when the trivy db is older than the maximum age
+func checkTrivyDBFreshness(cacheDirectory string, maxAge time.Duration, now time.Time) error {
+
+	if *trivyDBFreshness == "ignore" || maxAge <= 0 || *dryRun {
+		return nil
+	}
+
+	metadata, err := readTrivyDBMetadata(cacheDirectory)
+	if err != nil {
+		if *trivyDBFreshness == "fail" {
+			return err
+		}
+		log.Warn().Err(err).Msg("Can't check the age of the trivy db")
+		return nil
+	}
+
+	age := getTrivyDBAge(metadata, now)
+	log.Info().Msgf("Trivy db got updated at %v", metadata.UpdatedAt.Format(time.RFC3339))
+	if age <= maxAge {
+		return nil
+	}
+
+	message := fmt.Sprintf("The trivy db got updated at %v, more than %v days ago; vulnerabilities published since are missed", metadata.UpdatedAt.Format(time.RFC3339), int(maxAge.Hours()/24))
+	if *trivyDBFreshness == "fail" {
+		return fmt.Errorf("%v", message)
+	}
+	log.Warn().Msg(message)
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTrivyDBSourceType(t *testing.T) {
+	t.Run("ReturnsTypeOfSource", func(t *testing.T) {
+
+		assert.Equal(t, trivyDBSourceGCS, getTrivyDBSourceType(""))
+		assert.Equal(t, trivyDBSourceGCS, getTrivyDBSourceType("gcs"))
+		assert.Equal(t, trivyDBSourceBuiltin, getTrivyDBSourceType("builtin"))
+		assert.Equal(t, trivyDBSourceOCI, getTrivyDBSourceType("oci://eu.gcr.io/estafette/trivy-db:2"))
+		assert.Equal(t, trivyDBSourceURL, getTrivyDBSourceType("https://artifacts.example.com/trivy/db.tar.gz"))
+		assert.Equal(t, trivyDBSourceDirectory, getTrivyDBSourceType("/mnt/trivy-cache"))
+	})
+}
+
+func TestValidateTrivyDBSource(t *testing.T) {
+	t.Run("ReturnsErrorForUrlWithoutChecksum", func(t *testing.T) {
+
+		// act
+		err := validateTrivyDBSource("https://artifacts.example.com/trivy/db.tar.gz", "")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForInvalidChecksum", func(t *testing.T) {
+
+		// act
+		err := validateTrivyDBSource("https://artifacts.example.com/trivy/db.tar.gz", "sha256:abc")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsNilForUrlWithChecksum", func(t *testing.T) {
+
+		// act
+		err := validateTrivyDBSource("https://artifacts.example.com/trivy/db.tar.gz", "sha256:E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855")
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsNilForOtherSourcesWithoutChecksum", func(t *testing.T) {
+
+		// act
+		err := validateTrivyDBSource("oci://eu.gcr.io/estafette/trivy-db:2", "")
+
+		assert.Nil(t, err)
+	})
+}
+
+func TestDownloadTrivyDBArchive(t *testing.T) {
+
+	content := []byte("trivy db archive")
+	hash := sha256.Sum256(content)
+	checksum := hex.EncodeToString(hash[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/db.tar.gz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	t.Run("ReturnsPathOfDownloadedArchiveWithMatchingChecksum", func(t *testing.T) {
+
+		// act
+		archivePath, err := downloadTrivyDBArchive(context.Background(), server.URL+"/db.tar.gz", "sha256:"+checksum)
+
+		assert.Nil(t, err)
+		defer os.Remove(archivePath)
+		data, _ := os.ReadFile(archivePath)
+		assert.Equal(t, content, data)
+	})
+
+	t.Run("ReturnsErrorForChecksumMismatch", func(t *testing.T) {
+
+		// act
+		_, err := downloadTrivyDBArchive(context.Background(), server.URL+"/db.tar.gz", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), fmt.Sprintf("checksum sha256:%v", checksum))
+	})
+
+	t.Run("ReturnsErrorForUnsuccessfulResponse", func(t *testing.T) {
+
+		// act
+		_, err := downloadTrivyDBArchive(context.Background(), server.URL+"/missing.tar.gz", checksum)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCheckTrivyDBFreshness(t *testing.T) {
+
+	cacheDirectory := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(cacheDirectory, "db"), os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(cacheDirectory, "db", "metadata.json"), []byte(`{"Version":2,"NextUpdate":"2026-10-10T06:00:00Z","UpdatedAt":"2026-10-10T00:00:00Z","DownloadedAt":"2026-10-10T01:00:00Z"}`), 0644))
+	now := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+
+	t.Run("ReturnsNilForDBWithinMaxAge", func(t *testing.T) {
+
+		originalFreshness := *trivyDBFreshness
+		defer func() { *trivyDBFreshness = originalFreshness }()
+		*trivyDBFreshness = "fail"
+
+		// act
+		err := checkTrivyDBFreshness(cacheDirectory, 10*24*time.Hour, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorForDBOlderThanMaxAgeIfFailing", func(t *testing.T) {
+
+		originalFreshness := *trivyDBFreshness
+		defer func() { *trivyDBFreshness = originalFreshness }()
+		*trivyDBFreshness = "fail"
+
+		// act
+		err := checkTrivyDBFreshness(cacheDirectory, 7*24*time.Hour, now)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "more than 7 days ago")
+	})
+
+	t.Run("ReturnsNilForDBOlderThanMaxAgeIfWarning", func(t *testing.T) {
+
+		originalFreshness := *trivyDBFreshness
+		defer func() { *trivyDBFreshness = originalFreshness }()
+		*trivyDBFreshness = "warn"
+
+		// act
+		err := checkTrivyDBFreshness(cacheDirectory, 7*24*time.Hour, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorForMissingMetadataIfFailing", func(t *testing.T) {
+
+		originalFreshness := *trivyDBFreshness
+		defer func() { *trivyDBFreshness = originalFreshness }()
+		*trivyDBFreshness = "fail"
+
+		// act
+		err := checkTrivyDBFreshness(t.TempDir(), 7*24*time.Hour, now)
+
+		assert.NotNil(t, err)
+	})
+}